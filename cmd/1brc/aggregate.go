@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math"
+	"sync/atomic"
+
+	"example.com/mod/internal/parse"
+	"example.com/mod/internal/stats"
+	"example.com/mod/onebrc"
+)
+
+// Number of distinct stations reported by -aggregator runs whose aggregator keeps no stations,
+// summed over the inputs
+var aggregatedDistinct atomic.Uint64
+
+// Error an onebrc.Process run is stopped with once -onError fail saw a malformed line, which
+// parseFailure reports after the inputs
+var errStopReading = errors.New("stopped on a malformed line")
+
+// Aggregator folding the readings of onebrc.Process into a stats table computing what the flags
+// select, so the streamed inputs end up in the same tables as the ones read in byte ranges
+type tableAggregator struct {
+	table     *stats.Table
+	rows      int64         // Rows added since they were last counted in rowsRead
+	published *atomic.Int64 // Rows the aggregators of the stream counted in rowsRead so far
+}
+
+// Function to fold a reading into the stats of its station
+func (a *tableAggregator) Add(name []byte, value float64) {
+	a.AddWeighted(name, value, 1)
+}
+
+// Function to fold a reading into the stats of its station weight times, the readings of
+// onebrc.Process being exact tenths
+func (a *tableAggregator) AddWeighted(name []byte, value float64, weight int) {
+	a.table.Update(parse.ByteString(name), int16(math.Round(value*10)), weight)
+
+	// Count the rows a batch at a time, so the progress of a stream moves while it is read
+	if a.rows++; a.rows >= int64(batchSize) {
+		atomic.AddInt64(&rowsRead, a.rows)
+		a.published.Add(a.rows)
+		a.rows = 0
+	}
+}
+
+// Function to fold the table of another worker into this one
+func (a *tableAggregator) Merge(other onebrc.Aggregator) {
+	a.table.MergeTable(other.(*tableAggregator).table)
+}
+
+// Function to return nothing, the merged table is read directly by readProcessed
+func (a *tableAggregator) Results() onebrc.Results {
+	return onebrc.Results{}
+}
+
+// Function to read the lines of a decoded stream through onebrc.Process with the parsing flags.
+// The built-in tables are the aggregators of its workers unless -aggregator names a registered
+// one, whose stations are folded into a stats table so the usual outputs print them; the
+// fields it does not keep stay zero
+func readProcessed(ctx context.Context, r io.Reader) error {
+	opts := onebrc.Options{
+		Delimiter:     lineParser.Delimiter,
+		Quoted:        quoted,
+		Format:        inputFormat,
+		NameField:     nameField,
+		ValueField:    valueField,
+		Columns:       &onebrc.Columns{Group: groupColumns, Value: valueCol, Weight: weightCol},
+		Unit:          inputUnit,
+		Aliases:       lineParser.Aliases,
+		Workers:       workers,
+		BatchLines:    batchSize,
+		BufferSize:    readBuffer,
+		MaxLineLength: maxLineLength,
+		SkipLines:     skipLines,
+		PlainText:     true,
+		Aggregator:    aggregatorName,
+		OnMalformed: func(line []byte, offset int64, err error) error {
+			reportParseError(err, parse.ByteString(line), offset)
+			if stopReading.Load() {
+				return errStopReading
+			}
+			return nil
+		},
+	}
+	if r := lineParser.Range; r != nil {
+		opts.Range = &onebrc.Range{Min: r.Min, Max: r.Max, Keep: r.Keep}
+	}
+
+	// The workers' aggregators are merged into the first one created
+	var merged *tableAggregator
+	var published atomic.Int64
+	if aggregatorName == "" {
+		opts.NewAggregator = func() onebrc.Aggregator {
+			a := &tableAggregator{table: stats.NewTable(tableOptions()), published: &published}
+			if merged == nil {
+				merged = a
+			}
+			return a
+		}
+	}
+
+	results, err := onebrc.Process(ctx, r, opts)
+	atomic.AddInt64(&rowsRead, results.Rows-published.Load())
+	if lineParser.Range != nil {
+		lineParser.Range.Violations.Add(results.OutOfRange)
+	}
+	lineParser.Remapped.Add(results.Remapped)
+	if lineParser.Delimiter == "auto" && results.Delimiter != "auto" {
+		lineParser.Delimiter = results.Delimiter
+		if explain {
+			slog.Info("detected delimiter", "delimiter", results.Delimiter)
+		}
+	}
+
+	// What was aggregated before a cancellation or a failing line is kept
+	switch {
+	case merged != nil:
+		workerTables.Add(merged.table)
+	case len(results.Stations) == 0:
+		aggregatedDistinct.Add(results.Distinct)
+	default:
+		table := workerTables.NewTable()
+		for _, station := range results.Stations {
+			table.Merge(station.Name, stats.NameStats{
+				Min:   int16(math.Round(station.Min * 10)),
+				Max:   int16(math.Round(station.Max * 10)),
+				Sum:   int64(math.Round(station.Sum * 10)),
+				Count: int(station.Count),
+			})
+		}
+		table.Rows += results.Rows
+		workerTables.Release(table)
+	}
+	if errors.Is(err, errStopReading) || ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// Struct to count the bytes read through an io.Reader for the progress line and the summary
+type countingReader struct {
+	r io.Reader
+}
+
+// Function to read from the wrapped reader, counting the bytes read
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	countBytesRead(int64(n))
+	return n, err
+}
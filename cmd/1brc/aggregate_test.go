@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Function to write input gzipped to a file in a temporary directory of the test, returning its path
+func writeGzippedInput(t *testing.T, name, input string) string {
+	t.Helper()
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write([]byte(input))
+	zw.Close()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, compressed.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Checks that -aggregator runs read through onebrc.Process honour the unit, the aliases and the
+// compression of the input like the built-in tables
+func TestAggregatorFlags(t *testing.T) {
+	aliases := writeTestInput(t, "aliases.txt", "NYC;New York City\nNew York;New York City\n")
+	path := writeGzippedInput(t, "measurements.txt.gz", "NYC;50\nNew York;32\nHamburg;212\nHamburg;32\n")
+
+	tests := []struct {
+		aggregator, aggs, want string
+	}{
+		{"stats", "min,max,mean,count", "station,min,max,mean,count\nHamburg,0.00,100.00,50.00,2\nNew York City,0.00,10.00,5.00,2\n"},
+		{"sum", "count,sum", "station,count,sum\nHamburg,2,100.00\nNew York City,2,10.00\n"},
+		{"count", "count", "station,count\nHamburg,2\nNew York City,2\n"},
+	}
+	for _, test := range tests {
+		got, code := runCLI(t, "-file", path, "-aggregator", test.aggregator, "-input-unit", "F", "-alias-file", aliases,
+			"-aggs", test.aggs, "-output", "csv")
+		if code != 0 || string(got) != test.want {
+			t.Errorf("-aggregator %s exited with %d and printed\n%s\nwant\n%s", test.aggregator, code, got, test.want)
+		}
+	}
+}
+
+// Checks that a gzipped input, streamed through onebrc.Process, prints what the same input read in
+// byte ranges prints, down to the spread and the percentiles
+func TestCompressedMatchesPlain(t *testing.T) {
+	var input bytes.Buffer
+	for i := range 500 {
+		input.WriteString([]string{"Hamburg", "Bulawayo", "Palembang"}[i%3])
+		input.WriteString([]string{";12.3\n", ";-4.5\n", ";30.0\n", ";0.7\n", ";18.9\n"}[i%5])
+	}
+	plain := writeTestInput(t, "measurements.txt", input.String())
+	compressed := writeGzippedInput(t, "measurements.txt.gz", input.String())
+
+	args := []string{"-stddev", "-percentiles", "50,90", "-workers", "3", "-batchSize", "7", "-output", "csv"}
+	want, code := runCLI(t, append([]string{"-file", plain}, args...)...)
+	if code != 0 {
+		t.Fatalf("plain input exited with %d", code)
+	}
+	got, code := runCLI(t, append([]string{"-file", compressed}, args...)...)
+	if code != 0 || !bytes.Equal(got, want) {
+		t.Errorf("gzipped input exited with %d and printed\n%s\nwant\n%s", code, got, want)
+	}
+}
+
+// Checks that -onError fail stops a streamed input on its first malformed line and exits 1
+func TestCompressedFailsOnMalformed(t *testing.T) {
+	path := writeGzippedInput(t, "measurements.txt.gz", "Hamburg;12.0\nno delimiter\nBulawayo;8.9\n")
+	for _, args := range [][]string{{"-file", path, "-onError", "fail"}, {"-file", path, "-onError", "fail", "-aggregator", "stats"}} {
+		if _, stderr, code := runCLIOutput(t, args...); code != 1 || !bytes.Contains(stderr, []byte("no delimiter")) {
+			t.Errorf("%v exited with %d, want 1 reporting the malformed line:\n%s", args, code, stderr)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Checks that three aliases of a station merge into its canonical name, the remapped rows being
+// counted under -summary, and that names without an alias pass through
+func TestAliases(t *testing.T) {
+	aliases := writeTestInput(t, "aliases.txt", "# raw;canonical\nNYC;New York City\n  New York ; New York City\n\nNY;New York City\n")
+	path := writeTestInput(t, "measurements.txt", "NYC;10.0\nNew York;-2.0\nNY;4.0\nHamburg;12.0\nNew York City;6.0\nNYC;0.5\n")
+
+	got, stderr, code := runCLIOutput(t, "-file", path, "-alias-file", aliases, "-summary", "-aggs", "min,max,mean,count", "-output", "csv")
+	want := "station,min,max,mean,count\nHamburg,12.00,12.00,12.00,1\nNew York City,-2.00,10.00,3.70,5\n"
+	if code != 0 || string(got) != want {
+		t.Errorf("exited with %d and printed\n%s\nwant\n%s", code, got, want)
+	}
+	if !strings.Contains(string(stderr), "Remapped rows:     4\n") {
+		t.Errorf("summary does not count the 4 remapped rows:\n%s", stderr)
+	}
+}
+
+func TestLoadAliases(t *testing.T) {
+	path := writeTestInput(t, "aliases.txt", "# comment\nNYC;New York City\n\n Bombay ; Mumbai \n")
+	got, err := loadAliases(path)
+	if want := map[string]string{"NYC": "New York City", "Bombay": "Mumbai"}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("loadAliases = %v, %v, want %v", got, err, want)
+	}
+
+	for _, invalid := range []string{"NYC\n", "NYC;New York;City\n", ";Mumbai\n", "Bombay; \n"} {
+		path := writeTestInput(t, "aliases.txt", "# comment\n"+invalid)
+		if _, err := loadAliases(path); err == nil || !strings.Contains(err.Error(), "aliases.txt:2: invalid alias") {
+			t.Errorf("loadAliases of %q = %v, want an invalid alias error on line 2", invalid, err)
+		}
+	}
+}
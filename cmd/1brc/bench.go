@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"example.com/mod/internal/parse"
+	"example.com/mod/internal/stats"
+)
+
+// Phases a -bench run is timed in
+var benchPhases = []string{"read", "parse", "aggregate", "output"}
+
+// Timings of one -bench run, one per phase in benchPhases order
+type benchTimings []time.Duration
+
+// Function to run the -bench loop over a local file and print the min and median time of
+// every phase and of the whole run to w
+func runBench(w io.Writer, path string, runs int) error {
+	if path == "-" || isRemote(path) {
+		return errors.New("-bench needs a local file")
+	}
+	compressed, utf16, err := sniffInputFile(path)
+	if err != nil {
+		return err
+	}
+	if compressed || utf16 || inputFormat == "csv" || inputFormat == "parquet" {
+		return errors.New("-bench only supports uncompressed UTF-8 text or jsonl files")
+	}
+
+	var all []benchTimings
+	var buffer []byte
+	for range runs {
+		timings, err := benchOnce(path, &buffer)
+		if err != nil {
+			return err
+		}
+		all = append(all, timings)
+	}
+	return printBench(w, path, all)
+}
+
+// Function to time one run over the file: reading it as the run does, memory-mapped with -mmap
+// or in newline-aligned ranges through positioned reads into buffer (kept for the next run),
+// parsing every line alone, parsing and folding the lines into the tables (the time of parsing
+// alone taken off so only the aggregation remains) and merging them, and formatting the results
+func benchOnce(path string, buffer *[]byte) (benchTimings, error) {
+	resetRun()
+	timings := make(benchTimings, len(benchPhases))
+
+	start := time.Now()
+	data, chunks, release, err := readBenchInput(path, buffer)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	timings[0] = time.Since(start)
+
+	start = time.Now()
+	eachChunk(chunks, func(c chunk) { parseMapped(data[c.start:c.end]) })
+	timings[1] = time.Since(start)
+
+	// Parsing alone ran through the aliases, count them again from scratch
+	resetRun()
+	start = time.Now()
+	eachChunk(chunks, func(c chunk) {
+		table := workerTables.NewTable()
+		defer workerTables.Release(table)
+		processBlock(context.Background(), table, data[c.start:c.end], c.start, 0)
+	})
+	if !estimateDistinct {
+		statsShards = workerTables.Merge()
+	}
+	timings[2] = max(time.Since(start)-timings[1], 0)
+
+	start = time.Now()
+	if err := writeResults(io.Discard); err != nil {
+		return nil, err
+	}
+	timings[3] = time.Since(start)
+	return timings, nil
+}
+
+// Function to read a file for a -bench run the way readMapped and readRanges do, returning its
+// bytes, the newline-aligned ranges of its measurements and a function releasing the mapping
+func readBenchInput(path string, buffer *[]byte) ([]byte, []chunk, func() error, error) {
+	if useMmap {
+		data, unmap, err := mmapFile(path)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("mapping file: %w", err)
+		}
+		chunks, err := planRanges(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			unmap()
+			return nil, nil, nil, err
+		}
+		return data, chunks, unmap, nil
+	}
+
+	file, err := openInputFile(path)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("opening file: %w", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	chunks, err := planRanges(file, info.Size())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Every range is read by a goroutine of its own, as readRanges does
+	if int64(cap(*buffer)) < info.Size() {
+		*buffer = make([]byte, info.Size())
+	}
+	data := (*buffer)[:info.Size()]
+	errs := make([]error, len(chunks))
+	var wg sync.WaitGroup
+	for i, c := range chunks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = file.ReadAt(data[c.start:c.end], c.start)
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, nil, fmt.Errorf("reading file: %w", err)
+	}
+	return data, chunks, func() error { return nil }, nil
+}
+
+// Function to run fn over every chunk in its own goroutine and wait for all of them
+func eachChunk(chunks []chunk, fn func(c chunk)) {
+	var wg sync.WaitGroup
+	for _, c := range chunks {
+		wg.Add(1)
+		go func(c chunk) {
+			defer wg.Done()
+			fn(c)
+		}(c)
+	}
+	wg.Wait()
+}
+
+// Function to parse the lines of an in-memory range without folding them anywhere
+func parseMapped(data []byte) {
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, '\n')
+		var line []byte
+		if end < 0 {
+			line, data = data, nil
+		} else {
+			line, data = data[:end], data[end+1:]
+		}
+		lineParser.ParseLine(parse.ByteString(line))
+	}
+}
+
+// Function to forget the stats and counters of a previous run
+func resetRun() {
+	workerTables = stats.NewSet(tableOptions())
+	statsShards = [stats.ShardCount]*stats.Table{}
+
+	atomic.StoreInt64(&malformedLines, 0)
+	lineParser.Remapped.Store(0)
+	if lineParser.Range != nil {
+		lineParser.Range.Violations.Store(0)
+	}
+	atomic.StoreInt64(&bytesRead, 0)
+	errorMutex.Lock()
+	firstParseError, collectedErrors = nil, nil
+	errorMutex.Unlock()
+	stopReading.Store(false)
+}
+
+// Function to print the min and median time of every phase and of the total over all runs
+func printBench(w io.Writer, path string, all []benchTimings) error {
+	if _, err := fmt.Fprintf(w, "Benchmark: %s, runs: %d, workers: %d\n", path, len(all), workers); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%-10s %12s %12s\n", "phase", "min", "median"); err != nil {
+		return err
+	}
+
+	row := func(name string, durations []time.Duration) error {
+		slices.Sort(durations)
+		_, err := fmt.Fprintf(w, "%-10s %12s %12s\n", name, durations[0].Round(time.Microsecond), median(durations).Round(time.Microsecond))
+		return err
+	}
+	totals := make([]time.Duration, len(all))
+	for phase, name := range benchPhases {
+		durations := make([]time.Duration, len(all))
+		for i, timings := range all {
+			durations[i] = timings[phase]
+			totals[i] += timings[phase]
+		}
+		if err := row(name, durations); err != nil {
+			return err
+		}
+	}
+	return row("total", totals)
+}
+
+// Function to find the median of sorted durations, averaging the middle two of an even number
+func median(sorted []time.Duration) time.Duration {
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Checks that -bench-parsers times both value parsers and recommends the fixed-point fast path on
+// clean one-decimal readings, ParseFloat once some readings are not of the 1BRC shape
+func TestBenchParsers(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"clean", testMeasurements(2000), "fixed-point"},
+		{"mixed", testMeasurements(2000) + "Hamburg;12.25\nBulawayo;3\n", "ParseFloat"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestInput(t, "measurements.txt", tt.input)
+			got, code := runCLI(t, "-file", path, "-bench-parsers", "5000")
+			if code != 0 {
+				t.Fatalf("run exited with %d", code)
+			}
+			for _, parser := range []string{"fixed-point", "ParseFloat"} {
+				if !regexp.MustCompile(`(?m)^` + parser + ` +\d+\.\d \s+\d+/\d+$`).Match(got) {
+					t.Errorf("printed\n%s\nwant an ns/line row for %s", got, parser)
+				}
+			}
+			if !strings.Contains(string(got), "recommended: "+tt.want+" (") {
+				t.Errorf("printed\n%s\nwant %s recommended", got, tt.want)
+			}
+		})
+	}
+
+	path := writeTestInput(t, "measurements.txt", testMeasurements(10))
+	for _, args := range [][]string{{"-bench-parsers", "-1"}, {"-bench-parsers", "5", "-bench", "2"}, {"-bench-parsers", "5", "-format", "jsonl"}} {
+		want := 2
+		if args[len(args)-1] == "jsonl" {
+			want = 1
+		}
+		if _, code := runCLI(t, append([]string{"-file", path}, args...)...); code != want {
+			t.Errorf("%v exited with %d, want %d", args, code, want)
+		}
+	}
+}
+
+// Checks that the bench command prints a min and median time for every phase and the total over
+// -runs runs, reading the file in ranges or memory-mapped
+func TestBench(t *testing.T) {
+	path := writeTestInput(t, "measurements.txt", testMeasurements(100))
+	for _, args := range [][]string{{"-workers", "1"}, {"-workers", "3"}, {"-mmap", "-workers", "2"}} {
+		t.Run(strings.Join(args, " "), func(t *testing.T) {
+			got, code := runCLI(t, append([]string{"bench", "-file", path, "-runs", "2"}, args...)...)
+			if code != 0 {
+				t.Fatalf("bench exited with %d", code)
+			}
+			lines := strings.Split(strings.TrimSuffix(string(got), "\n"), "\n")
+			header := fmt.Sprintf("Benchmark: %s, runs: 2, workers: %s", path, args[len(args)-1])
+			if len(lines) != len(benchPhases)+3 || lines[0] != header || strings.Fields(lines[1])[0] != "phase" {
+				t.Fatalf("bench printed\n%s\nwant %q, the column names and a line per phase and the total", got, header)
+			}
+			for i, phase := range append(benchPhases, "total") {
+				fields := strings.Fields(lines[i+2])
+				if len(fields) != 3 || fields[0] != phase {
+					t.Errorf("line %q, want the min and median of %s", lines[i+2], phase)
+					continue
+				}
+				low, lowErr := time.ParseDuration(fields[1])
+				median, medianErr := time.ParseDuration(fields[2])
+				if lowErr != nil || medianErr != nil || low > median {
+					t.Errorf("line %q does not hold a min up to the median", lines[i+2])
+				}
+			}
+		})
+	}
+}
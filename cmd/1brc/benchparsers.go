@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/mod/internal/parse"
+	"example.com/mod/internal/reader"
+)
+
+// Shortest time each value parser is timed for by -bench-parsers, repeating the readings as needed
+const parserBenchTime = 100 * time.Millisecond
+
+// Struct to hold a value parser -bench-parsers times, turning a number field into tenths
+type valueParser struct {
+	name  string
+	parse func(s string) (int16, bool)
+}
+
+// Value parsers of ParseReading, the fixed-point fast path it tries first and the ParseFloat
+// fallback every reading it rejects goes to; ParseFloat must stay last, it is the reference
+var valueParsers = []valueParser{
+	{"fixed-point", parse.Tenths},
+	{"ParseFloat", func(s string) (int16, bool) {
+		number, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, false
+		}
+		return parse.RoundTenths(number)
+	}},
+}
+
+// Function to run the number fields of the first lines of a local file through every value
+// parser for -bench-parsers, printing the ns per line of each and recommending the fastest one
+// that reads every reading as ParseFloat does
+func runParserBench(w io.Writer, path string, lines int) error {
+	if path == "-" || isRemote(path) {
+		return errors.New("-bench-parsers needs a local file")
+	}
+	compressed, utf16, err := sniffInputFile(path)
+	if err != nil {
+		return err
+	}
+	if compressed || utf16 || inputFormat != "text" {
+		return errors.New("-bench-parsers only supports uncompressed UTF-8 text files")
+	}
+
+	numbers, err := readNumberFields(path, lines)
+	if err != nil {
+		return err
+	}
+	if len(numbers) == 0 {
+		return errors.New("no readings to parse")
+	}
+
+	// Readings ParseFloat rejects are malformed whatever the parser, they are left out
+	reference := valueParsers[len(valueParsers)-1]
+	want := make([]int16, 0, len(numbers))
+	valid := numbers[:0]
+	for _, number := range numbers {
+		if tenths, ok := reference.parse(number); ok {
+			want = append(want, tenths)
+			valid = append(valid, number)
+		}
+	}
+
+	if _, err := fmt.Fprintf(w, "Parser benchmark: %s, lines: %d, readings: %d\n", path, len(numbers), len(valid)); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%-12s %10s %16s\n", "parser", "ns/line", "parsed"); err != nil {
+		return err
+	}
+	recommended, fastest := "", math.Inf(1)
+	for _, p := range valueParsers {
+		parsed := 0
+		for i, number := range valid {
+			if tenths, ok := p.parse(number); ok && tenths == want[i] {
+				parsed++
+			}
+		}
+		perLine := timeParser(p, valid)
+		if _, err := fmt.Fprintf(w, "%-12s %10.1f %16s\n", p.name, perLine, fmt.Sprintf("%d/%d", parsed, len(valid))); err != nil {
+			return err
+		}
+		if parsed == len(valid) && perLine < fastest {
+			recommended, fastest = p.name, perLine
+		}
+	}
+
+	reason := "the fastest parser agreeing with ParseFloat on every reading"
+	if recommended != valueParsers[0].name {
+		reason += ", the run falls back to it for readings not of the 1BRC shape"
+	} else if lineParser.Unit != "C" {
+		reason += ", but -input-unit " + lineParser.Unit + " converts every reading through ParseFloat"
+	}
+	_, err = fmt.Fprintf(w, "recommended: %s (%s)\n", recommended, reason)
+	return err
+}
+
+// Function to read the trimmed number fields of the first lines of a file, as the run splits them
+func readNumberFields(path string, lines int) ([]string, error) {
+	file, err := openInputFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening file: %w", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	start, err := findDataStart(file, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	if err := detectFromFirstLine(file, start, info.Size()); err != nil {
+		return nil, err
+	}
+
+	var numbers []string
+	scanner, _ := reader.NewLineReader(io.NewSectionReader(file, start, info.Size()-start), start, readBuffer, maxLineLength)
+	for i := 0; i < lines && scanner.Scan(); i++ {
+		fields, err := lineParser.SplitFields(string(scanner.Bytes()), lineParser.Delimiter)
+		if err != nil || len(fields) <= lineParser.ValueColumn {
+			continue
+		}
+		numbers = append(numbers, strings.TrimSpace(fields[lineParser.ValueColumn]))
+	}
+	return numbers, scanner.Err()
+}
+
+// Function to time a value parser over the readings, repeating them for at least parserBenchTime,
+// returning the nanoseconds per reading
+func timeParser(p valueParser, numbers []string) float64 {
+	parsed := 0
+	start := time.Now()
+	for time.Since(start) < parserBenchTime {
+		for _, number := range numbers {
+			p.parse(number)
+		}
+		parsed += len(numbers)
+	}
+	return float64(time.Since(start).Nanoseconds()) / float64(parsed)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"example.com/mod/internal/stats"
+)
+
+// Struct to periodically save the progress of a run so it can be resumed
+type checkpointer struct {
+	// Readers hold mu shared while folding lines in, saving a checkpoint holds it exclusively
+	mu    sync.RWMutex
+	path  string
+	state checkpointState
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// Struct to hold everything written to the checkpoint file
+type checkpointState struct {
+	Delimiter string                     `json:"delimiter"`
+	Completed []string                   `json:"completed"`
+	Current   *fileProgress              `json:"current,omitempty"`
+	Stats     map[string]checkpointStats `json:"stats"`
+	Counters  checkpointCounters         `json:"counters"`
+}
+
+// Struct to hold the counters of the summary and the warnings, so a resumed run reports the
+// same totals as an uninterrupted one
+type checkpointCounters struct {
+	Rows       int64 `json:"rows"`
+	Malformed  int64 `json:"malformed"`
+	OutOfRange int64 `json:"outOfRange"`
+	Remapped   int64 `json:"remapped"`
+	BytesRead  int64 `json:"bytesRead"`
+}
+
+// Struct to describe how far the ranges of the file being read have been processed
+type fileProgress struct {
+	Path    string          `json:"path"`
+	Size    int64           `json:"size"`
+	ModTime time.Time       `json:"modTime"`
+	Chunks  []chunkProgress `json:"chunks"`
+}
+
+// Struct to describe a range of the input and the offset up to which its lines are in the stats
+type chunkProgress struct {
+	Start  int64 `json:"start"`
+	End    int64 `json:"end"`
+	Offset int64 `json:"offset"`
+}
+
+// Struct to hold stats.NameStats in a serializable form, values in tenths
+type checkpointStats struct {
+	Min            int16   `json:"min"`
+	Max            int16   `json:"max"`
+	Sum            int64   `json:"sum"`
+	SumHigh        int64   `json:"sumHigh,omitempty"`
+	SumSquares     int64   `json:"sumSquares"`
+	SumSquaresHigh int64   `json:"sumSquaresHigh,omitempty"`
+	Count          int     `json:"count"`
+	Histogram      []int64 `json:"histogram,omitempty"`
+}
+
+// Checkpointer of the current run, nil unless -checkpoint is set
+var activeCheckpoint *checkpointer
+
+// Function to start checkpointing to path every interval, first restoring the saved state when resuming
+func startCheckpoint(path string, interval time.Duration, resume bool) (*checkpointer, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("-checkpointInterval must be positive")
+	}
+
+	c := &checkpointer{path: path, done: make(chan struct{})}
+	if resume {
+		if err := c.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := c.save(); err != nil {
+					slog.Error("saving checkpoint failed", "file", c.path, "err", err)
+				}
+			case <-c.done:
+				return
+			}
+		}
+	}()
+	return c, nil
+}
+
+// Function to hold the checkpoint lock shared while folding lines in, a no-op without checkpoints
+func (c *checkpointer) readLock() {
+	if c != nil {
+		c.mu.RLock()
+	}
+}
+
+// Function to release the shared checkpoint lock
+func (c *checkpointer) readUnlock() {
+	if c != nil {
+		c.mu.RUnlock()
+	}
+}
+
+// Function to restore the stats and progress saved in the checkpoint file
+func (c *checkpointer) load() error {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return fmt.Errorf("loading checkpoint: %w", err)
+	}
+	if err := json.Unmarshal(data, &c.state); err != nil {
+		return fmt.Errorf("loading checkpoint %s: %w", c.path, err)
+	}
+
+	restored := workerTables.NewTable()
+	for name, s := range c.state.Stats {
+		restored.Merge(name, stats.NameStats{Min: s.Min, Max: s.Max, Sum: s.Sum, SumHigh: s.SumHigh, SumSquares: s.SumSquares,
+			SumSquaresHigh: s.SumSquaresHigh, Count: s.Count, Histogram: s.Histogram})
+	}
+	c.state.Stats = nil
+	restored.Rows = c.state.Counters.Rows
+	atomic.StoreInt64(&rowsRead, restored.Rows)
+	atomic.StoreInt64(&malformedLines, c.state.Counters.Malformed)
+	if lineParser.Range != nil {
+		lineParser.Range.Violations.Store(c.state.Counters.OutOfRange)
+	}
+	lineParser.Remapped.Store(c.state.Counters.Remapped)
+	atomic.StoreInt64(&bytesRead, c.state.Counters.BytesRead)
+
+	// The delimiter detected by the interrupted run stays in effect
+	if lineParser.Delimiter == "auto" && c.state.Delimiter != "" {
+		lineParser.Delimiter = c.state.Delimiter
+	}
+	return nil
+}
+
+// Function to write the current stats and progress to the checkpoint file, atomically replacing it
+func (c *checkpointer) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.state.Delimiter = lineParser.Delimiter
+	c.state.Stats = make(map[string]checkpointStats)
+	// The workers only touch their tables while holding mu shared, so merging them here is safe
+	for _, shard := range workerTables.Merge() {
+		for name, s := range shard.All() {
+			c.state.Stats[name] = checkpointStats{Min: s.Min, Max: s.Max, Sum: s.Sum, SumHigh: s.SumHigh, SumSquares: s.SumSquares,
+				SumSquaresHigh: s.SumSquaresHigh, Count: s.Count, Histogram: s.Histogram}
+		}
+	}
+	c.state.Counters = checkpointCounters{
+		Rows:       aggregatedRows(),
+		Malformed:  atomic.LoadInt64(&malformedLines),
+		OutOfRange: outOfRangeReadings(),
+		Remapped:   lineParser.Remapped.Load(),
+		BytesRead:  atomic.LoadInt64(&bytesRead),
+	}
+	data, err := json.Marshal(c.state)
+	c.state.Stats = nil
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
+
+// Function to tell whether an input was fully processed before the run was interrupted
+func (c *checkpointer) completed(path string) bool {
+	if c == nil {
+		return false
+	}
+	for _, done := range c.state.Completed {
+		if done == path {
+			return true
+		}
+	}
+	return false
+}
+
+// Function to record that an input has been fully processed
+func (c *checkpointer) complete(path string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state.Completed = append(c.state.Completed, path)
+	c.state.Current = nil
+}
+
+// Function to stop checkpointing, removing the checkpoint file after a successful run
+// and saving a last one after a failed run
+func (c *checkpointer) stop(success bool) {
+	if c == nil {
+		return
+	}
+	close(c.done)
+	c.wg.Wait()
+
+	if success {
+		os.Remove(c.path)
+	} else if err := c.save(); err != nil {
+		slog.Error("saving checkpoint failed", "file", c.path, "err", err)
+	}
+}
+
+// Function to read the ranges of a file, continuing from the saved offsets when the
+// interrupted run stopped in the middle of this file
+func (c *checkpointer) readRanges(ctx context.Context, path string, r io.ReaderAt, info os.FileInfo, openRange func(c chunk) (io.ReadCloser, error)) error {
+	var progress *fileProgress
+	if current := c.state.Current; current != nil && current.Path == path {
+		if current.Size != info.Size() || !current.ModTime.Equal(info.ModTime()) {
+			return fmt.Errorf("%s changed since the checkpoint was written", path)
+		}
+		progress = current
+	} else {
+		chunks, err := planRanges(r, info.Size())
+		if err != nil {
+			return err
+		}
+		progress = &fileProgress{Path: path, Size: info.Size(), ModTime: info.ModTime()}
+		for _, ch := range chunks {
+			progress.Chunks = append(progress.Chunks, chunkProgress{Start: ch.start, End: ch.end, Offset: ch.start})
+		}
+	}
+
+	// Continue every range from the end of its last processed line, the readers keep the
+	// saved offsets up to date as they go
+	chunks := make([]chunk, len(progress.Chunks))
+	offsets := make([]*int64, len(progress.Chunks))
+	for i, ch := range progress.Chunks {
+		chunks[i] = chunk{start: ch.Offset, end: ch.End}
+		offsets[i] = &progress.Chunks[i].Offset
+	}
+
+	c.mu.Lock()
+	c.state.Current = progress
+	c.mu.Unlock()
+
+	return runRanges(ctx, chunks, openRange, offsets)
+}
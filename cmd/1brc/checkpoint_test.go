@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Function to keep the lines of a -summary that do not depend on the timing of the run
+func summaryTotals(stderr []byte) string {
+	var totals []string
+	for line := range strings.Lines(string(stderr)) {
+		if strings.HasPrefix(line, "  ") && !strings.Contains(line, "Elapsed") && !strings.Contains(line, "Rows/sec") {
+			totals = append(totals, line)
+		}
+	}
+	return strings.Join(totals, "")
+}
+
+// Checks that a run interrupted by -timeout and then resumed from its checkpoint prints the
+// same results and summary totals as an uninterrupted run
+func TestCheckpointResume(t *testing.T) {
+	path := writeRandomInput(t, rand.New(rand.NewPCG(7, 0)), 400000)
+	dir := t.TempDir()
+	aliases := filepath.Join(dir, "aliases.csv")
+	if err := os.WriteFile(aliases, []byte("Zürich;Zurich\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"-file", path, "-workers", "2", "-batchSize", "50", "-summary", "-alias-file", aliases,
+		"-validateRange=-50,50", "-aggs", "min,max,mean,count,sum", "-output", "csv"}
+	want, wantStderr, code := runCLIOutput(t, args...)
+	if code != 0 {
+		t.Fatalf("uninterrupted run exited with %d", code)
+	}
+
+	// Give the interrupted run more time until it stops in the middle of the file
+	checkpoint := filepath.Join(dir, "run.ckpt")
+	interrupted := false
+	for timeout := 5 * time.Millisecond; timeout < 10*time.Second && !interrupted; timeout *= 2 {
+		os.Remove(checkpoint)
+		_, _, code := runCLIOutput(t, append([]string{"-checkpoint", checkpoint, "-timeout", timeout.String()}, args...)...)
+		if code == 0 {
+			t.Skipf("the run finished within %s before it could be interrupted", timeout)
+		}
+		if code != exitTimedOut {
+			t.Fatalf("interrupted run exited with %d", code)
+		}
+		data, err := os.ReadFile(checkpoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var state checkpointState
+		if err := json.Unmarshal(data, &state); err != nil {
+			t.Fatal(err)
+		}
+		interrupted = state.Counters.Rows > 0 && state.Counters.Malformed > 0
+	}
+	if !interrupted {
+		t.Fatal("no run stopped with rows in its checkpoint")
+	}
+
+	got, gotStderr, code := runCLIOutput(t, append([]string{"-checkpoint", checkpoint, "-resume"}, args...)...)
+	if code != 0 || !bytes.Equal(got, want) {
+		t.Errorf("resumed run exited with %d and printed\n%s\nwant\n%s", code, got, want)
+	}
+	if summaryTotals(gotStderr) != summaryTotals(wantStderr) {
+		t.Errorf("resumed run summary:\n%s\nwant\n%s", summaryTotals(gotStderr), summaryTotals(wantStderr))
+	}
+	if _, err := os.Stat(checkpoint); err == nil {
+		t.Errorf("checkpoint %s was not removed after the resumed run", checkpoint)
+	}
+}
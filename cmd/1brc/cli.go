@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Struct to describe a subcommand of the 1brc command line
+type command struct {
+	name, summary string
+	run           func(name string, args []string)
+}
+
+// Subcommands in the order the usage lists them
+var commands = []command{
+	{"run", "Aggregate the inputs and print min/mean/max per station (the default)", runCommand},
+	{"bench", "Time repeated runs over a file, per read, parse, aggregate and output phase", runCommand},
+	{"generate", "Write a 1BRC-style measurements file of random readings for built-in stations", generateCommand},
+	{"verify", "Aggregate the inputs and compare the results with a baseline output, within a tolerance", runCommand},
+}
+
+func main() {
+	// Without a command name the flags go to run, as before there were subcommands
+	args := os.Args[1:]
+	name := "run"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	if name == "help" {
+		printUsage(os.Stdout)
+		return
+	}
+	for _, c := range commands {
+		if c.name == name {
+			c.run(name, args)
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+	printUsage(os.Stderr)
+	os.Exit(2)
+}
+
+// Function to print the list of commands
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: 1brc [command] [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 1brc <command> -h for the flags of a command.")
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Struct to hold one setting of a config file: a flag name and its values, several for a
+// repeatable flag such as file
+type configEntry struct {
+	name   string
+	values []string
+	line   int
+}
+
+// Function to apply the settings of a -config file to every flag not set yet by the command
+// line or the environment, so both override the file
+func applyConfig(fs *flag.FlagSet, path string) error {
+	entries, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	for _, entry := range entries {
+		if fs.Lookup(entry.name) == nil || entry.name == "config" {
+			return fmt.Errorf("%s:%d: unknown setting %q", path, entry.line, entry.name)
+		}
+		if explicit[entry.name] {
+			continue
+		}
+
+		// Only -file is repeated, other flags take a list as one comma-separated value
+		values := entry.values
+		if _, repeatable := fs.Lookup(entry.name).Value.(*inputList); !repeatable && len(values) > 1 {
+			values = []string{strings.Join(values, ",")}
+		}
+		for _, value := range values {
+			if err := fs.Set(entry.name, value); err != nil {
+				return fmt.Errorf("%s:%d: %s: %w", path, entry.line, entry.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Function to read the flat settings of a YAML (.yaml, .yml) or TOML (.toml) config file,
+// "workers: 8" or "workers = 8", with lists for repeatable flags
+func loadConfig(path string) ([]configEntry, error) {
+	var separator string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		separator = ":"
+	case ".toml":
+		separator = "="
+	default:
+		return nil, fmt.Errorf("%s: config files must end in .yaml, .yml or .toml", path)
+	}
+
+	file, err := openInputFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []configEntry
+	open := false // Whether the last YAML setting had no value, so a block list may follow
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" || line == "---" {
+			continue
+		}
+
+		// A YAML block list gives the values of the setting above it
+		if item, ok := strings.CutPrefix(line, "- "); ok && separator == ":" {
+			if !open {
+				return nil, fmt.Errorf("%s:%d: list item without a setting", path, lineNumber)
+			}
+			value, err := parseConfigScalar(strings.TrimSpace(item))
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+			}
+			last := &entries[len(entries)-1]
+			last.values = append(last.values, value)
+			continue
+		}
+
+		name, raw, ok := strings.Cut(line, separator)
+		if !ok || strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("%s:%d: expected a flat \"name%s value\" setting, got %q", path, lineNumber, separator, line)
+		}
+		name, raw = strings.Trim(strings.TrimSpace(name), `"'`), strings.TrimSpace(raw)
+		open = raw == "" && separator == ":"
+		var values []string
+		if !open {
+			values, err = parseConfigValue(raw)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %s: %w", path, lineNumber, name, err)
+			}
+		}
+		entries = append(entries, configEntry{name: name, values: values, line: lineNumber})
+	}
+	return entries, scanner.Err()
+}
+
+// Function to cut a "#" comment off a line, leaving "#" inside quotes alone
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == '"' && c == '\\':
+			// Step over the escaped character, which may be a quote
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// Function to parse the value of a setting, a scalar or a [a, b] list of them
+func parseConfigValue(raw string) ([]string, error) {
+	inner, ok := strings.CutPrefix(raw, "[")
+	if !ok {
+		value, err := parseConfigScalar(raw)
+		return []string{value}, err
+	}
+	inner, ok = strings.CutSuffix(inner, "]")
+	if !ok {
+		return nil, errors.New("unterminated list")
+	}
+
+	// Split the items at the commas outside quotes
+	var values []string
+	var quote byte
+	start := 0
+	for i := 0; i <= len(inner); i++ {
+		if i < len(inner) {
+			c := inner[i]
+			switch {
+			case quote == '"' && c == '\\':
+				i++
+				continue
+			case quote != 0:
+				if c == quote {
+					quote = 0
+				}
+				continue
+			case c == '"' || c == '\'':
+				quote = c
+				continue
+			case c != ',':
+				continue
+			}
+		}
+		item := strings.TrimSpace(inner[start:i])
+		start = i + 1
+		if item == "" {
+			continue
+		}
+		value, err := parseConfigScalar(item)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// Function to parse a scalar: a "double-quoted" string with escapes, a 'single-quoted' string
+// taken as is, or a bare number, boolean, duration or word
+func parseConfigScalar(raw string) (string, error) {
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		value, err := strconv.Unquote(raw)
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", raw)
+		}
+		return value, nil
+	case strings.HasPrefix(raw, "'"):
+		if len(raw) < 2 || !strings.HasSuffix(raw, "'") {
+			return "", fmt.Errorf("invalid string %s", raw)
+		}
+		return strings.ReplaceAll(raw[1:len(raw)-1], "''", "'"), nil
+	default:
+		return raw, nil
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name, config string
+		want         []configEntry
+		fail         string // Part of the error when loading must fail
+	}{
+		{name: "run.yaml", config: "workers: 8\nbatchSize: 5000 # comment\n# whole line comment\n---\ndelimiter: \";\"\n",
+			want: []configEntry{{"workers", []string{"8"}, 1}, {"batchSize", []string{"5000"}, 2}, {"delimiter", []string{";"}, 5}}},
+		{name: "run.toml", config: "workers = 8\nbatchSize = 5000 # comment\n# whole line comment\ndelimiter = \";\"\n",
+			want: []configEntry{{"workers", []string{"8"}, 1}, {"batchSize", []string{"5000"}, 2}, {"delimiter", []string{";"}, 4}}},
+		{name: "quoting.yaml", config: "a: \"x # not a comment\"\nb: 'it''s'\nc: \"tab\\there\"\n\"d\": plain words\ne: \"say \\\"hi\\\"\" # comment\n",
+			want: []configEntry{{"a", []string{"x # not a comment"}, 1}, {"b", []string{"it's"}, 2}, {"c", []string{"tab\there"}, 3}, {"d", []string{"plain words"}, 4}, {"e", []string{`say "hi"`}, 5}}},
+		{name: "quoting.toml", config: "a = \"x # not a comment\"\nb = 'C:\\data'\nc = \"tab\\there\"\nd = \"a=b\"\n",
+			want: []configEntry{{"a", []string{"x # not a comment"}, 1}, {"b", []string{`C:\data`}, 2}, {"c", []string{"tab\there"}, 3}, {"d", []string{"a=b"}, 4}}},
+		{name: "files.yaml", config: "file:\n  - a.txt\n  - \"b c.txt\" # comment\nworkers: 2\nfile: [d.txt, 'e,f.txt']\n",
+			want: []configEntry{{"file", []string{"a.txt", "b c.txt"}, 1}, {"workers", []string{"2"}, 4}, {"file", []string{"d.txt", "e,f.txt"}, 5}}},
+		{name: "files.toml", config: "file = [\"a.txt\", \"b,c.txt\", 'd.txt']\nfile = \"e.txt\"\n",
+			want: []configEntry{{"file", []string{"a.txt", "b,c.txt", "d.txt"}, 1}, {"file", []string{"e.txt"}, 2}}},
+		{name: "url.yaml", config: "file: https://example.com/m.txt\n",
+			want: []configEntry{{"file", []string{"https://example.com/m.txt"}, 1}}},
+		{name: "table.toml", config: "[run]\nworkers = 8\n", fail: `table.toml:1: expected a flat "name= value" setting`},
+		{name: "list.yaml", config: "- a.txt\n", fail: "list.yaml:1: list item without a setting"},
+		{name: "list.toml", config: "file = [\"a.txt\"\n", fail: "list.toml:1: file: unterminated list"},
+		{name: "string.yaml", config: "a: \"open\n", fail: "string.yaml:1: a: invalid string"},
+		{name: "missing.yaml", config: "workers 8\n", fail: "missing.yaml:1: expected"},
+		{name: "run.json", config: "{}", fail: "must end in .yaml, .yml or .toml"},
+	}
+	for _, test := range tests {
+		path := writeTestInput(t, test.name, test.config)
+		got, err := loadConfig(path)
+		if test.fail != "" {
+			if err == nil || !strings.Contains(err.Error(), test.fail) {
+				t.Errorf("%s: loadConfig = %v, %v, want an error with %q", test.name, got, err, test.fail)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: loadConfig = %+v, %v, want %+v", test.name, got, err, test.want)
+		}
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	var files inputList
+	var size int
+	var timeout time.Duration
+	var aggs string
+	newFlags := func() *flag.FlagSet {
+		files, size, timeout, aggs = nil, 1000, 0, "min,max,mean"
+		fs := flag.NewFlagSet("1brc", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		fs.Var(&files, "file", "")
+		fs.IntVar(&size, "batchSize", 1000, "")
+		fs.DurationVar(&timeout, "timeout", 0, "")
+		fs.StringVar(&aggs, "aggs", "min,max,mean", "")
+		fs.String("config", "", "")
+		return fs
+	}
+
+	for _, config := range []struct{ name, text string }{
+		{"run.yaml", "file:\n  - a.txt\n  - b.txt\nbatchSize: 5000\ntimeout: 10m\naggs: [min, max]\n"},
+		{"run.toml", "file = ['a.txt', \"b.txt\"]\nbatchSize = 5000\ntimeout = \"10m\"\naggs = [\"min\", \"max\"]\n"},
+	} {
+		path := writeTestInput(t, config.name, config.text)
+		fs := newFlags()
+		if err := applyConfig(fs, path); err != nil {
+			t.Fatalf("%s: %v", config.name, err)
+		}
+		if !reflect.DeepEqual(files, inputList{"a.txt", "b.txt"}) || size != 5000 || timeout != 10*time.Minute || aggs != "min,max" {
+			t.Errorf("%s set %q, %d, %s and %q", config.name, files, size, timeout, aggs)
+		}
+
+		// Flags on the command line win over the file, -file included
+		fs = newFlags()
+		fs.Parse([]string{"-batchSize", "7", "-file", "c.txt"})
+		if err := applyConfig(fs, path); err != nil {
+			t.Fatalf("%s: %v", config.name, err)
+		}
+		if !reflect.DeepEqual(files, inputList{"c.txt"}) || size != 7 || timeout != 10*time.Minute {
+			t.Errorf("%s under -batchSize 7 -file c.txt set %q, %d and %s", config.name, files, size, timeout)
+		}
+	}
+
+	for _, invalid := range []struct{ config, fail string }{
+		{"batchSize: 8\nbogus: 1\n", `bad.yaml:2: unknown setting "bogus"`},
+		{"config: other.yaml\n", `bad.yaml:1: unknown setting "config"`},
+		{"batchSize: many\n", "bad.yaml:1: batchSize: "},
+		{"timeout: [1m, 2m]\n", "bad.yaml:1: timeout: "},
+	} {
+		path := writeTestInput(t, "bad.yaml", invalid.config)
+		if err := applyConfig(newFlags(), path); err == nil || !strings.Contains(err.Error(), invalid.fail) {
+			t.Errorf("applyConfig of %q = %v, want an error with %q", invalid.config, err, invalid.fail)
+		}
+	}
+}
+
+// Checks that the settings of -config take effect and that the command line overrides them
+func TestConfigFlag(t *testing.T) {
+	input := writeTestInput(t, "measurements.txt", "Hamburg;12.0\nBulawayo;8.9\n")
+	config := writeTestInput(t, "run.toml", "file = \""+filepath.ToSlash(input)+"\"\noutput = \"csv\" # as for a spreadsheet\naggs = [\"max\"]\n")
+	if got, code := runCLI(t, "-config", config); code != 0 || string(got) != "station,max,count\nBulawayo,8.90,1\nHamburg,12.00,1\n" {
+		t.Errorf("-config exited with %d and printed\n%s", code, got)
+	}
+	if got, code := runCLI(t, "-config", config, "-aggs", "min", "-precision", "1"); code != 0 || string(got) != "station,min,count\nBulawayo,8.9,1\nHamburg,12.0,1\n" {
+		t.Errorf("-config under -aggs min exited with %d and printed\n%s", code, got)
+	}
+}
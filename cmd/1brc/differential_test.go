@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Function to write a random input of rows lines to a file: names from a small pool with unicode
+// and spaces, readings in several number shapes, CRLF line ends and a few malformed lines
+func writeRandomInput(t *testing.T, random *rand.Rand, rows int) string {
+	t.Helper()
+	names := []string{"Hamburg", "St. John's", "İzmir", "東京", "Zürich", "a", "Las Palmas de Gran Canaria", "🌡"}
+	for i := range random.IntN(200) {
+		names = append(names, fmt.Sprintf("Station %d", i))
+	}
+
+	var input strings.Builder
+	for range rows {
+		name := names[random.IntN(len(names))]
+		tenths := random.IntN(1999) - 999
+		switch random.IntN(20) {
+		case 0:
+			input.WriteString("malformed line without delimiter")
+		case 1:
+			fmt.Fprintf(&input, "%s;%d", name, tenths/10)
+		case 2:
+			fmt.Fprintf(&input, "%s;%.2f", name, float64(tenths)/10)
+		default:
+			fmt.Fprintf(&input, "%s;%.1f", name, float64(tenths)/10)
+		}
+		if random.IntN(10) == 0 {
+			input.WriteString("\r")
+		}
+		input.WriteString("\n")
+	}
+
+	path := filepath.Join(t.TempDir(), "measurements.txt")
+	if err := os.WriteFile(path, []byte(input.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Compares the parallel readers with the -sequential reference on random inputs
+func TestSequentialDifferential(t *testing.T) {
+	variants := [][]string{
+		{"-workers", "1"},
+		{"-workers", "4", "-batchSize", "7"},
+		{"-workers", "3", "-mmap"},
+		{"-workers", "8", "-batchSize", "1", "-readBuffer", "64"},
+		{"-workers", "4", "-batchSize", "5", "-format", "csv"},
+	}
+	output := []string{"-aggs", "min,max,mean,count,sum", "-stddev", "-output", "csv", "-precision", "3"}
+
+	for seed := range uint64(5) {
+		random := rand.New(rand.NewPCG(seed, 0))
+		path := writeRandomInput(t, random, 1+random.IntN(20000))
+		want, code := runCLI(t, append([]string{"-sequential", "-file", path}, output...)...)
+		if code != 0 || len(want) == 0 {
+			t.Fatalf("seed %d: -sequential exited with %d and printed %q", seed, code, want)
+		}
+
+		for _, variant := range variants {
+			args := append(append([]string{"-file", path}, variant...), output...)
+			got, code := runCLI(t, args...)
+			if code != 0 || !bytes.Equal(got, want) {
+				t.Errorf("seed %d: %v exited with %d and printed\n%s\nwant, as -sequential:\n%s", seed, variant, code, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Prefix of the environment variables flags are read from
+const envPrefix = "ONEBRC_"
+
+// Function to set every flag not given on the command line from its ONEBRC_* environment
+// variable, e.g. ONEBRC_BATCH_SIZE for -batchSize or ONEBRC_GROUP_COL for -group-col. The value
+// of a repeatable flag is a comma-separated list, ONEBRC_FILE=a.txt,b.txt giving two inputs
+func applyEnvironment(fs *flag.FlagSet) error {
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || explicit[f.Name] {
+			return
+		}
+		name := envName(f.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		values := []string{value}
+		if _, repeatable := f.Value.(*inputList); repeatable {
+			values = strings.Split(value, ",")
+		}
+		for _, value := range values {
+			if setErr := fs.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("%s: %w", name, setErr)
+				return
+			}
+		}
+	})
+	return err
+}
+
+// Function to derive the environment variable of a flag, splitting camelCase words and
+// hyphens with underscores: batchSize gives ONEBRC_BATCH_SIZE
+func envName(flagName string) string {
+	var name strings.Builder
+	name.WriteString(envPrefix)
+	for i, c := range flagName {
+		switch {
+		case c == '-':
+			name.WriteByte('_')
+		case unicode.IsUpper(c) && i > 0:
+			name.WriteByte('_')
+			name.WriteRune(c)
+		default:
+			name.WriteRune(unicode.ToUpper(c))
+		}
+	}
+	return name.String()
+}
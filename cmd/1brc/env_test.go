@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestApplyEnvironment(t *testing.T) {
+	var files inputList
+	var size int
+	fs := flag.NewFlagSet("1brc", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Var(&files, "file", "")
+	fs.IntVar(&size, "batchSize", 1, "")
+	t.Setenv("ONEBRC_FILE", "a.txt,b.txt")
+	t.Setenv("ONEBRC_BATCH_SIZE", "5000")
+
+	if err := applyEnvironment(fs); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(files, inputList{"a.txt", "b.txt"}) || size != 5000 {
+		t.Errorf("ONEBRC_FILE and ONEBRC_BATCH_SIZE gave %q and %d, want [a.txt b.txt] and 5000", files, size)
+	}
+
+	fs = flag.NewFlagSet("1brc", flag.ContinueOnError)
+	fs.IntVar(&size, "batchSize", 1, "")
+	t.Setenv("ONEBRC_BATCH_SIZE", "many")
+	if err := applyEnvironment(fs); err == nil {
+		t.Error("ONEBRC_BATCH_SIZE=many did not fail")
+	}
+}
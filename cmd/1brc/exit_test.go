@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Checks that runs which cannot do their job exit non-zero, so scripts and CI catch them: 2 for
+// invalid flags, 1 for failures on the inputs or the setup
+func TestExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write(bytes.Repeat([]byte("Hamburg;12.0\n"), 1000))
+	zw.Close()
+	truncated := filepath.Join(dir, "truncated.txt.gz")
+	if err := os.WriteFile(truncated, compressed.Bytes()[:compressed.Len()/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	basic := filepath.Join("testdata", "basic.txt")
+
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"verify missing input", []string{"verify", "-file", filepath.Join(dir, "missing.txt"), "-expected", "testdata/basic.golden"}, 1},
+		{"verify missing baseline", []string{"verify", "-file", basic, "-expected", filepath.Join(dir, "missing.golden")}, 1},
+		{"verify matching", []string{"verify", "-file", basic, "-expected", "testdata/basic.golden"}, 0},
+		{"missing input", []string{"-file", filepath.Join(dir, "missing.txt")}, 1},
+		{"truncated gzip", []string{"-file", truncated}, 1},
+		{"missing alias file", []string{"-file", basic, "-alias-file", filepath.Join(dir, "aliases.txt")}, 1},
+		{"missing config", []string{"-file", basic, "-config", filepath.Join(dir, "run.yaml")}, 1},
+		{"unknown output", []string{"-file", basic, "-output", "bogus"}, 2},
+		{"invalid template", []string{"-file", basic, "-outputTemplate", "{{.Name"}, 2},
+		{"template of an unknown field", []string{"-file", basic, "-outputTemplate", "{{.Bogus}}"}, 1},
+		{"empty delimiter", []string{"-file", basic, "-delimiter", ""}, 2},
+		{"negative workers", []string{"-file", basic, "-workers", "0"}, 2},
+		{"verify without baseline", []string{"verify", "-file", basic}, 2},
+		{"unknown aggregator", []string{"-file", basic, "-aggregator", "bogus"}, 2},
+		{"unknown flag", []string{"-bogus"}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, code := runCLI(t, tt.args...); code != tt.code {
+				t.Errorf("%v exited with %d, want %d", tt.args, code, tt.code)
+			}
+		})
+	}
+}
+
+// Readings with malformed lines injected between valid ones, one of them out of the default
+// -validate range and one malformed line ending in CRLF
+const injectedInput = "Hamburg;12.0\nbad line\nBulawayo;8.9\nHamburg;abc\r\n;1.0\nCracow;150.0\n" +
+	"Cracow;-1.0\r\nTail;1.0;2\nPalembang;38.8\n"
+
+// Malformed lines of injectedInput with the reason -rejectFile records for each
+var injectedRejects = []struct{ line, reason string }{
+	{"bad line", "invalid format: bad line"},
+	{"Hamburg;abc", "invalid number: abc"},
+	{";1.0", "empty name: ;1.0"},
+	{"Tail;1.0;2", "invalid format: Tail;1.0;2"},
+}
+
+// Checks the records of -rejectFile, the input and byte offset of each malformed line, the reason
+// and the line without its line ending, whichever way the input is read
+func TestRejectFile(t *testing.T) {
+	path := writeTestInput(t, "measurements.txt", injectedInput)
+	var want []string
+	for _, r := range injectedRejects {
+		want = append(want, fmt.Sprintf("%s:%d\t%s\t%s\n", path, strings.Index(injectedInput, "\n"+r.line)+1, r.reason, r.line))
+	}
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"sequential", []string{"-workers", "1"}},
+		{"parallel", []string{"-workers", "3", "-batchSize", "2"}},
+		{"mmap", []string{"-mmap", "-workers", "2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rejects := filepath.Join(t.TempDir(), "rejects.txt")
+			stdout, stderr, code := runCLIOutput(t, append([]string{"-file", path, "-rejectFile", rejects}, tt.args...)...)
+			if code != 0 || !strings.Contains(string(stdout), "Palembang") {
+				t.Fatalf("run exited with %d and printed\n%s", code, stdout)
+			}
+			if strings.Contains(string(stderr), "malformed line") {
+				t.Errorf("malformed lines logged to stderr with -rejectFile:\n%s", stderr)
+			}
+			data, err := os.ReadFile(rejects)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Workers record their lines as they go, ordered by offset
+			lines := strings.SplitAfter(string(data), "\n")
+			lines = lines[:len(lines)-1]
+			slices.SortFunc(lines, func(a, b string) int { return cmp.Compare(rejectOffset(a), rejectOffset(b)) })
+			if got := strings.Join(lines, ""); got != strings.Join(want, "") {
+				t.Errorf("-rejectFile holds\n%s\nwant\n%s", got, strings.Join(want, ""))
+			}
+		})
+	}
+
+	if _, code := runCLI(t, "-file", path, "-rejectFile", filepath.Join(t.TempDir(), "missing", "rejects.txt")); code != 1 {
+		t.Errorf("unwritable -rejectFile exited with %d, want 1", code)
+	}
+}
+
+// Function to read the byte offset of a -rejectFile record
+func rejectOffset(record string) int {
+	location, _, _ := strings.Cut(record, "\t")
+	offset, _ := strconv.Atoi(location[strings.LastIndexByte(location, ':')+1:])
+	return offset
+}
+
+// Checks the counts -validate prints and that it exits 1 exactly when malformed lines or readings
+// out of range were injected
+func TestValidate(t *testing.T) {
+	report := func(checked, valid, malformed, outOfRange int, band string) string {
+		return fmt.Sprintf("Rows checked:   %d\nValid rows:     %d\nMalformed rows: %d\nOut of range:   %d (outside %s)\n", checked, valid, malformed, outOfRange, band)
+	}
+	tests := []struct {
+		name  string
+		input string
+		args  []string
+		want  string
+		code  int
+	}{
+		{"clean", "Hamburg;12.0\nBulawayo;-99.9\nPalembang;99.9\n", nil, report(3, 3, 0, 0, "-99.9,99.9"), 0},
+		{"injected", injectedInput, nil, report(9, 4, 4, 1, "-99.9,99.9"), 1},
+		{"injected in parallel", injectedInput, []string{"-workers", "3", "-batchSize", "2"}, report(9, 4, 4, 1, "-99.9,99.9"), 1},
+		{"out of range only", "Hamburg;12.0\nCracow;100.0\n", nil, report(2, 1, 0, 1, "-99.9,99.9"), 1},
+		{"malformed only", "Hamburg;12.0\nHamburg\n", nil, report(2, 1, 1, 0, "-99.9,99.9"), 1},
+		{"wider range", "Hamburg;12.0\nCracow;150.0\n", []string{"-validateRange=-200,200"}, report(2, 2, 0, 0, "-200,200"), 0},
+		{"empty", "", nil, report(0, 0, 0, 0, "-99.9,99.9"), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestInput(t, "measurements.txt", tt.input)
+			stdout, code := runCLI(t, append([]string{"-file", path, "-validate"}, tt.args...)...)
+			if code != tt.code || string(stdout) != tt.want {
+				t.Errorf("-validate exited with %d and printed\n%s\nwant %d and\n%s", code, stdout, tt.code, tt.want)
+			}
+		})
+	}
+
+	path := writeTestInput(t, "measurements.txt", injectedInput)
+	for _, args := range [][]string{{"-output", "json"}, {"-out", filepath.Join(t.TempDir(), "results.txt")}, {"-distinct"}} {
+		if _, code := runCLI(t, append([]string{"-file", path, "-validate"}, args...)...); code != 2 {
+			t.Errorf("-validate %v exited with %d, want 2", args, code)
+		}
+	}
+}
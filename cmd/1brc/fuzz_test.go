@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"testing/iotest"
+	"unicode"
+
+	"example.com/mod/internal/reader"
+)
+
+func FuzzLetterOf(f *testing.F) {
+	for _, seed := range []string{"Hamburg", "İzmir", "東京", "#1", "", "\xff\xfe", "\xc3"} {
+		f.Add(seed)
+	}
+
+	// Invalid UTF-8 must still only ever be sliced, never panic the letter lookup
+	f.Fuzz(func(t *testing.T, name string) {
+		if letter := letterOf(name); letter != otherLetter && !unicode.IsLower(letter) && unicode.ToLower(letter) != letter {
+			t.Fatalf("letterOf(%q) = %q, want a lower-case letter or %q", name, letter, otherLetter)
+		}
+	})
+}
+
+func FuzzChunkSplit(f *testing.F) {
+	f.Add([]byte("a;1.0\nb;2.0\nc;3.0\n"), uint8(2), uint8(4))
+	f.Add([]byte("a;1.0\r\nb;2.0\r\nlast;3.0"), uint8(3), uint8(1))
+	f.Add([]byte("\n\n\nx\n"), uint8(5), uint8(2))
+	f.Add([]byte("a very long line that does not fit;1.0\nb;2.0\n"), uint8(2), uint8(3))
+	f.Add([]byte(""), uint8(1), uint8(1))
+
+	f.Fuzz(func(t *testing.T, data []byte, n, bufferSize uint8) {
+		defer func(buffer, lineLength int) { readBuffer, maxLineLength = buffer, lineLength }(readBuffer, maxLineLength)
+		readBuffer, maxLineLength = int(bufferSize)%16+1, 32
+
+		// Every line of the input must come out of exactly one range, whatever the split
+		want := splitLines(t, data)
+		r := bytes.NewReader(data)
+		size := int64(len(data))
+		chunks, err := splitRanges(r, 0, size, int(n)%12+1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got [][]byte
+		next := int64(0)
+		for _, c := range chunks {
+			if c.start != next || c.end <= c.start || c.end > size {
+				t.Fatalf("range %+v after %d of %d bytes in %v", c, next, size, chunks)
+			}
+			if c.start > 0 && data[c.start-1] != '\n' {
+				t.Fatalf("range %+v does not start a line", c)
+			}
+			got = append(got, splitLines(t, data[c.start:c.end])...)
+			next = c.end
+		}
+		if next != size {
+			t.Fatalf("ranges %v end at %d of %d bytes", chunks, next, size)
+		}
+		if want != nil && !equalLines(got, want) {
+			t.Fatalf("ranges %v give lines %q, want %q", chunks, got, want)
+		}
+	})
+}
+
+// Function to read the lines of data through a line reader fed one byte at a time, checking them
+// against splitting on newlines; nil when a line is longer than -maxLineLength, which must fail
+func splitLines(t *testing.T, data []byte) [][]byte {
+	t.Helper()
+	var want [][]byte
+	for line := range bytes.SplitSeq(data, []byte("\n")) {
+		want = append(want, reader.DropCR(line))
+	}
+	if len(data) == 0 || data[len(data)-1] == '\n' {
+		want = want[:len(want)-1]
+	}
+
+	lines, consumed := reader.NewLineReader(iotest.OneByteReader(bytes.NewReader(data)), 0, readBuffer, maxLineLength)
+	var got [][]byte
+	for lines.Scan() {
+		got = append(got, bytes.Clone(lines.Bytes()))
+	}
+	longest := 0
+	for line := range bytes.SplitSeq(data, []byte("\n")) {
+		longest = max(longest, len(line)+1)
+	}
+	if longest > max(readBuffer, maxLineLength) {
+		if !errors.Is(lines.Err(), reader.ErrLineTooLong) && !equalLines(got, want) {
+			t.Fatalf("line reader over %q gave %q, %v with lines up to %d bytes", data, got, lines.Err(), longest)
+		}
+		return nil
+	}
+	if lines.Err() != nil || !equalLines(got, want) || *consumed != int64(len(data)) {
+		t.Fatalf("line reader over %q gave %q, %v after %d bytes, want %q", data, got, lines.Err(), *consumed, want)
+	}
+	return got
+}
+
+// Function to compare two lists of lines
+func equalLines(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	generateRows  int64   // Number of measurement lines the generate command writes
+	generateOut   string  // File the generate command writes to, - for stdout
+	generateSeed  uint64  // Seed of the random readings, the same seed gives the same file
+	stationsPath  string  // CSV file of name, mean, stddev rows replacing the built-in stations
+	stationStddev float64 // Standard deviation of the readings of the built-in stations
+	targetSize    int64   // Size in bytes the generate command writes instead of -rows, 0 for -rows
+)
+
+// Number of lines in a chunk of the generator; every chunk has a random stream of its own so the
+// file only depends on the seed, not on the number of workers
+const generateChunkRows = 1 << 18
+
+// Struct to hold a station the generator picks from with the standard deviation of its readings
+type generatorStation struct {
+	station
+	stddev float64
+}
+
+// Function to run the generate command: write 1BRC-style "name;temp" lines for randomly
+// picked built-in stations to -out
+func generateCommand(command string, args []string) {
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: 1brc %s [flags]\n\nFlags:\n", command)
+		fs.PrintDefaults()
+	}
+	fs.Int64Var(&generateRows, "rows", 1_000_000, "Number of measurement lines to write, 1000000000 for a full 1BRC file")
+	fs.StringVar(&generateOut, "out", "measurements.txt", "File to write the measurements to, or - for stdout")
+	fs.Uint64Var(&generateSeed, "seed", 0, "Seed of the random readings, identical seeds write byte-identical files (not given: a random seed, logged)")
+	fs.StringVar(&stationsPath, "stations", "", "CSV file of name,mean,stddev rows (mean and stddev in Celsius) to pick the stations from instead of the built-in list")
+	fs.Float64Var(&stationStddev, "stddev", 10, "Standard deviation in Celsius of the readings around the mean of each built-in station, as the reference generator uses")
+	fs.IntVar(&workers, "workers", runtime.NumCPU(), "Number of chunks generated in parallel")
+	targetSpec := fs.String("targetSize", "", "Write lines until the file reaches this size instead of -rows lines, in bytes or with a KB, MB, GB, TB (or KiB, MiB, GiB, TiB) suffix, e.g. 13GB")
+	fs.Parse(args)
+
+	slog.SetDefault(newLogger(slog.LevelInfo))
+	if *targetSpec != "" {
+		rowsGiven := false
+		fs.Visit(func(f *flag.Flag) { rowsGiven = rowsGiven || f.Name == "rows" })
+		size, err := parseByteSize(*targetSpec)
+		if err != nil || rowsGiven {
+			slog.Error("invalid -targetSize, must be a size and not be given with -rows", "targetSize", *targetSpec)
+			os.Exit(2)
+		}
+		targetSize = size
+	}
+	if workers < 1 {
+		slog.Error("invalid -workers, must be at least 1", "workers", workers)
+		os.Exit(2)
+	}
+	seeded := false
+	fs.Visit(func(f *flag.Flag) { seeded = seeded || f.Name == "seed" })
+	if !seeded {
+		// Log the picked seed so the file can still be written again
+		generateSeed = rand.Uint64()
+		slog.Info("generating with a random seed", "seed", generateSeed)
+	}
+	if generateRows < 0 {
+		slog.Error("invalid -rows, must not be negative", "rows", generateRows)
+		os.Exit(2)
+	}
+
+	stations := builtinGeneratorStations()
+	if stationsPath != "" {
+		var err error
+		if stations, err = loadStations(stationsPath); err != nil {
+			slog.Error("loading stations failed", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	if err := writeMeasurementsFile(generateOut, stations); err != nil {
+		slog.Error("generating measurements failed", "err", err)
+		os.Exit(1)
+	}
+}
+
+// Function to give the built-in stations the -stddev spread around their mean
+func builtinGeneratorStations() []generatorStation {
+	stations := make([]generatorStation, len(builtinStations))
+	for i, s := range builtinStations {
+		stations[i] = generatorStation{station: s, stddev: stationStddev}
+	}
+	return stations
+}
+
+// Function to load the stations of a -stations file, one "name,mean,stddev" row each; blank lines,
+// # comments and a leading header row are skipped and names may be double-quoted to hold commas
+func loadStations(path string) ([]generatorStation, error) {
+	file, err := openInputFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(bufio.NewReaderSize(file, 1<<20))
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+	reader.ReuseRecord = true
+
+	var stations []generatorStation
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		lineNumber, _ := reader.FieldPos(0)
+
+		name := strings.TrimSpace(record[0])
+		mean, meanErr := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		stddev, stddevErr := strconv.ParseFloat(strings.TrimSpace(record[2]), 64)
+		if meanErr != nil && first {
+			// The first row names the columns
+			continue
+		}
+		if name == "" || strings.ContainsAny(name, ";\n\r") || meanErr != nil || stddevErr != nil || stddev < 0 {
+			return nil, fmt.Errorf("%s:%d: invalid station: %s", path, lineNumber, strings.Join(record, ","))
+		}
+		stations = append(stations, generatorStation{station: station{name: name, mean: mean}, stddev: stddev})
+	}
+	if len(stations) == 0 {
+		return nil, errors.New(path + ": no stations")
+	}
+	return stations, nil
+}
+
+// Function to write the measurements to a file, or to stdout for -
+func writeMeasurementsFile(path string, stations []generatorStation) error {
+	if path == "-" {
+		return writeMeasurements(os.Stdout, stations)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeMeasurements(file, stations); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Function to write the measurement lines, generating chunks of them on -workers goroutines and
+// writing them in order; with -targetSize the last line that fits the size ends the file
+func writeMeasurements(w io.Writer, stations []generatorStation) error {
+	chunks := (generateRows + generateChunkRows - 1) / generateChunkRows
+	if targetSize > 0 {
+		chunks = math.MaxInt64
+	}
+
+	// Queue a result channel per chunk in file order, at most -workers chunks ahead of the writer;
+	// once the writer is done the chunks still being generated are waited for, so none outlives it
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	done := make(chan struct{})
+	defer close(done)
+	pending := make(chan chan []byte, workers)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(pending)
+		running := make(chan struct{}, workers)
+		for index := range chunks {
+			result := make(chan []byte, 1)
+			select {
+			case pending <- result:
+			case <-done:
+				return
+			}
+			select {
+			case running <- struct{}{}:
+			case <-done:
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				result <- generateChunk(stations, index)
+				<-running
+			}()
+		}
+	}()
+
+	var written int64
+	for result := range pending {
+		data := <-result
+		if targetSize > 0 && written+int64(len(data)) >= targetSize {
+			data = data[:bytes.LastIndexByte(data[:targetSize-written], '\n')+1]
+			_, err := w.Write(data)
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		written += int64(len(data))
+	}
+	return nil
+}
+
+// Function to generate the lines of a chunk for randomly picked stations, each reading drawn from a
+// normal distribution around the mean of its station, as the reference generator does, with one decimal
+func generateChunk(stations []generatorStation, index int64) []byte {
+	rows := int64(generateChunkRows)
+	if targetSize == 0 {
+		rows = min(rows, generateRows-index*generateChunkRows)
+	}
+
+	random := rand.New(rand.NewPCG(generateSeed, uint64(index)))
+	data := make([]byte, 0, rows*16)
+	for range rows {
+		s := stations[random.IntN(len(stations))]
+		value := s.mean + random.NormFloat64()*s.stddev
+		data = append(data, s.name...)
+		data = append(data, ';')
+		data = appendTenths(data, value)
+		data = append(data, '\n')
+	}
+	return data
+}
+
+// Function to append a temperature rounded to one decimal and kept within the -99.9..99.9
+// range of 1BRC
+func appendTenths(b []byte, value float64) []byte {
+	tenths := min(max(int64(math.Round(value*10)), -999), 999)
+	if tenths < 0 {
+		b = append(b, '-')
+		tenths = -tenths
+	}
+	b = strconv.AppendInt(b, tenths/10, 10)
+	return append(b, '.', byte('0'+tenths%10))
+}
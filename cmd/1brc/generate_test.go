@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Checks that generate writes byte-identical files for the same -seed whatever the number of
+// workers, over several chunks, and a different file for another seed
+func TestGenerateSeed(t *testing.T) {
+	dir := t.TempDir()
+	rows := "600000" // Three chunks, the last one partial
+	generate := func(name, seed, workers string) []byte {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if _, code := runCLI(t, "generate", "-rows", rows, "-seed", seed, "-workers", workers, "-out", path); code != 0 {
+			t.Fatalf("generate -seed %s -workers %s exited with %d", seed, workers, code)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	one := generate("one.txt", "42", "1")
+	four := generate("four.txt", "42", "4")
+	if lines := bytes.Count(one, []byte("\n")); lines != 600000 {
+		t.Fatalf("generate wrote %d lines, want %s", lines, rows)
+	}
+	if !bytes.Equal(one, four) {
+		t.Error("-seed 42 wrote different files with -workers 1 and -workers 4")
+	}
+	if other := generate("other.txt", "43", "4"); bytes.Equal(one, other) {
+		t.Error("-seed 42 and -seed 43 wrote the same file")
+	}
+}
+
+// Checks the parsing and validation of -stations files: the header row, comments, blank lines and
+// quoted names are skipped or read, invalid rows are reported with their line
+func TestLoadStations(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string // Stations as name:mean:stddev, or the error after the path
+	}{
+		{"rows", "Hamburg,12.0,3.5\nBulawayo,18.9,0\n", "[Hamburg:12:3.5 Bulawayo:18.9:0]"},
+		{"header", "name,mean,stddev\nHamburg,12.0,3.5\n", "[Hamburg:12:3.5]"},
+		{"comments and blank lines", "# stations\n\nHamburg,12,3\n\n# end\n", "[Hamburg:12:3]"},
+		{"quoted name", "\"Washington, D.C.\",14.6,5\n", "[Washington, D.C.:14.6:5]"},
+		{"spaces", " Hamburg , 12 , 3 \n", "[Hamburg:12:3]"},
+		{"no trailing newline", "Hamburg,-1.5,2", "[Hamburg:-1.5:2]"},
+		{"invalid mean", "Hamburg,12,3\nBulawayo,warm,3\n", ":2: invalid station: Bulawayo,warm,3"},
+		{"invalid stddev", "Hamburg,12,wide\n", ":1: invalid station: Hamburg,12,wide"},
+		{"negative stddev", "name,mean,stddev\nHamburg,12,-3\n", ":2: invalid station: Hamburg,12,-3"},
+		{"name with separator", "Ham;burg,12,3\n", ":1: invalid station: Ham;burg,12,3"},
+		{"empty name", "Hamburg,12,3\n ,12,3\n", ":2: invalid station: ,12,3"},
+		{"wrong field count", "Hamburg,12,3\nBulawayo,18.9\n", ": record on line 2: wrong number of fields"},
+		{"empty", "", ": no stations"},
+		{"header only", "name,mean,stddev\n", ": no stations"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestInput(t, "stations.csv", tt.input)
+			stations, err := loadStations(path)
+			got := fmt.Sprint(err)
+			if err == nil {
+				described := make([]string, len(stations))
+				for i, s := range stations {
+					described[i] = fmt.Sprintf("%s:%g:%g", s.name, s.mean, s.stddev)
+				}
+				got = fmt.Sprint(described)
+			} else if !strings.HasPrefix(got, path) {
+				t.Errorf("error %q does not start with the path", got)
+			}
+			if got = strings.TrimPrefix(got, path); got != tt.want {
+				t.Errorf("loadStations returned %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := loadStations(filepath.Join(t.TempDir(), "missing.csv")); err == nil {
+		t.Error("loading a missing -stations file succeeded")
+	}
+}
+
+// Checks that generate only writes the stations of -stations, and fails on an invalid file
+func TestGenerateStations(t *testing.T) {
+	path := writeTestInput(t, "stations.csv", "name,mean,stddev\n\"Washington, D.C.\",14.6,5\nHamburg,12,0\n")
+	stdout, code := runCLI(t, "generate", "-stations", path, "-rows", "1000", "-seed", "7", "-out", "-")
+	if code != 0 {
+		t.Fatalf("generate -stations exited with %d", code)
+	}
+	seen := map[string]int{}
+	for _, line := range strings.Split(strings.TrimSuffix(string(stdout), "\n"), "\n") {
+		name, value, _ := strings.Cut(line, ";")
+		if name == "Hamburg" && value != "12.0" {
+			t.Errorf("line %q of a station without spread is not at its mean", line)
+		}
+		seen[name]++
+	}
+	if len(seen) != 2 || seen["Hamburg"]+seen["Washington, D.C."] != 1000 {
+		t.Errorf("generate wrote the stations %v, want only those of -stations", seen)
+	}
+
+	invalid := writeTestInput(t, "invalid.csv", "Hamburg,12,-3\n")
+	if _, code := runCLI(t, "generate", "-stations", invalid, "-rows", "10", "-out", "-"); code != 1 {
+		t.Errorf("generate with an invalid -stations file exited with %d, want 1", code)
+	}
+}
+
+// Checks that -targetSize ends the file with the last whole line that fits the size, within a
+// line, on a line or chunk boundary, across chunks and below the length of a line
+func TestGenerateTargetSize(t *testing.T) {
+	savedSeed, savedSize, savedWorkers := generateSeed, targetSize, workers
+	t.Cleanup(func() { generateSeed, targetSize, workers = savedSeed, savedSize, savedWorkers })
+	generateSeed, targetSize, workers = 42, 1, 2
+	stations := builtinGeneratorStations()
+	chunk := generateChunk(stations, 0)
+	reference := append(chunk, generateChunk(stations, 1)...)
+	firstLine := int64(bytes.IndexByte(reference, '\n') + 1)
+	chunkSize := int64(len(chunk))
+
+	tests := []struct {
+		name string
+		size int64
+	}{
+		{"below a line", firstLine - 1},
+		{"one byte", 1},
+		{"end of a line", firstLine},
+		{"within a line", firstLine + 3},
+		{"end of a chunk", chunkSize},
+		{"within the last line of a chunk", chunkSize - 1},
+		{"across chunks", chunkSize + 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targetSize = tt.size
+			var out bytes.Buffer
+			if err := writeMeasurements(&out, stations); err != nil {
+				t.Fatal(err)
+			}
+			got := out.Bytes()
+			if int64(len(got)) > tt.size || !bytes.HasPrefix(reference, got) {
+				t.Fatalf("wrote %d bytes for -targetSize %d, not a prefix of the generated lines within the size", len(got), tt.size)
+			}
+			if len(got) > 0 && got[len(got)-1] != '\n' {
+				t.Errorf("file of -targetSize %d ends within a line", tt.size)
+			}
+			// The next line would not have fit
+			if next := int64(len(got) + bytes.IndexByte(reference[len(got):], '\n') + 1); next <= tt.size {
+				t.Errorf("wrote %d bytes for -targetSize %d, the next line ending at %d fits too", len(got), tt.size, next)
+			}
+		})
+	}
+}
+
+// Checks the -targetSize flag of generate: sizes with a unit suffix are written, invalid sizes and
+// -targetSize with -rows exit 2
+func TestGenerateTargetSizeFlag(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "measurements.txt")
+	if _, code := runCLI(t, "generate", "-targetSize", "1KiB", "-seed", "1", "-out", path); code != 0 {
+		t.Fatalf("generate -targetSize 1KiB exited with %d", code)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() > 1024 || info.Size() < 1000 {
+		t.Errorf("generate -targetSize 1KiB wrote %v (%v)", info, err)
+	}
+
+	for _, args := range [][]string{
+		{"-targetSize", "13 parsecs"},
+		{"-targetSize", "-1KB"},
+		{"-targetSize", "1MB", "-rows", "10"},
+	} {
+		if _, code := runCLI(t, append([]string{"generate", "-out", path}, args...)...); code != 2 {
+			t.Errorf("generate %v exited with %d, want 2", args, code)
+		}
+	}
+}
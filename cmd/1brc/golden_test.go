@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden files in testdata with the current output")
+
+// Environment variable making the test binary run as the 1brc command instead of the tests
+const runMainEnv = "ONEBRC_TEST_RUN_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// Function to run the 1brc command with args through the test binary, returning its stdout and exit code
+func runCLI(t *testing.T, args ...string) ([]byte, int) {
+	t.Helper()
+	stdout, _, code := runCLIOutput(t, args...)
+	return stdout, code
+}
+
+// Function to run the 1brc command with args through the test binary, returning its stdout,
+// its stderr and its exit code
+func runCLIOutput(t *testing.T, args ...string) ([]byte, []byte, int) {
+	t.Helper()
+	return runCLIInput(t, nil, args...)
+}
+
+// Function to run the 1brc command like runCLIOutput with stdin read from input
+func runCLIInput(t *testing.T, input io.Reader, args ...string) ([]byte, []byte, int) {
+	t.Helper()
+	cmd := cliCommand(args...)
+	cmd.Stdin = input
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		t.Fatalf("running %v: %v", args, err)
+	}
+	if t.Failed() || testing.Verbose() {
+		t.Logf("stderr of %v:\n%s", args, stderr.String())
+	}
+	return stdout.Bytes(), stderr.Bytes(), cmd.ProcessState.ExitCode()
+}
+
+// Function to prepare a run of the 1brc command with args through the test binary
+func cliCommand(args ...string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], args...)
+	for _, env := range os.Environ() {
+		// Keep flags set in the environment of the test out of the runs
+		if !strings.HasPrefix(env, envPrefix) {
+			cmd.Env = append(cmd.Env, env)
+		}
+	}
+	cmd.Env = append(cmd.Env, runMainEnv+"=1")
+	return cmd
+}
+
+// Function to write input to a file in a temporary directory of the test, returning its path
+func writeTestInput(t testing.TB, name, input string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(input), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGolden(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		args   []string
+		golden string // Defaults to name, runs that must print the same share a file
+		code   int
+	}{
+		{name: "basic", input: "basic.txt"},
+		{name: "basic_parallel", input: "basic.txt", args: []string{"-workers", "4", "-batchSize", "2"}, golden: "basic"},
+		{name: "basic_mmap", input: "basic.txt", args: []string{"-mmap", "-workers", "3"}, golden: "basic"},
+		{name: "basic_official", input: "basic.txt", args: []string{"-output", "official"}},
+		{name: "unicode", input: "unicode.txt"},
+		{name: "unicode_json", input: "unicode.txt", args: []string{"-output", "json"}},
+		{name: "boundary", input: "boundary.txt", args: []string{"-precision", "1"}},
+		{name: "boundary_half_up", input: "boundary.txt", args: []string{"-rounding", "halfUp", "-aggs", "min,max,mean,count,sum", "-output", "csv"}},
+		{name: "malformed", input: "malformed.txt"},
+		{name: "malformed_fail", input: "malformed.txt", args: []string{"-onError", "fail"}, code: 1},
+		{name: "bom_crlf", input: "bom_crlf.txt"},
+		{name: "escaping_markdown", input: "escaping.txt", args: []string{"-output", "markdown", "-totals"}},
+		{name: "escaping_prom", input: "escaping.jsonl", args: []string{"-format", "jsonl", "-output", "prom", "-percentiles", "50,99"}},
+		{name: "escaping_template", input: "escaping.txt", args: []string{"-percentiles", "50", "-outputTemplate", `{{.Letter}} {{printf "%q" .Name}} {{.Min}}/{{.Mean}}/{{.Max}} n={{.Count}} p50={{index .Percentiles "p50"}}`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"run", "-file", filepath.Join("testdata", tt.input)}, tt.args...)
+			got, code := runCLI(t, args...)
+			if code != tt.code {
+				t.Errorf("exit code %d, want %d", code, tt.code)
+			}
+
+			golden := tt.golden
+			if golden == "" {
+				golden = tt.name
+			}
+			path := filepath.Join("testdata", golden+".golden")
+			if *update && tt.golden == "" {
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("output differs from %s\n got:\n%s\nwant:\n%s", path, got, want)
+			}
+		})
+	}
+}
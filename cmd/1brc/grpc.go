@@ -0,0 +1,365 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/mod/internal/parse"
+	"example.com/mod/internal/stats"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Path prefix of the methods of the Results service of results.proto
+const grpcServicePath = "/onebrc.v1.Results/"
+
+// Largest message the -grpc server accepts, as the 4 MiB default of gRPC
+const maxGRPCMessage = 4 << 20
+
+// Status codes of gRPC the server answers with
+const (
+	grpcOK              = 0
+	grpcInvalidArgument = 3
+	grpcNotFound        = 5
+	grpcUnimplemented   = 12
+	grpcInternal        = 13
+	grpcUnavailable     = 14
+)
+
+// Struct to hold a gRPC status the methods fail with
+type grpcError struct {
+	code    int
+	message string
+}
+
+// Function to return the status message, as the grpc-message trailer carries it
+func (e *grpcError) Error() string {
+	return e.message
+}
+
+// Function to start serving the Results service of results.proto on addr over unencrypted HTTP/2,
+// the transport of plaintext gRPC. The methods answer UNAVAILABLE until publishResults is called
+func startGRPCServer(addr string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	server := &http.Server{Handler: http.HandlerFunc(serveGRPC), Protocols: protocols, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("gRPC server stopped", "err", err)
+		}
+	}()
+	slog.Info("serving gRPC", "addr", listener.Addr().String(), "service", "onebrc.v1.Results")
+	return server, nil
+}
+
+// Function to dispatch a gRPC call to its method and finish it with the status trailers
+func serveGRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC calls only", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+
+	var err error
+	switch strings.TrimPrefix(r.URL.Path, grpcServicePath) {
+	case "GetStation":
+		err = grpcGetStation(w, r.Body)
+	case "ListStations":
+		err = grpcListStations(w, r.Body)
+	case "Ingest":
+		err = grpcIngest(w, r.Body)
+	default:
+		err = &grpcError{grpcUnimplemented, "unknown method " + r.URL.Path}
+	}
+
+	code, message := grpcOK, ""
+	if err != nil {
+		code, message = grpcInternal, err.Error()
+		var status *grpcError
+		if errors.As(err, &status) {
+			code = status.code
+		}
+	}
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set("Grpc-Message", grpcPercentEncode(message))
+	}
+}
+
+// Function to answer GetStation with the stats of the requested station
+func grpcGetStation(w io.Writer, body io.Reader) error {
+	message, err := readGRPCMessage(body)
+	if err != nil {
+		return err
+	}
+	name, err := decodeName(message)
+	if err != nil {
+		return err
+	}
+	results, err := grpcResults()
+	if err != nil {
+		return err
+	}
+	r, found := findResult(results, name)
+	if !found {
+		return &grpcError{grpcNotFound, fmt.Sprintf("no station %q", name)}
+	}
+	return writeGRPCMessage(w, encodeStation(r))
+}
+
+// Function to answer ListStations with a stream of the stats of every station, sorted by name
+func grpcListStations(w io.Writer, body io.Reader) error {
+	if _, err := readGRPCMessage(body); err != nil {
+		return err
+	}
+	results, err := grpcResults()
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		if err := writeGRPCMessage(w, encodeStation(r)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function to answer Ingest: fold every streamed reading into a table of the call, parsed and
+// checked against -validateRange like the rows of the inputs, then publish the published results
+// with the table merged in for the other calls and the -serve endpoints once the stream ends
+func grpcIngest(w io.Writer, body io.Reader) error {
+	if _, err := grpcResults(); err != nil {
+		return err
+	}
+
+	// Every call parses with a parser of its own, so the counters of the run and of concurrent
+	// calls stay apart
+	parser := newIngestParser()
+	table := stats.NewTable(tableOptions())
+	var rows, malformed, outOfRange int64
+	for {
+		message, err := readGRPCMessage(body)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name, value, err := decodeMeasurement(message)
+		if err != nil {
+			return err
+		}
+		number := strconv.FormatFloat(value, 'f', -1, 64)
+		name, tenths, err := parser.ParseReading(name, number, name+parser.Delimiter+number)
+		if err != nil {
+			malformed++
+			continue
+		}
+		if !parser.Range.Accept(tenths, 1) {
+			outOfRange++
+			continue
+		}
+		table.Update(name, tenths, 1)
+		rows++
+	}
+	mergePublished(table)
+
+	var summary []byte
+	summary = protowire.AppendTag(summary, 1, protowire.VarintType)
+	summary = protowire.AppendVarint(summary, uint64(rows))
+	summary = protowire.AppendTag(summary, 2, protowire.VarintType)
+	summary = protowire.AppendVarint(summary, uint64(malformed))
+	summary = protowire.AppendTag(summary, 3, protowire.VarintType)
+	summary = protowire.AppendVarint(summary, uint64(outOfRange))
+	return writeGRPCMessage(w, summary)
+}
+
+// Function to create the parser of an Ingest call, reading as the parser of the run does but
+// counting the remapped names and range violations of the call alone
+func newIngestParser() *parse.Parser {
+	p := parse.NewParser()
+	p.Delimiter, p.Unit, p.Aliases = lineParser.Delimiter, lineParser.Unit, lineParser.Aliases
+	if r := lineParser.Range; r != nil {
+		p.Range = &parse.Range{Min: r.Min, Max: r.Max, Keep: r.Keep}
+	}
+	return p
+}
+
+// Function to publish the published results with the stats of table merged in. The published
+// stats are read without locking, so they are copied and never changed; a call that published
+// in the meantime has the merge start over from its results, so concurrent calls lose nothing
+func mergePublished(table *stats.Table) {
+	for {
+		published := servedResults.Load()
+		merged := stats.NewTable(tableOptions())
+		for _, r := range *published {
+			merged.Merge(r.name, r.stats)
+		}
+		merged.MergeTable(table)
+		results := tableResults([]*stats.Table{merged})
+		if servedResults.CompareAndSwap(published, &results) {
+			return
+		}
+	}
+}
+
+// Function to take the published results, failing with UNAVAILABLE while there are none yet
+func grpcResults() ([]result, error) {
+	results := servedResults.Load()
+	if results == nil {
+		return nil, &grpcError{grpcUnavailable, "results are still being aggregated"}
+	}
+	return *results, nil
+}
+
+// Function to read one length-prefixed message of a call, io.EOF once the stream of the client ends
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, &grpcError{grpcInternal, "reading message: " + err.Error()}
+	}
+	if prefix[0] != 0 {
+		return nil, &grpcError{grpcUnimplemented, "compressed messages are not supported"}
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > maxGRPCMessage {
+		return nil, &grpcError{grpcInvalidArgument, fmt.Sprintf("message of %d bytes is larger than %d", size, maxGRPCMessage)}
+	}
+	message := make([]byte, size)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, &grpcError{grpcInternal, "reading message: " + err.Error()}
+	}
+	return message, nil
+}
+
+// Function to write one length-prefixed, uncompressed message and flush it to the client
+func writeGRPCMessage(w io.Writer, message []byte) error {
+	framed := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(framed[1:], uint32(len(message)))
+	if _, err := w.Write(append(framed, message...)); err != nil {
+		return err
+	}
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// Struct to hold a stat of the Station message, in degrees
+type stationField struct {
+	number protowire.Number
+	value  float64
+}
+
+// Function to encode a result as a Station message, in degrees, with the stats -aggs selected as
+// the other outputs print them; the count is always sent
+func encodeStation(r result) []byte {
+	var fields []stationField
+	if aggregates.min {
+		fields = append(fields, stationField{2, float64(r.stats.Min) / 10})
+	}
+	if aggregates.max {
+		fields = append(fields, stationField{3, float64(r.stats.Max) / 10})
+	}
+	if aggregates.mean {
+		fields = append(fields, stationField{4, r.stats.Mean() / 10})
+	}
+	if aggregates.sum {
+		fields = append(fields, stationField{6, r.stats.FloatSum() / 10})
+	}
+
+	b := protowire.AppendTag(nil, 1, protowire.BytesType)
+	b = protowire.AppendString(b, r.name)
+	for _, f := range fields {
+		b = protowire.AppendTag(b, f.number, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(f.value))
+	}
+	b = protowire.AppendTag(b, 5, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(r.stats.Count))
+}
+
+// Function to decode the name of a GetStationRequest
+func decodeName(message []byte) (string, error) {
+	var name string
+	err := decodeFields(message, func(number protowire.Number, typ protowire.Type, value []byte) (int, bool) {
+		if number != 1 || typ != protowire.BytesType {
+			return 0, false
+		}
+		s, n := protowire.ConsumeString(value)
+		name = s
+		return n, true
+	})
+	return name, err
+}
+
+// Function to decode the name and value of a Measurement
+func decodeMeasurement(message []byte) (string, float64, error) {
+	var name string
+	var value float64
+	err := decodeFields(message, func(number protowire.Number, typ protowire.Type, b []byte) (int, bool) {
+		switch {
+		case number == 1 && typ == protowire.BytesType:
+			s, n := protowire.ConsumeString(b)
+			name = s
+			return n, true
+		case number == 2 && typ == protowire.Fixed64Type:
+			bits, n := protowire.ConsumeFixed64(b)
+			value = math.Float64frombits(bits)
+			return n, true
+		}
+		return 0, false
+	})
+	return name, value, err
+}
+
+// Function to walk the fields of a message, handing each to field, which consumes the ones it
+// knows and returns false for the others so they are skipped
+func decodeFields(message []byte, field func(number protowire.Number, typ protowire.Type, value []byte) (int, bool)) error {
+	for len(message) > 0 {
+		number, typ, n := protowire.ConsumeTag(message)
+		if n < 0 {
+			return &grpcError{grpcInvalidArgument, "malformed message: " + protowire.ParseError(n).Error()}
+		}
+		message = message[n:]
+
+		n, known := field(number, typ, message)
+		if !known {
+			n = protowire.ConsumeFieldValue(number, typ, message)
+		}
+		if n < 0 {
+			return &grpcError{grpcInvalidArgument, "malformed message: " + protowire.ParseError(n).Error()}
+		}
+		message = message[n:]
+	}
+	return nil
+}
+
+// Function to percent-encode a status message for the grpc-message trailer, as the gRPC
+// protocol asks for bytes outside printable ASCII and for the percent sign itself
+func grpcPercentEncode(message string) string {
+	var encoded strings.Builder
+	for i := 0; i < len(message); i++ {
+		if c := message[i]; c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&encoded, "%%%02X", c)
+		} else {
+			encoded.WriteByte(c)
+		}
+	}
+	return encoded.String()
+}
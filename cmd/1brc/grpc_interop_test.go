@@ -0,0 +1,107 @@
+//go:build grpcinterop
+
+// Interop test of the -grpc service against a grpc-go client. The module does not depend on
+// grpc-go, so the test is left out of the default build; run it with
+//
+//	go get google.golang.org/grpc && go test -tags grpcinterop -run GRPCInterop ./cmd/1brc
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/mod/internal/stats"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// Checks every method of the -grpc service through the grpc-go client, with the messages of
+// results.proto encoded by the protobuf runtime
+func TestGRPCInterop(t *testing.T) {
+	resetGRPCResults(t, "min,max,mean")
+	fd := loadResultsProto(t)
+	url, _ := startGRPCTestServer(t)
+	conn, err := grpc.NewClient(strings.TrimPrefix(url, "http://"), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	getStation := func(name string) (string, error) {
+		reply := newProtoMessage(fd, "Station", nil)
+		err := conn.Invoke(ctx, "/onebrc.v1.Results/GetStation", newProtoMessage(fd, "GetStationRequest", map[string]any{"name": name}), reply)
+		return describeMessage(reply), err
+	}
+	if _, err := getStation("Hamburg"); status.Code(err) != codes.Unavailable {
+		t.Errorf("GetStation before publishing failed with %v, want UNAVAILABLE", err)
+	}
+
+	statsShards[stats.ShardOf("Hamburg")].Update("Hamburg", 120, 1)
+	publishResults()
+
+	ingest, err := conn.NewStream(ctx, &grpc.StreamDesc{StreamName: "Ingest", ClientStreams: true}, "/onebrc.v1.Results/Ingest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []struct {
+		name  string
+		value float64
+	}{{"Hamburg", -3.4}, {"Bulawayo", 8.9}, {"", 1}} {
+		if err := ingest.SendMsg(newProtoMessage(fd, "Measurement", map[string]any{"name": m.name, "value": m.value})); err != nil {
+			t.Fatalf("sending a measurement: %v", err)
+		}
+	}
+	if err := ingest.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	summary := newProtoMessage(fd, "IngestSummary", nil)
+	if err := ingest.RecvMsg(summary); err != nil || describeMessage(summary) != "rows=2 malformed=1" {
+		t.Errorf("Ingest returned %q with %v, want rows=2 malformed=1", describeMessage(summary), err)
+	}
+
+	if got, err := getStation("Hamburg"); err != nil || got != "name=Hamburg min=-3.4 max=12 mean=4.3 count=2" {
+		t.Errorf("GetStation returned %q with %v", got, err)
+	}
+	if _, err := getStation("Cracow"); status.Code(err) != codes.NotFound {
+		t.Errorf("GetStation of an unknown station failed with %v, want NOT_FOUND", err)
+	}
+
+	list, err := conn.NewStream(ctx, &grpc.StreamDesc{StreamName: "ListStations", ServerStreams: true}, "/onebrc.v1.Results/ListStations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := list.SendMsg(newProtoMessage(fd, "ListStationsRequest", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := list.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	var stations []string
+	for {
+		reply := newProtoMessage(fd, "Station", nil)
+		if err := list.RecvMsg(reply); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("ListStations: %v", err)
+		}
+		stations = append(stations, describeMessage(reply))
+	}
+	want := []string{"name=Bulawayo min=8.9 max=8.9 mean=8.9 count=1", "name=Hamburg min=-3.4 max=12 mean=4.3 count=2"}
+	if fmt.Sprint(stations) != fmt.Sprint(want) {
+		t.Errorf("ListStations streamed %q, want %q", stations, want)
+	}
+
+	reply := newProtoMessage(fd, "Station", nil)
+	if err := conn.Invoke(ctx, "/onebrc.v1.Results/Delete", newProtoMessage(fd, "GetStationRequest", nil), reply); status.Code(err) != codes.Unimplemented {
+		t.Errorf("an unknown method failed with %v, want UNIMPLEMENTED", err)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"example.com/mod/internal/parse"
+	"example.com/mod/internal/stats"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Field of a message of results.proto: an optional label, a scalar type, a name and a number
+var protoField = regexp.MustCompile(`^(optional )?(string|double|int64) (\w+) = (\d+);`)
+
+// Function to build the descriptor of the messages of results.proto, which only hold scalar
+// fields, so the tests encode and decode them with the protobuf runtime rather than with the
+// protowire code of the server
+func loadResultsProto(t testing.TB) protoreflect.FileDescriptor {
+	t.Helper()
+	source, err := os.ReadFile("results.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := map[string]descriptorpb.FieldDescriptorProto_Type{
+		"string": descriptorpb.FieldDescriptorProto_TYPE_STRING,
+		"double": descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
+		"int64":  descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	}
+	file := &descriptorpb.FileDescriptorProto{Name: proto.String("results.proto"), Syntax: proto.String("proto3")}
+	var message *descriptorpb.DescriptorProto
+	scanner := bufio.NewScanner(bytes.NewReader(source))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "package "):
+			file.Package = proto.String(strings.TrimSuffix(strings.TrimPrefix(line, "package "), ";"))
+		case strings.HasPrefix(line, "message "):
+			message = &descriptorpb.DescriptorProto{Name: proto.String(strings.Fields(line)[1])}
+			file.MessageType = append(file.MessageType, message)
+		case message != nil && protoField.MatchString(line):
+			m := protoField.FindStringSubmatch(line)
+			number, _ := strconv.Atoi(m[4])
+			field := &descriptorpb.FieldDescriptorProto{
+				Name:     proto.String(m[3]),
+				JsonName: proto.String(m[3]),
+				Number:   proto.Int32(int32(number)),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:     types[m[2]].Enum(),
+			}
+			if m[1] != "" {
+				// proto3 optional fields sit in a synthetic oneof of their own
+				field.Proto3Optional = proto.Bool(true)
+				field.OneofIndex = proto.Int32(int32(len(message.OneofDecl)))
+				message.OneofDecl = append(message.OneofDecl, &descriptorpb.OneofDescriptorProto{Name: proto.String("_" + m[3])})
+			}
+			message.Field = append(message.Field, field)
+		}
+	}
+	descriptor, err := protodesc.NewFile(file, nil)
+	if err != nil {
+		t.Fatalf("results.proto: %v", err)
+	}
+	return descriptor
+}
+
+// Function to create a message of results.proto with the given fields set
+func newProtoMessage(fd protoreflect.FileDescriptor, name string, fields map[string]any) *dynamicpb.Message {
+	message := dynamicpb.NewMessage(fd.Messages().ByName(protoreflect.Name(name)))
+	for field, value := range fields {
+		message.Set(message.Descriptor().Fields().ByName(protoreflect.Name(field)), protoreflect.ValueOf(value))
+	}
+	return message
+}
+
+// Function to describe a message of results.proto by its set fields, as "name=Hamburg min=-3.4"
+func describeMessage(message *dynamicpb.Message) string {
+	var fields []string
+	descriptors := message.Descriptor().Fields()
+	for i := range descriptors.Len() {
+		if field := descriptors.Get(i); message.Has(field) {
+			fields = append(fields, fmt.Sprintf("%s=%v", field.Name(), message.Get(field).Interface()))
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// Function to make a gRPC call over unencrypted HTTP/2, framing and marshalling the requests as a
+// gRPC client does, returning the responses decoded as the reply message and the grpc-status trailer
+func callGRPC(t *testing.T, client *http.Client, url, method, reply string, requests ...proto.Message) ([]string, string) {
+	t.Helper()
+	var body bytes.Buffer
+	for _, request := range requests {
+		message, err := proto.Marshal(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body.WriteByte(0)
+		binary.Write(&body, binary.BigEndian, uint32(len(message)))
+		body.Write(message)
+	}
+	req, err := http.NewRequest(http.MethodPost, url+"/onebrc.v1.Results/"+method, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("%s: %v", method, err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "application/grpc" {
+		t.Errorf("%s answered with content type %q", method, got)
+	}
+
+	fd := loadResultsProto(t)
+	var responses []string
+	for {
+		var prefix [5]byte
+		if _, err := io.ReadFull(resp.Body, prefix[:]); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		message := make([]byte, binary.BigEndian.Uint32(prefix[1:]))
+		if _, err := io.ReadFull(resp.Body, message); err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		decoded := newProtoMessage(fd, reply, nil)
+		if err := proto.Unmarshal(message, decoded); err != nil {
+			t.Fatalf("%s answered with a %s that does not decode: %v", method, reply, err)
+		}
+		responses = append(responses, describeMessage(decoded))
+	}
+	return responses, resp.Trailer.Get("Grpc-Status")
+}
+
+// Function to serve the -grpc handler on an unencrypted HTTP/2 test server, returning its URL and a
+// client speaking HTTP/2 to it without TLS
+func startGRPCTestServer(t *testing.T) (string, *http.Client) {
+	t.Helper()
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(serveGRPC))
+	server.Config.Protocols = protocols
+	server.Start()
+	t.Cleanup(server.Close)
+	return server.URL, &http.Client{Transport: &http.Transport{Protocols: protocols}}
+}
+
+// Function to start every gRPC test with empty published results and the given -aggs
+func resetGRPCResults(t *testing.T, aggs string) {
+	t.Helper()
+	aggregates, _ = parseAggregates(aggs)
+	servedResults.Store(nil)
+	for i := range statsShards {
+		statsShards[i] = stats.NewTable(tableOptions())
+	}
+	t.Cleanup(func() {
+		aggregates, _ = parseAggregates("min,max,mean")
+		servedResults.Store(nil)
+		statsShards = [stats.ShardCount]*stats.Table{}
+		lineParser.Range = nil
+		lineParser.Unit = "C"
+	})
+}
+
+// Checks the methods of the -grpc service, Ingest folding readings into what the others return
+func TestGRPCService(t *testing.T) {
+	resetGRPCResults(t, "min,max,mean")
+	fd := loadResultsProto(t)
+	url, client := startGRPCTestServer(t)
+	station := func(name string) proto.Message {
+		return newProtoMessage(fd, "GetStationRequest", map[string]any{"name": name})
+	}
+	measurement := func(name string, value float64) proto.Message {
+		return newProtoMessage(fd, "Measurement", map[string]any{"name": name, "value": value})
+	}
+
+	if _, status := callGRPC(t, client, url, "GetStation", "Station", station("Hamburg")); status != "14" {
+		t.Errorf("GetStation before publishing ended with status %s, want 14 (UNAVAILABLE)", status)
+	}
+
+	statsShards[stats.ShardOf("Hamburg")].Update("Hamburg", 120, 1)
+	publishResults()
+	published := servedResults.Load()
+	lineParser.Range = &parse.Range{Min: -50, Max: 50}
+	responses, status := callGRPC(t, client, url, "Ingest", "IngestSummary",
+		measurement("Hamburg", -3.4), measurement(" Bulawayo ", 8.9), measurement("", 1), measurement("Far", 1e9), measurement("Hot", 60))
+	if want := "rows=2 malformed=2 out_of_range=1"; status != "0" || len(responses) != 1 || responses[0] != want {
+		t.Errorf("Ingest returned %q with status %s, want %q", responses, status, want)
+	}
+
+	// The results published before stay as they were for the readers still holding them, the
+	// counters of the run are left alone
+	if len(*published) != 1 || (*published)[0].stats.Count != 1 || statsShards[stats.ShardOf("Hamburg")].Len() != 1 {
+		t.Errorf("Ingest changed the results published before it: %+v", *published)
+	}
+	if violations := lineParser.Range.Violations.Load(); violations != 0 {
+		t.Errorf("Ingest counted %d range violations of the run", violations)
+	}
+
+	responses, status = callGRPC(t, client, url, "GetStation", "Station", station("Hamburg"))
+	if want := "name=Hamburg min=-3.4 max=12 mean=4.3 count=2"; status != "0" || len(responses) != 1 || responses[0] != want {
+		t.Errorf("GetStation returned %q with status %s, want %q", responses, status, want)
+	}
+	if _, status := callGRPC(t, client, url, "GetStation", "Station", station("Cracow")); status != "5" {
+		t.Errorf("GetStation of an unknown station ended with status %s, want 5 (NOT_FOUND)", status)
+	}
+
+	responses, status = callGRPC(t, client, url, "ListStations", "Station", newProtoMessage(fd, "ListStationsRequest", nil))
+	want := []string{"name=Bulawayo min=8.9 max=8.9 mean=8.9 count=1", "name=Hamburg min=-3.4 max=12 mean=4.3 count=2"}
+	if status != "0" || fmt.Sprint(responses) != fmt.Sprint(want) {
+		t.Errorf("ListStations returned %q with status %s, want %q", responses, status, want)
+	}
+
+	lineParser.Unit = "F"
+	callGRPC(t, client, url, "Ingest", "IngestSummary", measurement("Cracow", 50))
+	responses, status = callGRPC(t, client, url, "GetStation", "Station", station("Cracow"))
+	if want := "name=Cracow min=10 max=10 mean=10 count=1"; status != "0" || len(responses) != 1 || responses[0] != want {
+		t.Errorf("GetStation after ingesting 50F returned %q with status %s, want %q (10C)", responses, status, want)
+	}
+
+	if _, status := callGRPC(t, client, url, "Delete", "Station"); status != "12" {
+		t.Errorf("an unknown method ended with status %s, want 12 (UNIMPLEMENTED)", status)
+	}
+}
+
+// Checks that the Station messages only carry the stats -aggs selects, as the other outputs print
+func TestGRPCAggregates(t *testing.T) {
+	resetGRPCResults(t, "count,sum")
+	fd := loadResultsProto(t)
+	url, client := startGRPCTestServer(t)
+	statsShards[stats.ShardOf("Hamburg")].Update("Hamburg", 120, 3)
+	publishResults()
+
+	responses, status := callGRPC(t, client, url, "GetStation", "Station", newProtoMessage(fd, "GetStationRequest", map[string]any{"name": "Hamburg"}))
+	if want := "name=Hamburg count=3 sum=36"; status != "0" || len(responses) != 1 || responses[0] != want {
+		t.Errorf("GetStation with -aggs count,sum returned %q with status %s, want %q", responses, status, want)
+	}
+}
+
+// Checks that concurrent Ingest calls all end up in the results, none publishing over another
+func TestGRPCConcurrentIngest(t *testing.T) {
+	resetGRPCResults(t, "min,max,mean")
+	fd := loadResultsProto(t)
+	url, client := startGRPCTestServer(t)
+	publishResults()
+
+	const calls, readings = 8, 50
+	var wg sync.WaitGroup
+	for range calls {
+		wg.Go(func() {
+			var stream []proto.Message
+			for range readings {
+				stream = append(stream, newProtoMessage(fd, "Measurement", map[string]any{"name": "Hamburg", "value": 1.5}))
+			}
+			if _, status := callGRPC(t, client, url, "Ingest", "IngestSummary", stream...); status != "0" {
+				t.Errorf("Ingest ended with status %s", status)
+			}
+		})
+	}
+	wg.Wait()
+
+	responses, _ := callGRPC(t, client, url, "GetStation", "Station", newProtoMessage(fd, "GetStationRequest", map[string]any{"name": "Hamburg"}))
+	if want := fmt.Sprintf("name=Hamburg min=1.5 max=1.5 mean=1.5 count=%d", calls*readings); len(responses) != 1 || responses[0] != want {
+		t.Errorf("after %d concurrent calls GetStation returned %q, want %q", calls, responses, want)
+	}
+}
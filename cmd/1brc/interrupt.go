@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Exit codes of a run cut short by SIGINT or SIGTERM and by -timeout, after printing the partial results
+const (
+	exitInterrupted = 130
+	exitTimedOut    = 124
+)
+
+// Causes a run is cancelled with
+var (
+	errInterrupted = errors.New("interrupted")
+	errTimedOut    = errors.New("-timeout exceeded")
+)
+
+// Set once the run context is done, for reporting partial results after the context is released;
+// the readers and workers check the context itself
+var cancelled atomic.Bool
+
+// Function to start the context of a run, cancelled by the first SIGINT or SIGTERM (a second
+// one kills the process as usual) or once timeout passes when it is positive. Cancelling stops
+// the readers, and the workers between two lines of their batch. The returned function
+// releases the context once reading is done, without marking the run cancelled
+func startRunContext(timeout time.Duration) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	var stopTimer context.CancelFunc = func() {}
+	if timeout > 0 {
+		ctx, stopTimer = context.WithTimeoutCause(ctx, timeout, errTimedOut)
+	}
+	stopWatching := context.AfterFunc(ctx, func() {
+		cancelled.Store(true)
+		slog.Warn("stopping to print partial results", "reason", context.Cause(ctx))
+	})
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		defer signal.Stop(signals)
+		select {
+		case <-signals:
+			cancel(errInterrupted)
+		case <-done:
+		}
+	}()
+
+	return ctx, func() {
+		stopWatching()
+		close(done)
+		stopTimer()
+		cancel(nil)
+	}
+}
+
+// Function to pick the exit code of a cancelled run from its cause
+func cancelledExitCode(ctx context.Context) int {
+	if errors.Is(context.Cause(ctx), errTimedOut) {
+		return exitTimedOut
+	}
+	return exitInterrupted
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Function to run the 1brc command with args on an endless stream of readings on stdin, so only
+// cancelling stops it; onStderr sees every line the run logs as it does. It returns the stdout,
+// the stderr and the exit code of the run
+func runCLIStream(t *testing.T, onStderr func(cmd *exec.Cmd, line string), args ...string) ([]byte, string, int) {
+	t.Helper()
+	cmd := cliCommand(args...)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// A run that does not stop fails the test instead of hanging it
+	watchdog := time.AfterFunc(30*time.Second, func() { cmd.Process.Kill() })
+	defer watchdog.Stop()
+
+	go func() {
+		chunk := []byte(strings.Repeat("Hamburg;12.0\nBulawayo;-3.4\nPalembang;38.8\n", 1000))
+		for {
+			if _, err := stdin.Write(chunk); err != nil {
+				return
+			}
+		}
+	}()
+
+	var logged strings.Builder
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		logged.WriteString(scanner.Text() + "\n")
+		onStderr(cmd, scanner.Text())
+	}
+	err = cmd.Wait()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		t.Fatalf("running %v: %v", args, err)
+	}
+	if t.Failed() || testing.Verbose() {
+		t.Logf("stderr of %v:\n%s", args, logged.String())
+	}
+	return stdout.Bytes(), logged.String(), cmd.ProcessState.ExitCode()
+}
+
+// Checks that SIGINT in the middle of a large input stops the run with the partial results
+// printed, a note that they are partial and exit code 130
+func TestInterrupt(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("SIGINT cannot be sent to a process on Windows")
+	}
+	interrupted := false
+	stdout, stderr, code := runCLIStream(t, func(cmd *exec.Cmd, line string) {
+		// Interrupt once the progress events show rows were read
+		if !interrupted && strings.HasPrefix(line, "{") && !strings.Contains(line, `"rows":0,`) {
+			interrupted = true
+			if err := cmd.Process.Signal(os.Interrupt); err != nil {
+				t.Error(err)
+			}
+		}
+	}, "-progress-json", "stderr", "-aggs", "min,max,mean,count")
+	if !interrupted {
+		t.Fatalf("the run exited with %d before reading any rows", code)
+	}
+	if code != exitInterrupted {
+		t.Errorf("interrupted run exited with %d, want %d", code, exitInterrupted)
+	}
+	if !strings.Contains(stderr, "results are partial") || !strings.Contains(stderr, "reason=interrupted") {
+		t.Errorf("interrupted run did not note its results are partial:\n%s", stderr)
+	}
+	checkPartialResults(t, stdout, true)
+}
+
+// Function to check the partial results of a cancelled run over the stream of runCLIStream: the
+// stations of the stream, or nothing when no rows were read before it stopped
+func checkPartialResults(t *testing.T, stdout []byte, rowsRead bool) {
+	t.Helper()
+	if len(stdout) == 0 && !rowsRead {
+		return
+	}
+	lines := strings.Split(strings.TrimSuffix(string(stdout), "\n"), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "Letter: b, Name: Bulawayo, Min: -3.40, Max: -3.40, Avg: -3.40, Count: ") ||
+		!strings.HasPrefix(lines[1], "Letter: h, Name: Hamburg, Min: 12.00, Max: 12.00, Avg: 12.00, Count: ") ||
+		!strings.HasPrefix(lines[2], "Letter: p, Name: Palembang, Min: 38.80, Max: 38.80, Avg: 38.80, Count: ") {
+		t.Errorf("partial results are\n%s", stdout)
+	}
+}
+
+// Checks that -timeout stops a run that cannot finish in time with exit code 124, a note that
+// its results are partial and whatever was read before the deadline
+func TestTimeout(t *testing.T) {
+	stdout, stderr, code := runCLIStream(t, func(*exec.Cmd, string) {}, "-timeout", "1ms", "-aggs", "min,max,mean,count")
+	if code != exitTimedOut {
+		t.Errorf("run past -timeout exited with %d, want %d", code, exitTimedOut)
+	}
+	if !strings.Contains(stderr, "results are partial") || !strings.Contains(stderr, `reason="-timeout exceeded"`) {
+		t.Errorf("run past -timeout did not note its results are partial:\n%s", stderr)
+	}
+	checkPartialResults(t, stdout, false)
+
+	// A run finishing in time is not partial
+	path := writeTestInput(t, "measurements.txt", "Hamburg;12.0\n")
+	if _, stderr, code := runCLIOutput(t, "-file", path, "-timeout", "1m"); code != 0 || strings.Contains(string(stderr), "partial") {
+		t.Errorf("run within -timeout exited with %d and logged\n%s", code, stderr)
+	}
+}
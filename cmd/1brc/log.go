@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Function to route the diagnostics to stderr through slog at the level picked by -logLevel,
+// -v (debug) or -q (errors only), keeping stdout for the results alone
+func setupLogging() error {
+	if verbose && quiet {
+		return errors.New("-v and -q cannot be combined")
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.ToLower(logLevel))); err != nil {
+		return errors.New("-logLevel must be debug, info, warn or error")
+	}
+	if verbose {
+		level = slog.LevelDebug
+	}
+	if quiet {
+		level = slog.LevelError
+	}
+
+	slog.SetDefault(newLogger(level))
+	return nil
+}
+
+// Function to create a logger writing level=... msg=... lines from level up to stderr
+func newLogger(level slog.Level) *slog.Logger {
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			// Timestamps only clutter the output of a single run
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+}
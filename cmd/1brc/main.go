@@ -0,0 +1,1219 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"runtime/trace"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"example.com/mod/internal/output"
+	"example.com/mod/internal/parse"
+	"example.com/mod/internal/stats"
+	"example.com/mod/onebrc"
+)
+
+var (
+	batchSize          int           // Batch size for processing rows
+	workers            int           // Number of goroutines aggregating in parallel, independent of the batch size
+	ioWorkers          int           // Number of goroutines reading blocks of a local file for the workers, 0 reads a range per worker
+	maxOpenFiles       int           // Number of input descriptors open at once, the byte ranges beyond it sharing one
+	inputFiles         inputList     // Paths or glob patterns of the input files, "-" reads from stdin
+	outOfRange         string        // What happens to readings outside -validateRange: reject or flag
+	rejectPath         string        // File malformed lines are written to with their offset and reason
+	onError            string        // Policy for malformed lines: skip, fail or collect
+	inputFormat        string        // Input format: text lines, csv records that may span lines, jsonl objects or parquet columns
+	nameField          string        // Field of a jsonl object or column of a parquet file holding the name
+	valueField         string        // Field of a jsonl object or column of a parquet file holding the number
+	quoted             bool          // Parse lines as RFC 4180 records so quoted fields may contain the delimiter
+	precision          string        // Decimals printed for min, max and avg, uniform or per field
+	groupCol           int           // Index of an optional category column grouped with the name (-1 disables)
+	groupBy            string        // Comma-separated indexes of the columns forming the key of each row
+	valueCol           int           // Index of the column holding the number
+	checkpointPath     string        // File the progress of the run is periodically saved to
+	progressJSON       string        // File, or stderr, the progress events of the run are written to as JSON lines
+	checkpointInterval time.Duration // Time between two checkpoints
+	resume             bool          // Continue from the checkpoint instead of starting over
+	skipLines          int           // Number of leading lines (headers, comments) skipped in every input
+	readBuffer         int           // Size of the blocks each line reader reads the input in
+	maxLineLength      int           // Longest line a line reader accepts before failing
+	useMmap            bool          // Memory-map the input instead of reading it through a scanner
+	aliasFile          string        // Path to an optional file mapping raw names to canonical names
+	delimiter          string        // Field separator, or "auto" to detect it from the first data line
+	explain            bool          // Report decisions taken automatically, such as the detected delimiter
+	inputUnit          string        // Unit of the values in the input (C, F or K), converted to Celsius on read
+	maxOutputBytes     int64         // Upper bound on the number of bytes written as results (0 is unlimited)
+	weightCol          int           // Index of an optional column holding the number of readings per row (-1 disables)
+	showSpread         bool          // Also print the variance and standard deviation of each name
+	percentileSpec     string        // Comma-separated percentiles to estimate for each name, empty disables
+	histogramSpec      string        // Bucket boundaries of the histogram collected for each name, empty disables
+	topN               int           // Number of most extreme names printed (0 prints all of them)
+	topBy              string        // Stat ranking the names for -top: avg, max, min or count
+	sortBy             string        // Order of the printed names: name, or a stat as for -by
+	aggregateSpec      string        // Comma-separated aggregates computed and printed for each name
+	estimateDistinct   bool          // Only estimate the number of distinct names instead of aggregating them
+	outputFormat       string        // Format of the results: text, official, json, csv, table, markdown, prom or parquet
+	outPath            string        // File the results are written to instead of stdout
+	showTotals         bool          // End -output table with a row aggregating all names
+	rounding           string        // How min, mean, max and sum are rounded: float or halfUp
+	showSummary        bool          // Print rows, stations, bytes and throughput of the run to stderr
+	outputTemplate     string        // text/template applied to each result row instead of an -output format
+	gcPercent          int           // GOGC percentage set at startup, -1 disables the collector
+	memLimit           string        // Soft memory limit set at startup, such as 4GiB
+	cpuProfilePath     string        // File a CPU profile of the processing phase is written to
+	memProfilePath     string        // File a heap profile taken at the end of the processing phase is written to
+	runTimeout         time.Duration // Time budget of reading, after which the partial results are printed (0 is unlimited)
+	benchRuns          int           // Number of timed runs over the input instead of printing results, 0 disables
+	benchParsers       int           // Number of leading lines whose readings are timed through every value parser instead of a run, 0 disables
+	tracePath          string        // File an execution trace of the processing phase is written to
+	pprofAddr          string        // Address the live pprof endpoints are served on during the run
+	validateOnly       bool          // Only parse and check the inputs, skipping aggregation and output
+	showVersion        bool          // Print the version and build information instead of running
+	configPath         string        // YAML or TOML file holding flag values, the command line overrides them
+	logLevel           string        // Lowest level of the diagnostics logged to stderr: debug, info, warn or error
+	verbose            bool          // Log debug diagnostics, short for -logLevel debug
+	quiet              bool          // Log errors only, short for -logLevel error
+	expectedPath       string        // Baseline output the verify command compares the results with
+	verifyTolerance    float64       // Largest difference between a number of the results and of the baseline
+	sequential         bool          // Aggregate with the simple single-threaded reference implementation
+	selfVerify         bool          // Aggregate the inputs again in one pass without any merge and compare the results
+	aggregatorName     string        // Registered onebrc aggregator the readings are folded into instead of the built-in tables
+	serveAddr          string        // Address the JSON query endpoints are served on once the results are in
+	grpcAddr           string        // Address the gRPC results service is served on once the results are in
+)
+
+// List of input paths collected from repeated -file flags
+type inputList []string
+
+// Function to print the list of inputs for the flag package
+func (l *inputList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Function to append another input from a repeated -file flag
+func (l *inputList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// Struct to hold the band of plausible readings checked by -validateRange
+type readingRange struct {
+	enabled  bool
+	min, max float64
+}
+
+// Band used when -validateRange is given without bounds, the range of the 1BRC data
+var defaultReadingRange = readingRange{min: -99.9, max: 99.9}
+
+// Band set with -validateRange
+var validRange = defaultReadingRange
+
+// Function to print the band for the flag package
+func (r *readingRange) String() string {
+	if r == nil || !r.enabled {
+		return ""
+	}
+	return strconv.FormatFloat(r.min, 'f', -1, 64) + "," + strconv.FormatFloat(r.max, 'f', -1, 64)
+}
+
+// Function to let -validateRange be given alone, selecting the default band
+func (r *readingRange) IsBoolFlag() bool {
+	return true
+}
+
+// Function to parse -validateRange as "true" (the default band) or "min,max"
+func (r *readingRange) Set(value string) error {
+	switch value {
+	case "true":
+		*r = defaultReadingRange
+		r.enabled = true
+		return nil
+	case "false":
+		r.enabled = false
+		return nil
+	}
+
+	bounds := strings.Split(value, ",")
+	if len(bounds) != 2 {
+		return fmt.Errorf("expected min,max but got %q", value)
+	}
+	low, err := strconv.ParseFloat(strings.TrimSpace(bounds[0]), 64)
+	if err != nil {
+		return fmt.Errorf("invalid minimum %q", bounds[0])
+	}
+	high, err := strconv.ParseFloat(strings.TrimSpace(bounds[1]), 64)
+	if err != nil {
+		return fmt.Errorf("invalid maximum %q", bounds[1])
+	}
+	if low > high {
+		return fmt.Errorf("minimum %v is above maximum %v", low, high)
+	}
+	*r = readingRange{enabled: true, min: low, max: high}
+	return nil
+}
+
+// Function to count the readings outside -validateRange so far
+func outOfRangeReadings() int64 {
+	if lineParser.Range == nil {
+		return 0
+	}
+	return lineParser.Range.Violations.Load()
+}
+
+// Struct to hold the number of decimals printed for each output field
+type FieldPrecision struct {
+	min, max, mean int
+}
+
+// Parsed output precision
+var fieldPrecision FieldPrecision
+
+// Parser of the rows set up from the parsing flags, the alias file and -validateRange once they
+// are checked; the delimiter detected by -delimiter auto replaces "auto" in it
+var lineParser = parse.NewParser()
+
+// Columns forming the key of each row parsed from -groupBy, the first one holds the name
+var groupColumns = []int{0}
+
+// Ascending bucket boundaries in tenths parsed from -histogram; n boundaries make n+1 buckets,
+// each boundary being the inclusive lower end of the bucket above it
+var histogramBounds []int16
+
+// Struct to hold which aggregates -aggs selected
+type aggregateSet struct {
+	min, max, mean, count, sum bool
+}
+
+// Aggregates parsed from -aggs
+var aggregates aggregateSet
+
+// Percentiles (between 0 and 100) parsed from -percentiles
+var percentiles []float64
+
+// Merged stats of every name, sharded by hash and filled once reading finishes
+var statsShards [stats.ShardCount]*stats.Table
+
+// Tables of the stats each worker aggregates on its own without any locking, merged into
+// statsShards after the last input; runCommand creates it once the flags are parsed
+var workerTables = stats.NewSet(stats.Options{})
+
+// Function to select what the stats tables compute from -aggs, -stddev, -percentiles,
+// -histogram, -distinct and -validate
+func tableOptions() stats.Options {
+	return stats.Options{
+		Min:             aggregates.min,
+		Max:             aggregates.max,
+		Sum:             aggregates.mean || aggregates.sum,
+		Spread:          showSpread,
+		Percentiles:     len(percentiles) > 0,
+		HistogramBounds: histogramBounds,
+		Distinct:        estimateDistinct,
+		RowsOnly:        validateOnly,
+	}
+}
+
+// Number of lines the workers and readers go through between checks of the run context
+const cancelCheckLines = 256
+
+// Function to parse a single row starting at offset in the input and fold it into a worker's table.
+// The line stays in the reader's buffer: parsing only slices it, the table copies a name it has
+// not seen before and errors format the line into their message, so nothing is allocated per row
+func processLine(table *stats.Table, line []byte, offset int64) {
+	text := parse.ByteString(line)
+	name, tenths, weight, err := lineParser.ParseLine(text)
+	if err != nil {
+		reportParseError(err, text, offset)
+		return
+	}
+	if !lineParser.Range.Accept(tenths, weight) {
+		return
+	}
+	table.Update(name, tenths, weight)
+}
+
+// Function to fold an already split record (from -format csv) starting at offset into a worker's table
+func processRecord(table *stats.Table, fields []string, offset int64) {
+	line := strings.Join(fields, lineParser.Delimiter)
+	name, tenths, weight, err := lineParser.ParseFields(fields, line)
+	if err != nil {
+		reportParseError(err, line, offset)
+		return
+	}
+	if !lineParser.Range.Accept(tenths, weight) {
+		return
+	}
+	table.Update(name, tenths, weight)
+}
+
+// Function to load an alias file with one "raw name;canonical name" pair per line
+func loadAliases(path string) (map[string]string, error) {
+	file, err := openInputFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	result := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		// Skip blank lines and comments
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.Split(line, ";")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s:%d: invalid alias: %s", path, lineNumber, line)
+		}
+		raw := strings.TrimSpace(parts[0])
+		canonical := strings.TrimSpace(parts[1])
+		if raw == "" || canonical == "" {
+			return nil, fmt.Errorf("%s:%d: invalid alias: %s", path, lineNumber, line)
+		}
+		result[raw] = canonical
+	}
+	return result, scanner.Err()
+}
+
+// Function to parse a comma-separated list of zero-based column indexes such as "0,1"
+func parseColumns(spec string) ([]int, error) {
+	var columns []int
+	for _, field := range strings.Split(spec, ",") {
+		col, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || col < 0 {
+			return nil, fmt.Errorf("invalid column: %s", field)
+		}
+		if slices.Contains(columns, col) {
+			return nil, fmt.Errorf("column %d is grouped twice", col)
+		}
+		columns = append(columns, col)
+	}
+	return columns, nil
+}
+
+// Function to build the parser of the rows from the parsing flags, the aliases and -validateRange
+func newLineParser(aliases map[string]string) *parse.Parser {
+	p := parse.NewParser()
+	p.Delimiter, p.Quoted, p.Format = delimiter, quoted, inputFormat
+	p.NameField, p.ValueField = nameField, valueField
+	p.GroupColumns, p.ValueColumn, p.WeightColumn = groupColumns, valueCol, weightCol
+	p.Unit, p.Aliases = inputUnit, aliases
+	if validRange.enabled {
+		p.Range = &parse.Range{Min: validRange.min, Max: validRange.max, Keep: outOfRange == "flag"}
+	}
+	return p
+}
+
+// Separators that can be given to -delimiter by name, for those awkward to pass on a command line
+var namedDelimiters = map[string]string{
+	"tab":       "\t",
+	`\t`:        "\t",
+	"comma":     ",",
+	"pipe":      "|",
+	"semicolon": ";",
+	"space":     " ",
+}
+
+// Function to turn a -delimiter value into the separator it names
+func delimiterByName(value string) string {
+	if named, ok := namedDelimiters[strings.ToLower(value)]; ok {
+		return named
+	}
+	return value
+}
+
+// Letter printed for the names that do not start with a letter (digits, punctuation, invalid UTF-8)
+const otherLetter = '#'
+
+// Function to pick the letter a name is listed under, its case-folded first letter, without allocating
+func letterOf(name string) rune {
+	first, _ := utf8.DecodeRuneInString(name)
+	if first == utf8.RuneError || !unicode.IsLetter(first) {
+		return otherLetter
+	}
+	return unicode.ToLower(first)
+}
+
+// Function to run the run, bench and verify commands: aggregate the inputs and print the results,
+// time repeated runs over them with bench or compare the results with a baseline with verify
+func runCommand(command string, args []string) {
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: 1brc %s [flags]\n\nFlags:\n", command)
+		fs.PrintDefaults()
+	}
+
+	// Define command-line flags for batch size and file path
+	fs.IntVar(&batchSize, "batchSize", 1000, "Number of lines to process in each batch")
+	fs.IntVar(&workers, "workers", runtime.NumCPU(), "Number of workers aggregating in parallel (byte ranges, stream consumers, Parquet row groups, zstd decoders)")
+	fs.IntVar(&ioWorkers, "io-workers", 0, "Number of goroutines issuing positioned reads of newline-aligned -readBuffer blocks of a local file for the -workers, 0 has each worker read a byte range of its own")
+	fs.IntVar(&maxOpenFiles, "max-open-files", defaultMaxOpenFiles(), "Number of input file descriptors open at once, byte ranges beyond it read through the descriptor of their file, by default half the descriptor limit of the process")
+	fs.Var(&inputFiles, "file", "Path or glob pattern of an input file, or - to read from stdin (repeatable, default -)")
+	fs.StringVar(&progressJSON, "progress-json", "", "File, or stderr, to write a JSON progress event with bytes, total_bytes, rows, pct, elapsed and rate to every 250ms, one per line")
+	fs.StringVar(&checkpointPath, "checkpoint", "", "File to periodically save progress and partial stats to, removed once the run completes")
+	fs.DurationVar(&checkpointInterval, "checkpointInterval", time.Minute, "Time between two checkpoints")
+	fs.BoolVar(&resume, "resume", false, "Continue an interrupted run from the -checkpoint file")
+	fs.IntVar(&skipLines, "skipLines", 0, "Number of leading lines (headers, comments) to skip in every input")
+	fs.IntVar(&readBuffer, "readBuffer", 4<<20, "Size in bytes of the blocks the input is read in and split into lines")
+	fs.IntVar(&maxLineLength, "maxLineLength", 1<<20, "Longest accepted line in bytes, the block buffer grows up to this size when a line does not fit")
+	fs.BoolVar(&useMmap, "mmap", false, "Memory-map the input file instead of reading it through a buffered scanner")
+	fs.StringVar(&aliasFile, "alias-file", "", "Path to a file of \"raw name;canonical name\" lines merging aliased stations")
+	fs.StringVar(&delimiter, "delimiter", ";", "Field separator: a literal string, tab, comma, pipe, semicolon, space, or auto to detect ';', ',' or tab from the first data line")
+	fs.BoolVar(&explain, "explain", false, "Report automatically taken decisions such as the detected delimiter")
+	fs.StringVar(&groupBy, "groupBy", "0", "Comma-separated zero-based indexes of the columns whose values form the key, the first holds the name (e.g. 0,1 for station;sensor;temp)")
+	fs.IntVar(&valueCol, "value-col", 1, "Zero-based index of the column holding the number")
+	fs.IntVar(&groupCol, "group-col", -1, "Zero-based index of a category column to aggregate per (name, category) pair, short for -groupBy 0,N")
+	fs.IntVar(&weightCol, "weight-col", -1, "Zero-based index of a column holding how many readings each row represents")
+	fs.StringVar(&inputUnit, "input-unit", "C", "Unit of the input values (C, F or K); values are converted to Celsius before aggregation")
+	fs.Int64Var(&maxOutputBytes, "max-output-bytes", 0, "Stop with an error instead of writing more than this many bytes of results (0 is unlimited)")
+	fs.Var(&validRange, "validateRange", "Check readings (in Celsius) against a plausible band, -validateRange alone uses -99.9,99.9, -validateRange=min,max sets it")
+	fs.StringVar(&outOfRange, "outOfRange", "reject", "What happens to readings outside -validateRange: reject (leave them out) or flag (aggregate and count them)")
+	fs.StringVar(&rejectPath, "rejectFile", "", "Write malformed lines with their input, byte offset and reason to this file instead of stderr")
+	fs.StringVar(&onError, "onError", onErrorSkip, "Policy for malformed lines: skip (report on stderr and go on), fail (stop with exit code 1) or collect (summarize at the end)")
+	fs.StringVar(&inputFormat, "format", "text", "Input format: text (one row per line), csv (RFC 4180, quoted fields may hold delimiters, quotes and newlines), jsonl (one JSON object per line) or parquet (columns of a local Parquet file)")
+	fs.StringVar(&nameField, "nameField", "station", "Field of -format jsonl objects or column of -format parquet files holding the name")
+	fs.StringVar(&valueField, "valueField", "temp", "Field of -format jsonl objects or column of -format parquet files holding the number")
+	fs.StringVar(&nameField, "jsonName", "station", "Alias of -nameField")
+	fs.StringVar(&valueField, "jsonValue", "temp", "Alias of -valueField")
+	fs.BoolVar(&quoted, "quoted", false, "Parse lines as RFC 4180 records so \"double-quoted\" fields may contain the delimiter (slower)")
+	fs.BoolVar(&showSpread, "stddev", false, "Also print the population variance and standard deviation of each station")
+	fs.StringVar(&percentileSpec, "percentiles", "", "Comma-separated percentiles to estimate per station with a t-digest, e.g. 50,90,99 (costs memory and CPU)")
+	fs.StringVar(&histogramSpec, "histogram", "", "Collect a histogram per station over the given bucket boundaries, e.g. buckets=-20,0,20,40")
+	fs.IntVar(&topN, "top", 0, "Print only the N most extreme stations as ranked by -by (0 prints all)")
+	fs.StringVar(&topBy, "by", "avg", "Stat ranking the stations for -top: avg, max, count (highest first) or min (lowest first)")
+	fs.StringVar(&aggregateSpec, "aggs", "min,max,mean", "Comma-separated aggregates to compute and print per station: min, max, mean, count, sum")
+	fs.BoolVar(&estimateDistinct, "distinct", false, "Only estimate the number of distinct stations with a HyperLogLog sketch (about 0.8% error) instead of aggregating them")
+	fs.StringVar(&outputFormat, "output", "text", "Format of the results: text (one line per station), official (the sorted {name=min/mean/max, ...} line of the 1BRC reference), json (an array of objects), csv (with a header row), table (aligned columns), markdown (a GitHub-flavored markdown table), prom (Prometheus text exposition format, e.g. for a node_exporter textfile collector) or parquet (a Parquet file, best written with -out)")
+	fs.BoolVar(&showTotals, "totals", false, "End -output table or markdown with a totals row over all stations")
+	fs.StringVar(&outPath, "out", "", "Write the results to this file (atomically replaced once complete) instead of stdout")
+	fs.StringVar(&sortBy, "sort", "name", "Order of the printed stations: name, or avg, max, count (highest first) or min (lowest first); -top lists in -by order")
+	fs.StringVar(&rounding, "rounding", "float", "Rounding of min, mean, max and sum: float (the nearest float64, then round half to even) or halfUp (exact halves up as the 1BRC reference does)")
+	fs.BoolVar(&showSummary, "summary", false, "Print an end-of-run summary (rows processed, malformed rows, distinct stations, bytes read, elapsed time, rows/sec) to stderr")
+	fs.StringVar(&precision, "precision", "2", "Decimals to print for min, max and avg (0 prints whole numbers), or per field as min=1,max=1,mean=2")
+
+	fs.StringVar(&outputTemplate, "outputTemplate", "", "Go text/template printed for each station on its own line instead of -output, with .Name, .Letter, .Min, .Max, .Mean, .Count, .Sum, .Variance, .StdDev, .Percentiles (by name, e.g. p99) and .Histogram")
+	fs.IntVar(&gcPercent, "gcpercent", 100, "Garbage collection target percentage as GOGC, -1 disables the collector (not given: GOGC applies)")
+	fs.StringVar(&memLimit, "memlimit", "", "Soft memory limit as GOMEMLIMIT, in bytes or with a B, KiB, MiB, GiB or TiB suffix (empty: GOMEMLIMIT applies)")
+	fs.StringVar(&cpuProfilePath, "cpuprofile", "", "Write a CPU profile of reading and aggregating to this file, for go tool pprof")
+	fs.StringVar(&memProfilePath, "memprofile", "", "Write a heap profile taken once the inputs are aggregated to this file, for go tool pprof")
+	if command == "bench" {
+		fs.IntVar(&benchRuns, "runs", 5, "Number of timed runs over the -file")
+	} else {
+		fs.IntVar(&benchParsers, "bench-parsers", 0, "Time the readings of the first N lines of the -file through every value parser, print the ns/line of each and recommend one instead of the results")
+		fs.IntVar(&benchRuns, "bench", 0, "Time N runs over the -file and print the min and median of its read, parse, aggregate and output phases instead of the results, as the bench command does")
+	}
+	if command == "verify" {
+		fs.StringVar(&expectedPath, "expected", "", "Baseline output, in the -output format, to compare the results with")
+		fs.Float64Var(&verifyTolerance, "tolerance", 0, "Largest accepted difference between a number of the results and the baseline, e.g. 0.1")
+	}
+	fs.DurationVar(&runTimeout, "timeout", 0, "Stop reading after this long, e.g. 10m, and print the partial results, exiting with 124 (0 is unlimited)")
+	fs.StringVar(&tracePath, "trace", "", "Write an execution trace of reading and aggregating to this file, for go tool trace")
+	fs.StringVar(&pprofAddr, "pprofAddr", "", "Serve net/http/pprof on this address during the run, e.g. :6060 or localhost:6060, for go tool pprof")
+	fs.BoolVar(&validateOnly, "validate", false, "Only parse the inputs and check their field counts and numbers (against -validateRange, -99.9,99.9 unless given), print the counts of valid, malformed and out-of-range rows and exit with 1 if any is invalid")
+	fs.BoolVar(&sequential, "sequential", false, "Aggregate with a simple single-threaded reference implementation (slow, keeps every reading in memory) to check the parallel paths against")
+	fs.BoolVar(&selfVerify, "self-verify", false, "Aggregate local inputs a second time in one pass into a single table and fail on the first station differing from the parallel, merged results (slow)")
+	fs.StringVar(&serveAddr, "serve", "", "Serve the results as JSON on this address, e.g. :8080, at /stations, /stations/{name} and /top?by=max&n=10, until SIGINT or SIGTERM")
+	fs.StringVar(&grpcAddr, "grpc", "", "Serve the results with the gRPC service of results.proto (GetStation, ListStations, Ingest) on this address, e.g. :9090, until SIGINT or SIGTERM")
+	fs.StringVar(&aggregatorName, "aggregator", "", "Aggregate through the onebrc library with a registered aggregator: "+strings.Join(onebrc.Aggregators(), ", "))
+	fs.BoolVar(&showVersion, "version", false, "Print the module version, VCS revision and Go version of the binary and exit")
+	fs.StringVar(&configPath, "config", "", "YAML (.yaml, .yml) or TOML (.toml) file of flag settings such as \"workers: 8\" or \"workers = 8\", lists for repeated flags; flags on the command line override it")
+	fs.StringVar(&logLevel, "logLevel", "info", "Lowest level of the diagnostics logged to stderr: debug, info, warn or error")
+	fs.BoolVar(&verbose, "v", false, "Log debug diagnostics as well, short for -logLevel debug")
+	fs.BoolVar(&quiet, "q", false, "Log errors only, short for -logLevel error")
+
+	// Parse the command-line flags
+	fs.Parse(args)
+	if showVersion {
+		printVersion(os.Stdout)
+		return
+	}
+
+	// Log at the default level until the flags picking it are all known
+	slog.SetDefault(newLogger(slog.LevelInfo))
+
+	// Fill in the flags not given on the command line from ONEBRC_* variables, then from the
+	// -config file, so the command line wins over the environment and both over the file
+	if err := applyEnvironment(fs); err != nil {
+		slog.Error("invalid environment", "err", err)
+		os.Exit(2)
+	}
+	if configPath != "" {
+		if err := applyConfig(fs, configPath); err != nil {
+			slog.Error("loading config failed", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	// Send the diagnostics to stderr, stdout only carries the results
+	if err := setupLogging(); err != nil {
+		slog.Error("invalid logging flags", "err", err)
+		os.Exit(2)
+	}
+
+	var err error
+
+	// Tune the garbage collector before anything is read, leaving GOGC alone unless -gcpercent is given
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "gcpercent" {
+			debug.SetGCPercent(gcPercent)
+			slog.Debug("set GC percentage", "gcpercent", gcPercent)
+		}
+	})
+	if memLimit != "" {
+		limit, err := parseByteSize(memLimit)
+		if err != nil {
+			slog.Error("invalid -memlimit", "err", err)
+			os.Exit(2)
+		}
+		debug.SetMemoryLimit(limit)
+		slog.Debug("set memory limit", "bytes", limit)
+	}
+
+	// Parse the output precision
+	fieldPrecision, err = parsePrecision(precision)
+	if err != nil {
+		slog.Error("invalid -precision", "err", err)
+		os.Exit(2)
+	}
+
+	// Parse the requested percentiles
+	percentiles, err = parsePercentiles(percentileSpec)
+	if err != nil {
+		slog.Error("invalid -percentiles", "err", err)
+		os.Exit(2)
+	}
+	if (len(percentiles) > 0 || estimateDistinct) && checkpointPath != "" {
+		slog.Error("-percentiles and -distinct cannot be combined with -checkpoint")
+		os.Exit(2)
+	}
+
+	// Parse the histogram buckets
+	histogramBounds, err = parseHistogram(histogramSpec)
+	if err != nil {
+		slog.Error("invalid -histogram", "err", err)
+		os.Exit(2)
+	}
+
+	// Parse the selected aggregates
+	aggregates, err = parseAggregates(aggregateSpec)
+	if err != nil {
+		slog.Error("invalid -aggs", "err", err)
+		os.Exit(2)
+	}
+	if topN > 0 && !aggregates.has(topBy) {
+		slog.Error(fmt.Sprintf("-by %s needs %s in -aggs", topBy, strings.Replace(topBy, "avg", "mean", 1)))
+		os.Exit(2)
+	}
+	if sortBy != "name" && sortBy != "avg" && sortBy != "max" && sortBy != "min" && sortBy != "count" {
+		slog.Error("-sort must be name, avg, max, min or count")
+		os.Exit(2)
+	}
+	if !aggregates.has(sortBy) {
+		slog.Error(fmt.Sprintf("-sort %s needs %s in -aggs", sortBy, strings.Replace(sortBy, "avg", "mean", 1)))
+		os.Exit(2)
+	}
+
+	switch outputFormat {
+	case "text", "official", "json", "csv", "table", "markdown", "prom", "parquet":
+	default:
+		slog.Error("-output must be text, official, json, csv, table, markdown, prom or parquet")
+		os.Exit(2)
+	}
+	if outputTemplate != "" {
+		if outputFormat != "text" {
+			slog.Error("-outputTemplate cannot be combined with -output")
+			os.Exit(2)
+		}
+		resultTemplate, err = template.New("outputTemplate").Parse(outputTemplate)
+		if err != nil {
+			slog.Error("invalid -outputTemplate", "err", err)
+			os.Exit(2)
+		}
+	}
+	if rounding != "float" && rounding != "halfUp" {
+		slog.Error("-rounding must be float or halfUp")
+		os.Exit(2)
+	}
+	if outputFormat == "official" && !(aggregates.min && aggregates.max && aggregates.mean) {
+		slog.Error("-output official needs min, max and mean in -aggs")
+		os.Exit(2)
+	}
+
+	if command == "verify" && (expectedPath == "" || outputFormat == "parquet" || outPath != "" || verifyTolerance < 0) {
+		slog.Error("verify needs -expected and a non-negative -tolerance, and cannot be combined with -out or -output parquet")
+		os.Exit(2)
+	}
+	if benchRuns < 0 || command == "bench" && benchRuns == 0 {
+		slog.Error("-bench must not be negative, -runs must be positive")
+		os.Exit(2)
+	}
+	if benchParsers < 0 || benchParsers > 0 && benchRuns > 0 {
+		slog.Error("-bench-parsers must not be negative and cannot be combined with -bench")
+		os.Exit(2)
+	}
+	if topN < 0 {
+		slog.Error("-top must not be negative")
+		os.Exit(2)
+	}
+	if topBy != "avg" && topBy != "max" && topBy != "min" && topBy != "count" {
+		slog.Error("-by must be avg, max, min or count")
+		os.Exit(2)
+	}
+
+	// Check readings against the default band with -validate unless -validateRange says otherwise
+	if validateOnly {
+		rangeGiven := false
+		fs.Visit(func(f *flag.Flag) {
+			rangeGiven = rangeGiven || f.Name == "validateRange"
+		})
+		if !rangeGiven {
+			validRange.Set("true")
+		}
+		if estimateDistinct || outputFormat != "text" || outputTemplate != "" || outPath != "" || benchRuns > 0 {
+			slog.Error("-validate cannot be combined with -distinct, -output, -outputTemplate, -out or -bench")
+			os.Exit(2)
+		}
+	}
+
+	inputUnit = strings.ToUpper(inputUnit)
+	if inputUnit != "C" && inputUnit != "F" && inputUnit != "K" {
+		slog.Error("-input-unit must be C, F or K")
+		os.Exit(2)
+	}
+	if skipLines < 0 {
+		slog.Error("-skipLines must not be negative")
+		os.Exit(2)
+	}
+	if workers <= 0 {
+		slog.Error("-workers must be positive")
+		os.Exit(2)
+	}
+	if maxOpenFiles <= 0 {
+		slog.Error("-max-open-files must be positive")
+		os.Exit(2)
+	}
+	openFileSlots = make(chan struct{}, maxOpenFiles)
+	if ioWorkers < 0 {
+		slog.Error("-io-workers must not be negative, 0 has each worker read a byte range")
+		os.Exit(2)
+	}
+	if ioWorkers > 0 && (useMmap || checkpointPath != "") {
+		slog.Error("-io-workers cannot be combined with -mmap or -checkpoint")
+		os.Exit(2)
+	}
+	if maxOutputBytes < 0 {
+		slog.Error("-max-output-bytes must not be negative, 0 is unlimited")
+		os.Exit(2)
+	}
+	if batchSize <= 0 {
+		slog.Error("-batchSize must be positive")
+		os.Exit(2)
+	}
+	if readBuffer <= 0 || maxLineLength <= 0 {
+		slog.Error("-readBuffer and -maxLineLength must be positive")
+		os.Exit(2)
+	}
+	delimiter = delimiterByName(delimiter)
+	if delimiter == "" {
+		slog.Error("-delimiter must not be empty")
+		os.Exit(2)
+	}
+	if quoted && delimiter != "auto" && utf8.RuneCountInString(delimiter) != 1 {
+		slog.Error("-quoted needs a single-character -delimiter")
+		os.Exit(2)
+	}
+	if outOfRange != "reject" && outOfRange != "flag" {
+		slog.Error("-outOfRange must be reject or flag")
+		os.Exit(2)
+	}
+	if onError != onErrorSkip && onError != onErrorFail && onError != onErrorCollect {
+		slog.Error("-onError must be skip, fail or collect")
+		os.Exit(2)
+	}
+	if inputFormat != "text" && inputFormat != "csv" && inputFormat != "jsonl" && inputFormat != "parquet" {
+		slog.Error("-format must be text, csv, jsonl or parquet")
+		os.Exit(2)
+	}
+	if (inputFormat == "jsonl" || inputFormat == "parquet") && (groupBy != "0" || groupCol >= 0 || valueCol != 1 || weightCol >= 0 || quoted) {
+		slog.Error(fmt.Sprintf("-format %s cannot be combined with -groupBy, -group-col, -value-col, -weight-col or -quoted", inputFormat))
+		os.Exit(2)
+	}
+	if inputFormat == "csv" && (delimiter == "auto" || utf8.RuneCountInString(delimiter) != 1) {
+		slog.Error("-format csv needs a single-character -delimiter")
+		os.Exit(2)
+	}
+
+	// Parse the key columns, -group-col N standing for -groupBy 0,N
+	groupColumns, err = parseColumns(groupBy)
+	if err != nil {
+		slog.Error("invalid -groupBy", "err", err)
+		os.Exit(2)
+	}
+	if groupCol >= 0 {
+		if groupBy != "0" {
+			slog.Error("-group-col cannot be combined with -groupBy")
+			os.Exit(2)
+		}
+		if groupCol == 0 {
+			slog.Error("-group-col must not point at the name column")
+			os.Exit(2)
+		}
+		groupColumns = append(groupColumns, groupCol)
+	}
+	lineParser = newLineParser(nil)
+	if err := lineParser.CheckColumns(); err != nil {
+		slog.Error("invalid columns", "err", err)
+		os.Exit(2)
+	}
+	if sequential && (inputFormat != "text" || quoted || lineParser.RequiredColumns() != 2 || len(groupColumns) != 1 || groupColumns[0] != 0 || valueCol != 1 ||
+		len(percentiles) > 0 || histogramBounds != nil || estimateDistinct || checkpointPath != "" || useMmap || benchRuns > 0) {
+		slog.Error("-sequential only supports plain two-column text without -percentiles, -histogram, -distinct, -checkpoint, -mmap or -bench")
+		os.Exit(2)
+	}
+	if selfVerify && (sequential || inputFormat == "csv" || inputFormat == "parquet" || estimateDistinct || aggregatorName != "" || checkpointPath != "" ||
+		validateOnly || benchRuns > 0 || command == "verify") {
+		slog.Error("-self-verify cannot be combined with -sequential, -format csv or parquet, -distinct, -aggregator, -checkpoint, -validate, -bench or the verify command")
+		os.Exit(2)
+	}
+	if (serveAddr != "" || grpcAddr != "") && (estimateDistinct || validateOnly || benchRuns > 0 || command == "verify") {
+		slog.Error("-serve and -grpc cannot be combined with -distinct, -validate, -bench or the verify command")
+		os.Exit(2)
+	}
+	if aggregatorName != "" {
+		if !slices.Contains(onebrc.Aggregators(), aggregatorName) {
+			slog.Error("unknown -aggregator", "aggregator", aggregatorName, "registered", onebrc.Aggregators())
+			os.Exit(2)
+		}
+		if sequential || inputFormat == "csv" || inputFormat == "parquet" || showSpread || len(percentiles) > 0 || histogramBounds != nil || estimateDistinct ||
+			checkpointPath != "" || useMmap || benchRuns > 0 {
+			slog.Error("-aggregator only supports text and jsonl input, without -sequential, -stddev, -percentiles, -histogram, -distinct, -checkpoint, -mmap or -bench")
+			os.Exit(2)
+		}
+	}
+
+	// The tables of the workers compute what the flags ask for
+	workerTables = stats.NewSet(tableOptions())
+
+	// Load the station aliases
+	if aliasFile != "" {
+		lineParser.Aliases, err = loadAliases(aliasFile)
+		if err != nil {
+			slog.Error("loading aliases failed", "file", aliasFile, "err", err)
+			os.Exit(1)
+		}
+	}
+
+	// Expand glob patterns and read every input, merging all of them into one result set
+	if len(inputFiles) == 0 {
+		inputFiles = inputList{"-"}
+	}
+	paths, err := expandInputs(inputFiles)
+	if err != nil {
+		slog.Error("expanding inputs failed", "err", err)
+		os.Exit(1)
+	}
+	if selfVerify {
+		if err := checkSelfVerifyInputs(paths); err != nil {
+			slog.Error("invalid -self-verify inputs", "err", err)
+			os.Exit(2)
+		}
+	}
+	if benchParsers > 0 {
+		if len(paths) != 1 {
+			slog.Error("-bench-parsers needs exactly one -file")
+			os.Exit(2)
+		}
+		if err := runParserBench(os.Stdout, paths[0], benchParsers); err != nil {
+			slog.Error("parser benchmark failed", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if benchRuns > 0 {
+		if len(paths) != 1 || checkpointPath != "" {
+			slog.Error("benchmarks need exactly one -file and no -checkpoint")
+			os.Exit(2)
+		}
+		if err := runBench(os.Stdout, paths[0], benchRuns); err != nil {
+			slog.Error("benchmark failed", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if checkpointPath != "" {
+		activeCheckpoint, err = startCheckpoint(checkpointPath, checkpointInterval, resume)
+		if err != nil {
+			slog.Error("starting checkpoint failed", "file", checkpointPath, "err", err)
+			os.Exit(1)
+		}
+	} else if resume {
+		slog.Error("-resume needs -checkpoint")
+		os.Exit(2)
+	}
+	if rejectPath != "" {
+		if err := openRejectFile(rejectPath); err != nil {
+			slog.Error("opening reject file failed", "file", rejectPath, "err", err)
+			os.Exit(1)
+		}
+		defer closeRejectFile()
+	}
+	if pprofAddr != "" {
+		if err := startPprofServer(pprofAddr); err != nil {
+			slog.Error("starting pprof server failed", "addr", pprofAddr, "err", err)
+			closeRejectFile()
+			os.Exit(1)
+		}
+	}
+	stopProfiling, err := startProfiling()
+	if err != nil {
+		slog.Error("starting profile failed", "err", err)
+		closeRejectFile()
+		os.Exit(1)
+	}
+	defer stopProfiling()
+	var servers []*http.Server
+	if serveAddr != "" {
+		server, err := startQueryServer(serveAddr)
+		if err != nil {
+			slog.Error("starting query server failed", "addr", serveAddr, "err", err)
+			closeRejectFile()
+			stopProfiling()
+			os.Exit(1)
+		}
+		servers = append(servers, server)
+	}
+	if grpcAddr != "" {
+		server, err := startGRPCServer(grpcAddr)
+		if err != nil {
+			slog.Error("starting gRPC server failed", "addr", grpcAddr, "err", err)
+			closeRejectFile()
+			stopProfiling()
+			os.Exit(1)
+		}
+		servers = append(servers, server)
+	}
+	stopProgress, err := startProgress(paths)
+	if err != nil {
+		slog.Error("opening -progress-json failed", "path", progressJSON, "err", err)
+		closeRejectFile()
+		stopProfiling()
+		os.Exit(1)
+	}
+	defer stopProgress(false)
+	ctx, stopRun := startRunContext(runTimeout)
+	for _, path := range paths {
+		if activeCheckpoint.completed(path) {
+			continue
+		}
+		currentInput = path
+		slog.Debug("reading input", "input", path)
+		var err error
+		trace.WithRegion(ctx, "readInput", func() {
+			err = readInput(ctx, path)
+		})
+		if err != nil && ctx.Err() == nil {
+			// Keep the progress made so far for a later -resume
+			activeCheckpoint.stop(false)
+			stopRun()
+			stopProgress(false)
+			closeRejectFile()
+			slog.Error("reading input failed", "input", path, "err", err)
+			stopProfiling()
+			os.Exit(1)
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		activeCheckpoint.complete(path)
+	}
+	// The watcher of ctx may not have run yet when the readers just stopped on it
+	if ctx.Err() != nil {
+		cancelled.Store(true)
+	}
+	stopRun()
+	stopProgress(!cancelled.Load() && parseFailure() == nil)
+
+	// Stop before printing results when a malformed line failed the run
+	if err := parseFailure(); err != nil {
+		activeCheckpoint.stop(false)
+		closeRejectFile()
+		slog.Error("malformed input", "err", err)
+		stopProfiling()
+		os.Exit(1)
+	}
+	// A cancelled run keeps its checkpoint for a later -resume
+	activeCheckpoint.stop(!cancelled.Load())
+	printErrorSummary(os.Stderr)
+	if violations := outOfRangeReadings(); violations > 0 {
+		verb := "rejected"
+		if outOfRange == "flag" {
+			verb = "flagged"
+		}
+		slog.Warn("readings outside -validateRange", "count", violations, "range", validRange.String(), "action", verb)
+	}
+
+	if explain && lineParser.Aliases != nil {
+		slog.Info("remapped rows", "count", lineParser.Remapped.Load())
+	}
+
+	// Only report the checks with -validate, nothing was aggregated
+	if validateOnly {
+		valid, err := printValidation(os.Stdout)
+		if err != nil {
+			slog.Error("writing validation failed", "err", err)
+			os.Exit(1)
+		}
+		if !valid {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Combine what every worker aggregated, with -distinct only the estimate is printed
+	if !estimateDistinct {
+		trace.WithRegion(context.Background(), "mergeTables", func() {
+			statsShards = workerTables.Merge()
+		})
+	}
+	stopProfiling()
+
+	// Print the final result to stdout, or to the -out file, or compare it with the baseline
+	matched := true
+	switch {
+	case expectedPath != "":
+		matched, err = verifyResults(os.Stdout)
+	case outPath != "":
+		err = writeResultsFile(outPath)
+	default:
+		err = writeLimited(os.Stdout)
+	}
+	if err != nil {
+		slog.Error("writing results failed", "err", err)
+		os.Exit(1)
+	}
+	// A partial run has nothing complete to compare with
+	if selfVerify && !cancelled.Load() {
+		verified, err := runSelfVerify(context.Background(), paths)
+		if err != nil {
+			slog.Error("self-verify failed", "err", err)
+			os.Exit(1)
+		}
+		matched = matched && verified
+	}
+	if showSummary {
+		printSummary(os.Stderr)
+	}
+	if cancelled.Load() {
+		slog.Warn("results are partial", "reason", context.Cause(ctx), "bytesRead", atomic.LoadInt64(&bytesRead))
+		os.Exit(cancelledExitCode(ctx))
+	}
+	if !matched {
+		os.Exit(1)
+	}
+	// Only now hand the results to -serve and -grpc, whose Ingest calls fold more readings into them
+	if len(servers) > 0 {
+		publishResults()
+		serveUntilSignalled(servers)
+	}
+}
+
+// Function to print the results
+func printResults(w io.Writer) error {
+	written := 0
+
+	// Print out the name -> min/max/avg stats, each listed under its starting letter
+	for _, r := range collectResults() {
+		name, stats := r.name, r.stats
+		line := fmt.Sprintf("Letter: %c, Name: %s", letterOf(name), name)
+		if aggregates.min {
+			line += ", Min: " + formatMin(stats, fieldPrecision.min)
+		}
+		if aggregates.max {
+			line += ", Max: " + formatMax(stats, fieldPrecision.max)
+		}
+		if aggregates.mean {
+			line += ", Avg: " + formatMean(stats, fieldPrecision.mean)
+		}
+		if aggregates.count {
+			line += ", Count: " + strconv.Itoa(stats.Count)
+		}
+		if aggregates.sum {
+			line += ", Sum: " + formatSum(stats, fieldPrecision.mean)
+		}
+
+		// The spread uses the precision of the mean
+		if showSpread {
+			variance := stats.Variance()
+			line += fmt.Sprintf(", Variance: %s, StdDev: %s", output.FormatValue(variance, fieldPrecision.mean), output.FormatValue(math.Sqrt(variance), fieldPrecision.mean))
+		}
+		for _, p := range percentiles {
+			line += fmt.Sprintf(", P%s: %s", strconv.FormatFloat(p, 'f', -1, 64), output.FormatValue(stats.Digest.Quantile(p/100)/10, fieldPrecision.mean))
+		}
+		if histogramBounds != nil {
+			line += ", Histogram: " + formatHistogram(stats.Histogram)
+		}
+		_, err := io.WriteString(w, line+"\n")
+		if err == errOutputLimit {
+			return fmt.Errorf("%w after %d stations", err, written)
+		}
+		if err != nil {
+			return err
+		}
+		written++
+	}
+	return nil
+}
+
+// Struct to hold the merged stats of one name for printing
+type result struct {
+	name  string
+	stats stats.NameStats
+}
+
+// Function to gather the merged stats of all names, keeping only the -top most extreme ones if set
+func collectResults() []result {
+	var results []result
+	for _, shard := range statsShards {
+		for name, stats := range shard.All() {
+			results = append(results, result{name: name, stats: stats})
+		}
+	}
+
+	// Rank the names most extreme first for -top, otherwise order them by -sort; ties are
+	// broken by name so every run prints the same order and the -top cut is stable
+	order := sortBy
+	if topN > 0 {
+		order = topBy
+	}
+	sortResults(results, order)
+	if topN > 0 {
+		results = results[:min(topN, len(results))]
+	}
+	return results
+}
+
+// Function to sort results in place by one of the built-in orders of onebrc.StationOrder, which
+// the flags and /top have validated
+func sortResults(results []result, order string) {
+	less, err := onebrc.StationOrder(order)
+	if err != nil {
+		panic(err)
+	}
+	slices.SortFunc(results, func(a, b result) int {
+		switch sa, sb := a.station(), b.station(); {
+		case less(sa, sb):
+			return -1
+		case less(sb, sa):
+			return 1
+		default:
+			return 0
+		}
+	})
+}
+
+// Function to view a result as the onebrc.Station the library orders compare
+func (r result) station() onebrc.Station {
+	return onebrc.Station{
+		Name:  r.name,
+		Min:   float64(r.stats.Min) / 10,
+		Max:   float64(r.stats.Max) / 10,
+		Mean:  r.stats.Mean() / 10,
+		Sum:   r.stats.FloatSum() / 10,
+		Count: int64(r.stats.Count),
+	}
+}
+
+// Error returned once writing would exceed -max-output-bytes
+var errOutputLimit = errors.New("output limit reached")
+
+// Writer that refuses any write which would take the output past a byte limit
+type limitedWriter struct {
+	w       io.Writer
+	limit   int64
+	written int64
+}
+
+// Function to write p in full, or nothing at all if it does not fit under the limit
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if l.written+int64(len(p)) > l.limit {
+		return 0, errOutputLimit
+	}
+	n, err := l.w.Write(p)
+	l.written += int64(n)
+	return n, err
+}
+
+// Function to parse a byte size such as 512MiB or 4GiB, the suffixes GOMEMLIMIT accepts
+func parseByteSize(spec string) (int64, error) {
+	units := []struct {
+		suffix string
+		scale  int64
+	}{
+		{"TiB", 1 << 40}, {"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10},
+		{"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"KB", 1e3}, {"B", 1},
+	}
+
+	number, scale := spec, int64(1)
+	for _, unit := range units {
+		if trimmed, ok := strings.CutSuffix(spec, unit.suffix); ok {
+			number, scale = trimmed, unit.scale
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(number), 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/scale {
+		return 0, fmt.Errorf("invalid size %q, expected bytes or a B, KB, MB, GB, TB, KiB, MiB, GiB or TiB suffix", spec)
+	}
+	return n * scale, nil
+}
+
+// Function to parse a precision spec, either a single number for all fields or a per-field list
+func parsePrecision(spec string) (FieldPrecision, error) {
+	// A single number applies uniformly to every field
+	if uniform, err := strconv.Atoi(strings.TrimSpace(spec)); err == nil {
+		if uniform < 0 {
+			return FieldPrecision{}, fmt.Errorf("invalid precision: %s", spec)
+		}
+		return FieldPrecision{min: uniform, max: uniform, mean: uniform}, nil
+	}
+
+	// Otherwise expect field=decimals pairs, fields not listed keep the default of 2
+	result := FieldPrecision{min: 2, max: 2, mean: 2}
+	for _, pair := range strings.Split(spec, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return FieldPrecision{}, fmt.Errorf("invalid precision: %s", pair)
+		}
+		decimals, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil || decimals < 0 {
+			return FieldPrecision{}, fmt.Errorf("invalid precision: %s", pair)
+		}
+		switch strings.TrimSpace(kv[0]) {
+		case "min":
+			result.min = decimals
+		case "max":
+			result.max = decimals
+		case "mean", "avg":
+			result.mean = decimals
+		default:
+			return FieldPrecision{}, fmt.Errorf("unknown precision field: %s", kv[0])
+		}
+	}
+	return result, nil
+}
+
+// Function to parse a comma-separated list of aggregates such as "min,max,mean"
+func parseAggregates(spec string) (aggregateSet, error) {
+	var result aggregateSet
+	for _, field := range strings.Split(spec, ",") {
+		switch strings.TrimSpace(field) {
+		case "min":
+			result.min = true
+		case "max":
+			result.max = true
+		case "mean", "avg":
+			result.mean = true
+		case "count":
+			result.count = true
+		case "sum":
+			result.sum = true
+		default:
+			return aggregateSet{}, fmt.Errorf("unknown aggregate: %s", field)
+		}
+	}
+	return result, nil
+}
+
+// Function to check whether a -by stat is among the selected aggregates
+func (a aggregateSet) has(stat string) bool {
+	switch stat {
+	case "min":
+		return a.min
+	case "max":
+		return a.max
+	case "avg":
+		return a.mean
+	default:
+		// The name is always known and the count always kept, every other stat depends on it
+		return true
+	}
+}
+
+// Function to parse a comma-separated list of percentiles such as "50,90,99.9"
+func parsePercentiles(spec string) ([]float64, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	var result []float64
+	for _, field := range strings.Split(spec, ",") {
+		p, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || p < 0 || p > 100 {
+			return nil, fmt.Errorf("invalid percentile: %s", field)
+		}
+		result = append(result, p)
+	}
+	return result, nil
+}
+
+// Function to parse a histogram spec of the form "buckets=b1,b2,..." into ascending boundaries in tenths
+func parseHistogram(spec string) ([]int16, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	list, ok := strings.CutPrefix(strings.TrimSpace(spec), "buckets=")
+	if !ok {
+		return nil, fmt.Errorf("invalid histogram: %s, expected buckets=b1,b2,...", spec)
+	}
+
+	var bounds []int16
+	for _, field := range strings.Split(list, ",") {
+		value, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid histogram bucket: %s", field)
+		}
+		bound, ok := parse.RoundTenths(value)
+		if !ok || (len(bounds) > 0 && bound <= bounds[len(bounds)-1]) {
+			return nil, fmt.Errorf("histogram buckets must be ascending: %s", field)
+		}
+		bounds = append(bounds, bound)
+	}
+	return bounds, nil
+}
+
+// Function to format the counts of a histogram as "<b1:n b1..b2:n ... >=bn:n"
+func formatHistogram(counts []int64) string {
+	bound := func(i int) string {
+		return strconv.FormatFloat(float64(histogramBounds[i])/10, 'f', -1, 64)
+	}
+
+	parts := make([]string, len(counts))
+	for i, count := range counts {
+		switch {
+		case i == 0:
+			parts[i] = fmt.Sprintf("<%s:%d", bound(0), count)
+		case i == len(histogramBounds):
+			parts[i] = fmt.Sprintf(">=%s:%d", bound(i-1), count)
+		default:
+			parts[i] = fmt.Sprintf("%s..%s:%d", bound(i-1), bound(i), count)
+		}
+	}
+	return strings.Join(parts, " ")
+}
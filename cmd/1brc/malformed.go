@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Policies for malformed lines selected with -onError
+const (
+	onErrorSkip    = "skip"
+	onErrorFail    = "fail"
+	onErrorCollect = "collect"
+)
+
+// Number of malformed lines kept for the summary printed in collect mode
+const maxCollectedErrors = 100
+
+var (
+	malformedLines  int64       // Number of lines that could not be parsed
+	stopReading     atomic.Bool // Set once a malformed line fails the run
+	errorMutex      sync.Mutex  // Protects firstParseError and collectedErrors
+	firstParseError error
+	collectedErrors []error
+	currentInput    string        // Input being read, named in the reject file
+	rejectFile      *os.File      // File malformed lines are written to, nil without -rejectFile
+	rejectWriter    *bufio.Writer // Buffered writer of rejectFile, protected by errorMutex
+)
+
+// Function to create the reject file receiving malformed lines
+func openRejectFile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("creating reject file: %w", err)
+	}
+	rejectFile = file
+	rejectWriter = bufio.NewWriter(file)
+	return nil
+}
+
+// Function to flush and close the reject file, if any
+func closeRejectFile() {
+	errorMutex.Lock()
+	defer errorMutex.Unlock()
+	if rejectFile == nil {
+		return
+	}
+	if err := rejectWriter.Flush(); err != nil {
+		slog.Error("writing reject file failed", "err", err)
+	}
+	rejectFile.Close()
+	rejectFile, rejectWriter = nil, nil
+}
+
+// Function to handle a malformed line starting at offset according to the -onError policy,
+// also recording it in the reject file when there is one
+func reportParseError(err error, line string, offset int64) {
+	atomic.AddInt64(&malformedLines, 1)
+
+	rejected := false
+	errorMutex.Lock()
+	if rejectWriter != nil {
+		fmt.Fprintf(rejectWriter, "%s:%d\t%v\t%s\n", currentInput, offset, err, strings.TrimSuffix(line, "\r"))
+		rejected = true
+	}
+	errorMutex.Unlock()
+
+	switch onError {
+	case onErrorFail:
+		errorMutex.Lock()
+		if firstParseError == nil {
+			firstParseError = err
+		}
+		errorMutex.Unlock()
+		stopReading.Store(true)
+	case onErrorCollect:
+		errorMutex.Lock()
+		if len(collectedErrors) < maxCollectedErrors {
+			collectedErrors = append(collectedErrors, err)
+		}
+		errorMutex.Unlock()
+	default:
+		if !rejected {
+			slog.Warn("malformed line", "input", currentInput, "offset", offset, "err", err)
+		}
+	}
+}
+
+// Function to tell the readers and workers to stop early because the run already failed or ctx
+// was cancelled
+func stopRequested(ctx context.Context) bool {
+	return stopReading.Load() || ctx.Err() != nil
+}
+
+// Function to return the malformed line that failed the run in fail mode, or nil
+func parseFailure() error {
+	errorMutex.Lock()
+	defer errorMutex.Unlock()
+	return firstParseError
+}
+
+// Function to print the malformed lines collected in collect mode
+func printErrorSummary(w io.Writer) {
+	if onError != onErrorCollect || malformedLines == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "%d malformed lines skipped\n", malformedLines)
+	for _, err := range collectedErrors {
+		fmt.Fprintln(w, "  ", err)
+	}
+	if omitted := malformedLines - int64(len(collectedErrors)); omitted > 0 {
+		fmt.Fprintf(w, "   ... and %d more\n", omitted)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+// Function to report that memory-mapped input is not available on this platform
+func mmapFile(path string) ([]byte, func() error, error) {
+	return nil, nil, errors.New("-mmap is not supported on this platform")
+}
@@ -0,0 +1,32 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// Function to map a whole file read-only into memory, returning the bytes and a function releasing them
+func mmapFile(path string) ([]byte, func() error, error) {
+	file, err := openInputFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The mapping stays valid after the descriptor is closed
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Empty files cannot be mapped, there is nothing to read either way
+	size := info.Size()
+	if size == 0 {
+		return nil, func() error { return nil }, nil
+	}
+
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"example.com/mod/internal/codec"
+)
+
+// Number of input descriptors open at once when the descriptor limit of the process is unknown
+const fallbackMaxOpenFiles = 256
+
+// Semaphore with a slot per input descriptor open, sized by -max-open-files once the flags are parsed
+var openFileSlots = make(chan struct{}, fallbackMaxOpenFiles)
+
+// Struct to hold an input file whose descriptor takes a slot of -max-open-files until it is closed
+type slottedFile struct {
+	*os.File
+}
+
+// Function to close the file and free its slot
+func (f slottedFile) Close() error {
+	err := f.File.Close()
+	<-openFileSlots
+	return err
+}
+
+// Function to open an input file once a slot of -max-open-files is free
+func openInputFile(path string) (slottedFile, error) {
+	openFileSlots <- struct{}{}
+	file, err := os.Open(path)
+	if err != nil {
+		<-openFileSlots
+		return slottedFile{}, err
+	}
+	return slottedFile{file}, nil
+}
+
+// Function to open an input file only when a slot of -max-open-files is free right away,
+// reporting false without opening it otherwise
+func tryOpenInputFile(path string) (slottedFile, bool, error) {
+	select {
+	case openFileSlots <- struct{}{}:
+	default:
+		return slottedFile{}, false, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		<-openFileSlots
+		return slottedFile{}, false, err
+	}
+	return slottedFile{file}, true, nil
+}
+
+// Function to tell whether a local input file is compressed and whether it is UTF-16 text, from
+// its extension and its first bytes read through a slot of -max-open-files
+func sniffInputFile(path string) (compressed, utf16 bool, err error) {
+	file, err := openInputFile(path)
+	if err != nil {
+		return false, false, fmt.Errorf("opening file: %w", err)
+	}
+	defer file.Close()
+	prefix, err := codec.ReadPrefix(file)
+	if err != nil {
+		return false, false, fmt.Errorf("opening file: %w", err)
+	}
+	return codec.IsCompressed(path, prefix), codec.IsUTF16(prefix), nil
+}
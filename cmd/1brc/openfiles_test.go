@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Checks that many small files read with a low -max-open-files and more workers than slots are
+// all aggregated as if they were one file, whether some are gzipped or they are memory-mapped
+func TestMaxOpenFiles(t *testing.T) {
+	dir := t.TempDir()
+	var all, plain strings.Builder
+	for i := range 300 {
+		var input strings.Builder
+		for j := range 20 {
+			fmt.Fprintf(&input, "S%d;%d.%d\n", (i+j)%7, (i*j)%199-99, j%10)
+		}
+		// Every third file is gzipped, -mmap only reads the others
+		name, data := fmt.Sprintf("f%d.txt", i), []byte(input.String())
+		if i%3 == 0 {
+			var compressed bytes.Buffer
+			zw := gzip.NewWriter(&compressed)
+			zw.Write(data)
+			zw.Close()
+			name, data = name+".gz", compressed.Bytes()
+		} else {
+			plain.WriteString(input.String())
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		all.WriteString(input.String())
+	}
+	output := []string{"-output", "csv", "-aggs", "min,max,mean,count,sum"}
+	oneFile := func(name, input string) []byte {
+		t.Helper()
+		want, code := runCLI(t, append([]string{"-file", writeTestInput(t, name, input)}, output...)...)
+		if code != 0 || !strings.Contains(string(want), "S6,") {
+			t.Fatalf("one file exited with %d and printed\n%s", code, want)
+		}
+		return want
+	}
+	wantAll, wantPlain := oneFile("all.txt", all.String()), oneFile("plain.txt", plain.String())
+
+	for _, limit := range []string{"1", "2"} {
+		for _, run := range []struct {
+			pattern string
+			flags   []string
+			want    []byte
+		}{
+			{"f*", nil, wantAll},
+			{"*.txt", []string{"-mmap"}, wantPlain},
+		} {
+			args := append([]string{"-file", filepath.Join(dir, run.pattern), "-max-open-files", limit, "-workers", "8"}, run.flags...)
+			if got, code := runCLI(t, append(args, output...)...); code != 0 || string(got) != string(run.want) {
+				t.Errorf("%v exited with %d and printed\n%s\nwant\n%s", args, code, got, run.want)
+			}
+		}
+	}
+	if _, code := runCLI(t, "-file", filepath.Join(dir, "f*"), "-max-open-files", "0"); code != 2 {
+		t.Errorf("-max-open-files 0 exited with %d, want 2", code)
+	}
+}
+
+// Checks that the descriptors of the inputs take the slots of the semaphore until closed, and that
+// a range finding none free is told so instead of waiting
+func TestOpenFileSlots(t *testing.T) {
+	saved := openFileSlots
+	t.Cleanup(func() { openFileSlots = saved })
+	openFileSlots = make(chan struct{}, 2)
+	path := writeTestInput(t, "measurements.txt", "Hamburg;12.0\n")
+
+	first, err := openInputFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, opened, err := tryOpenInputFile(path)
+	if err != nil || !opened {
+		t.Fatalf("tryOpenInputFile with a free slot = %v, %v", opened, err)
+	}
+	if _, opened, err := tryOpenInputFile(path); err != nil || opened {
+		t.Errorf("tryOpenInputFile without a free slot = %v, %v, want false", opened, err)
+	}
+	second.Close()
+	third, opened, err := tryOpenInputFile(path)
+	if err != nil || !opened {
+		t.Errorf("tryOpenInputFile after a close = %v, %v", opened, err)
+	}
+	third.Close()
+	first.Close()
+
+	if _, err := openInputFile(filepath.Join(t.TempDir(), "missing.txt")); err == nil || len(openFileSlots) != 0 {
+		t.Errorf("opening a missing file = %v and kept %d slots, want an error and none", err, len(openFileSlots))
+	}
+}
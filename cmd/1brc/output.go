@@ -0,0 +1,507 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+
+	"example.com/mod/internal/output"
+	"example.com/mod/internal/stats"
+)
+
+// Function to write the results to w, stopping at -max-output-bytes when set
+func writeLimited(w io.Writer) error {
+	if maxOutputBytes > 0 {
+		w = &limitedWriter{w: w, limit: maxOutputBytes}
+	}
+	return writeResults(w)
+}
+
+// Function to write the results to path through a temporary file in the same directory that
+// is renamed over path once complete, so path never holds partial results
+func writeResultsFile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	buffered := bufio.NewWriter(tmp)
+	err = writeLimited(buffered)
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err == nil {
+		err = tmp.Chmod(0o644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Function to write the results in the -output format
+func writeResults(w io.Writer) error {
+	if estimateDistinct {
+		_, err := fmt.Fprintf(w, "Distinct stations: %d (estimated)\n", workerTables.EstimateDistinct())
+		return err
+	}
+	if distinct := aggregatedDistinct.Load(); distinct > 0 {
+		_, err := fmt.Fprintf(w, "Distinct stations: %d (estimated)\n", distinct)
+		return err
+	}
+
+	if resultTemplate != nil {
+		return printTemplate(w)
+	}
+
+	switch outputFormat {
+	case "official":
+		return printOfficial(w)
+	case "json":
+		return printJSON(w)
+	case "csv":
+		return printCSV(w)
+	case "table":
+		return printTable(w)
+	case "markdown":
+		return printMarkdown(w)
+	case "prom":
+		return printPrometheus(w)
+	case "parquet":
+		return printParquet(w)
+	default:
+		return printResults(w)
+	}
+}
+
+// Struct to hold a formatted value of a result under its column name
+type column struct {
+	name, value string
+}
+
+// Function to list the columns the structured outputs print for a result: the -aggs
+// aggregates, the count, and the spread and percentiles when requested. Without stats
+// (nil) only the column names are filled in, for headers
+func resultColumns(s *stats.NameStats) []column {
+	var columns []column
+	add := func(name string, value func() string) {
+		c := column{name: name}
+		if s != nil {
+			c.value = value()
+		}
+		columns = append(columns, c)
+	}
+
+	if aggregates.min {
+		add("min", func() string { return formatMin(*s, fieldPrecision.min) })
+	}
+	if aggregates.max {
+		add("max", func() string { return formatMax(*s, fieldPrecision.max) })
+	}
+	if aggregates.mean {
+		add("mean", func() string { return formatMean(*s, fieldPrecision.mean) })
+	}
+	add("count", func() string { return strconv.Itoa(s.Count) })
+	if aggregates.sum {
+		add("sum", func() string { return formatSum(*s, fieldPrecision.mean) })
+	}
+	if showSpread {
+		add("variance", func() string { return output.FormatValue(s.Variance(), fieldPrecision.mean) })
+		add("stddev", func() string { return output.FormatValue(math.Sqrt(s.Variance()), fieldPrecision.mean) })
+	}
+	for _, p := range percentiles {
+		add("p"+strconv.FormatFloat(p, 'f', -1, 64), func() string { return output.FormatValue(s.Digest.Quantile(p/100)/10, fieldPrecision.mean) })
+	}
+	return columns
+}
+
+// Template parsed from -outputTemplate, nil without it
+var resultTemplate *template.Template
+
+// Struct to hold the fields of a result row as -outputTemplate sees them, formatted with
+// -precision and -rounding; aggregates left out of -aggs are empty
+type templateRow struct {
+	Name, Letter        string
+	Min, Max, Mean, Sum string
+	Count               int
+	Variance, StdDev    string
+	Percentiles         map[string]string // Keyed by column name, e.g. "p99"
+	Histogram           []int64
+}
+
+// Function to print every result through -outputTemplate, one row per line
+func printTemplate(w io.Writer) error {
+	var line bytes.Buffer
+	for i, r := range collectResults() {
+		row := templateRow{Name: r.name, Letter: string(letterOf(r.name)), Count: r.stats.Count, Histogram: r.stats.Histogram}
+		for _, c := range resultColumns(&r.stats) {
+			switch c.name {
+			case "min":
+				row.Min = c.value
+			case "max":
+				row.Max = c.value
+			case "mean":
+				row.Mean = c.value
+			case "sum":
+				row.Sum = c.value
+			case "variance":
+				row.Variance = c.value
+			case "stddev":
+				row.StdDev = c.value
+			case "count":
+			default:
+				if row.Percentiles == nil {
+					row.Percentiles = make(map[string]string)
+				}
+				row.Percentiles[c.name] = c.value
+			}
+		}
+
+		// Render the row first, so the output limit never cuts a line in half
+		line.Reset()
+		if err := resultTemplate.Execute(&line, row); err != nil {
+			return err
+		}
+		line.WriteByte('\n')
+		_, err := w.Write(line.Bytes())
+		if err == errOutputLimit {
+			return fmt.Errorf("%w after %d stations", err, i)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function to print the results as a JSON array of {"station": ..., "min": ..., ...} objects,
+// one per line; the histogram, when collected, is an array of bucket counts
+func printJSON(w io.Writer) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	for i, r := range collectResults() {
+		object, err := stationJSON(r)
+		if err != nil {
+			return err
+		}
+		separator := "\n  "
+		if i > 0 {
+			separator = ",\n  "
+		}
+
+		_, err = io.WriteString(w, separator+object)
+		if err == errOutputLimit {
+			return fmt.Errorf("%w after %d stations", err, i)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "\n]\n")
+	return err
+}
+
+// Function to format one result as the JSON object -output json and -serve print for it
+func stationJSON(r result) (string, error) {
+	station, err := json.Marshal(r.name)
+	if err != nil {
+		return "", err
+	}
+
+	var object strings.Builder
+	object.WriteString(`{"station": `)
+	object.Write(station)
+	for _, c := range resultColumns(&r.stats) {
+		fmt.Fprintf(&object, ", %q: %s", c.name, c.value)
+	}
+	if histogramBounds != nil {
+		counts, _ := json.Marshal(r.stats.Histogram)
+		object.WriteString(`, "histogram": `)
+		object.Write(counts)
+	}
+	object.WriteString("}")
+	return object.String(), nil
+}
+
+// Function to print the results as CSV with a header row, "station,min,max,mean,count" by default
+func printCSV(w io.Writer) error {
+	header := []string{"station"}
+	for _, c := range resultColumns(nil) {
+		header = append(header, c.name)
+	}
+	if err := writeCSVRecord(w, header); err != nil {
+		return err
+	}
+
+	for i, r := range collectResults() {
+		record := []string{r.name}
+		for _, c := range resultColumns(&r.stats) {
+			record = append(record, c.value)
+		}
+		err := writeCSVRecord(w, record)
+		if err == errOutputLimit {
+			return fmt.Errorf("%w after %d stations", err, i)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function to write one RFC 4180 record in a single write, quoting fields as needed
+func writeCSVRecord(w io.Writer, record []string) error {
+	var line bytes.Buffer
+	writer := csv.NewWriter(&line)
+	if err := writer.Write(record); err != nil {
+		return err
+	}
+	writer.Flush()
+	_, err := w.Write(line.Bytes())
+	return err
+}
+
+// Function to print the results as a column-aligned table for terminals, names left-aligned
+// and numbers right-aligned, followed by a totals row over all stations with -totals
+func printTable(w io.Writer) error {
+	results := collectResults()
+
+	// Lay out the cells first, the widths depend on every row
+	header := []string{"station"}
+	for _, c := range resultColumns(nil) {
+		header = append(header, c.name)
+	}
+	rows := append([][]string{header}, resultRows(results)...)
+
+	widths := make([]int, len(header))
+	for _, row := range rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+	rule := make([]string, len(widths))
+	for i, width := range widths {
+		rule[i] = strings.Repeat("-", width)
+	}
+
+	// The header and the totals row are set off by rules
+	lines := []string{output.TableRow(rows[0], widths), output.TableRow(rule, widths)}
+	for i, row := range rows[1:] {
+		if showTotals && i == len(results) {
+			lines = append(lines, output.TableRow(rule, widths))
+		}
+		lines = append(lines, output.TableRow(row, widths))
+	}
+	for i, line := range lines {
+		_, err := io.WriteString(w, line+"\n")
+		if err == errOutputLimit {
+			return fmt.Errorf("%w after %d stations", err, max(i-2, 0))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function to lay out the cells of the results, one row per station starting with its name,
+// followed by the totals row over all stations with -totals
+func resultRows(results []result) [][]string {
+	var rows [][]string
+	var total stats.NameStats
+	for i, r := range results {
+		row := []string{r.name}
+		for _, c := range resultColumns(&r.stats) {
+			row = append(row, c.value)
+		}
+		rows = append(rows, row)
+
+		if i == 0 {
+			total = r.stats.Clone()
+		} else {
+			total = total.Combine(r.stats)
+		}
+	}
+	if showTotals && len(results) > 0 {
+		row := []string{"total"}
+		for _, c := range resultColumns(&total) {
+			row = append(row, c.value)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// Function to print the results as a GitHub-flavored markdown table, numbers right-aligned
+// and the totals row in bold with -totals
+func printMarkdown(w io.Writer) error {
+	results := collectResults()
+
+	header := []string{"station"}
+	alignment := []string{"---"}
+	for _, c := range resultColumns(nil) {
+		header = append(header, c.name)
+		alignment = append(alignment, "---:")
+	}
+	lines := []string{output.MarkdownRow(header), output.MarkdownRow(alignment)}
+	for i, row := range resultRows(results) {
+		if i == len(results) {
+			for j := range row {
+				row[j] = "**" + row[j] + "**"
+			}
+		}
+		lines = append(lines, output.MarkdownRow(row))
+	}
+	for i, line := range lines {
+		_, err := io.WriteString(w, line+"\n")
+		if err == errOutputLimit {
+			return fmt.Errorf("%w after %d stations", err, max(i-2, 0))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Help texts of the Prometheus metrics by result column, percentiles go into one metric
+// told apart by a quantile label
+var prometheusHelp = map[string]string{
+	"min":      "Lowest reading of the station in degrees Celsius.",
+	"max":      "Highest reading of the station in degrees Celsius.",
+	"mean":     "Mean reading of the station in degrees Celsius.",
+	"count":    "Number of readings of the station.",
+	"sum":      "Sum of the readings of the station in degrees Celsius.",
+	"variance": "Population variance of the readings of the station in squared degrees Celsius.",
+	"stddev":   "Population standard deviation of the readings of the station in degrees Celsius.",
+	"quantile": "Estimated quantiles of the readings of the station in degrees Celsius.",
+}
+
+// Function to print the results in the Prometheus text exposition format, one gauge family per
+// result column with a sample per station, e.g. station_temp_min{station="Abha"} -23.0
+func printPrometheus(w io.Writer) error {
+	results := collectResults()
+	rows := make([][]column, len(results))
+	for i, r := range results {
+		rows[i] = resultColumns(&r.stats)
+	}
+
+	// Samples of a metric must follow each other, so the families go column by column
+	labelEscaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	samples := 0
+	var family string
+	for j, c := range resultColumns(nil) {
+		var out strings.Builder
+		metric, quantile := c.name, ""
+		if p, ok := strings.CutPrefix(c.name, "p"); ok {
+			value, _ := strconv.ParseFloat(p, 64)
+			metric, quantile = "quantile", strconv.FormatFloat(value/100, 'g', 15, 64)
+		}
+		if metric != family {
+			family = metric
+			fmt.Fprintf(&out, "# HELP station_temp_%s %s\n# TYPE station_temp_%s gauge\n", metric, prometheusHelp[metric], metric)
+		}
+		for i, r := range results {
+			fmt.Fprintf(&out, `station_temp_%s{station="%s"`, metric, labelEscaper.Replace(r.name))
+			if quantile != "" {
+				fmt.Fprintf(&out, `,quantile="%s"`, quantile)
+			}
+			fmt.Fprintf(&out, "} %s\n", rows[i][j].value)
+		}
+
+		_, err := io.WriteString(w, out.String())
+		if err == errOutputLimit {
+			return fmt.Errorf("%w after %d samples", err, samples)
+		}
+		if err != nil {
+			return err
+		}
+		samples += len(results)
+	}
+	return nil
+}
+
+// Function to print the results exactly like the 1BRC reference implementation,
+// "{Abha=-23.0/18.0/59.2, Abidjan=...}" sorted by name with min/mean/max to one decimal
+func printOfficial(w io.Writer) error {
+	results := collectResults()
+	sortResults(results, "name")
+
+	if _, err := io.WriteString(w, "{"); err != nil {
+		return err
+	}
+	for i, r := range results {
+		separator := ", "
+		if i == 0 {
+			separator = ""
+		}
+		_, err := fmt.Fprintf(w, "%s%s=%s/%s/%s", separator, r.name,
+			output.RoundHalfUp(int64(r.stats.Min), 10, 1), output.RoundHalfUpBig(r.stats.BigSum(), 10*int64(r.stats.Count), 1), output.RoundHalfUp(int64(r.stats.Max), 10, 1))
+		if err == errOutputLimit {
+			return fmt.Errorf("%w after %d stations", err, i)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "}\n")
+	return err
+}
+
+// Functions to format the exact stats of a name with the given decimals, rounded by -rounding
+func formatMin(s stats.NameStats, decimals int) string {
+	return formatExact(int64(s.Min), 10, decimals)
+}
+
+func formatMax(s stats.NameStats, decimals int) string {
+	return formatExact(int64(s.Max), 10, decimals)
+}
+
+func formatMean(s stats.NameStats, decimals int) string {
+	if s.SumHigh != 0 {
+		return formatBig(s.BigSum(), 10*int64(s.Count), decimals)
+	}
+	return formatExact(s.Sum, 10*int64(s.Count), decimals)
+}
+
+func formatSum(s stats.NameStats, decimals int) string {
+	if s.SumHigh != 0 {
+		return formatBig(s.BigSum(), 10, decimals)
+	}
+	return formatExact(s.Sum, 10, decimals)
+}
+
+// Function to format the fraction numerator/denominator with the given decimals, either
+// through the nearest float64 or rounded exactly with halves up
+func formatExact(numerator, denominator int64, decimals int) string {
+	if rounding == "halfUp" {
+		return output.RoundHalfUp(numerator, denominator, decimals)
+	}
+	return output.FormatValue(float64(numerator)/float64(denominator), decimals)
+}
+
+// Function to format a fraction like formatExact for a numerator overflowing an int64, a sum
+// held in two limbs
+func formatBig(numerator *big.Int, denominator int64, decimals int) string {
+	if rounding == "halfUp" {
+		return output.RoundHalfUpBig(numerator, denominator, decimals)
+	}
+	value, _ := new(big.Rat).SetFrac(numerator, big.NewInt(denominator)).Float64()
+	return output.FormatValue(value, decimals)
+}
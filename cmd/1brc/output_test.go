@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"example.com/mod/onebrc"
+)
+
+// Checks that -precision 0 prints whole numbers without a decimal point, a rounded -0 as 0,
+// and rounds halves to even by default and up with -rounding halfUp
+func TestPrecisionZero(t *testing.T) {
+	path := writeTestInput(t, "measurements.txt", "a;-0.4\na;0.4\nb;2.5\nb;-2.5\nc;-1.5\nc;-0.5\nd;1.5\ne;-12.0\n")
+	tests := []struct {
+		rounding string
+		want     string
+	}{
+		{"float", "station,min,max,mean,count,sum\na,0,0,0,2,0\nb,-2,2,0,2,0\nc,-2,0,-1,2,-2\nd,2,2,2,1,2\ne,-12,-12,-12,1,-12\n"},
+		{"halfUp", "station,min,max,mean,count,sum\na,0,0,0,2,0\nb,-2,3,0,2,0\nc,-1,0,-1,2,-2\nd,2,2,2,1,2\ne,-12,-12,-12,1,-12\n"},
+	}
+	for _, test := range tests {
+		got, code := runCLI(t, "-file", path, "-precision", "0", "-rounding", test.rounding, "-aggs", "min,max,mean,count,sum", "-output", "csv")
+		if code != 0 || string(got) != test.want {
+			t.Errorf("-rounding %s exited with %d and printed\n%s\nwant\n%s", test.rounding, code, got, test.want)
+		}
+	}
+
+	got, code := runCLI(t, "-file", path, "-precision", "0")
+	if want := "Letter: a, Name: a, Min: 0, Max: 0, Avg: 0\n"; code != 0 || !strings.HasPrefix(string(got), want) {
+		t.Errorf("text output exited with %d and printed\n%s\nwant it to start with %q", code, got, want)
+	}
+}
+
+func TestParsePrecision(t *testing.T) {
+	tests := []struct {
+		spec string
+		want FieldPrecision
+		ok   bool
+	}{
+		{"1", FieldPrecision{min: 1, max: 1, mean: 1}, true},
+		{" 0 ", FieldPrecision{}, true},
+		{"min=1,max=1,mean=2", FieldPrecision{min: 1, max: 1, mean: 2}, true},
+		{"avg=3", FieldPrecision{min: 2, max: 2, mean: 3}, true},
+		{"max=0, min = 4", FieldPrecision{min: 4, max: 0, mean: 2}, true},
+		{"-1", FieldPrecision{}, false},
+		{"min=1,median=2", FieldPrecision{}, false},
+		{"min=-1", FieldPrecision{}, false},
+		{"min", FieldPrecision{}, false},
+		{"min=x", FieldPrecision{}, false},
+	}
+	for _, test := range tests {
+		got, err := parsePrecision(test.spec)
+		if (err == nil) != test.ok || got != test.want {
+			t.Errorf("parsePrecision(%q) = %+v, %v, want %+v and ok %v", test.spec, got, err, test.want, test.ok)
+		}
+	}
+}
+
+// Checks that every field is printed with its own precision, each rounded with -rounding
+func TestPerFieldPrecision(t *testing.T) {
+	path := writeTestInput(t, "measurements.txt", "Hamburg;12.0\nHamburg;-3.4\nHamburg;0.9\nHamburg;0.6\n")
+	tests := []struct {
+		spec, rounding string
+		want           string
+	}{
+		// The mean is 2.525, just below in a float64 and exactly half of a hundredth above 2.52
+		{"min=1,max=1,mean=2", "float", "Hamburg,-3.4,12.0,2.52,4\n"},
+		{"min=1,max=1,mean=2", "halfUp", "Hamburg,-3.4,12.0,2.53,4\n"},
+		{"min=0,max=3,mean=1", "float", "Hamburg,-3,12.000,2.5,4\n"},
+		{"min=0,mean=0", "halfUp", "Hamburg,-3,12.00,3,4\n"},
+		{"1", "halfUp", "Hamburg,-3.4,12.0,2.5,4\n"},
+	}
+	for _, test := range tests {
+		got, code := runCLI(t, "-file", path, "-precision", test.spec, "-rounding", test.rounding, "-output", "csv")
+		if want := "station,min,max,mean,count\n" + test.want; code != 0 || string(got) != want {
+			t.Errorf("-precision %s -rounding %s exited with %d and printed\n%s\nwant\n%s", test.spec, test.rounding, code, got, want)
+		}
+	}
+}
+
+// Checks that -max-output-bytes stops before the first line that does not fit, 0 being unlimited
+func TestMaxOutputBytes(t *testing.T) {
+	path := filepath.Join("testdata", "basic.txt")
+	full, code := runCLI(t, "-file", path)
+	if code != 0 {
+		t.Fatalf("unlimited run exited with %d", code)
+	}
+	firstTwo := strings.Join(strings.SplitAfter(string(full), "\n")[:2], "")
+
+	tests := []struct {
+		limit string
+		want  string
+		code  int
+	}{
+		{"0", string(full), 0},
+		{fmt.Sprint(len(full)), string(full), 0},
+		{"1", "", 1},
+		{"10", "", 1},
+		{fmt.Sprint(len(firstTwo) + 5), firstTwo, 1},
+		{fmt.Sprint(len(full) - 1), string(full[:strings.LastIndex(string(full[:len(full)-1]), "\n")+1]), 1},
+		{"-1", "", 2},
+	}
+	for _, test := range tests {
+		got, code := runCLI(t, "-file", path, "-max-output-bytes", test.limit)
+		if code != test.code || string(got) != test.want {
+			t.Errorf("-max-output-bytes %s exited with %d and printed\n%s\nwant %d and\n%s", test.limit, code, got, test.code, test.want)
+		}
+	}
+}
+
+// Checks that -out replaces its file atomically: a write failing after several buffers reached the
+// temporary file leaves the previous results as they were and no temporary file behind
+func TestWriteResultsFileAtomic(t *testing.T) {
+	var input strings.Builder
+	for i := range 2000 {
+		fmt.Fprintf(&input, "Station %04d;%d.5\n", i, i%100)
+	}
+	path := writeTestInput(t, "measurements.txt", input.String())
+	full, code := runCLI(t, "-file", path)
+	if code != 0 {
+		t.Fatalf("run exited with %d", code)
+	}
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "results.txt")
+	previous := []byte("previous results\n")
+	if err := os.WriteFile(out, previous, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	checkDir := func(want []byte) {
+		t.Helper()
+		if got, err := os.ReadFile(out); err != nil || string(got) != string(want) {
+			t.Errorf("-out file holds %d bytes (%v), want %d", len(got), err, len(want))
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 {
+			var names []string
+			for _, e := range entries {
+				names = append(names, e.Name())
+			}
+			t.Errorf("-out left %v behind, want only results.txt", names)
+		}
+	}
+
+	// The limit fails the write well past the first buffers of the temporary file
+	if _, code := runCLI(t, "-file", path, "-out", out, "-max-output-bytes", fmt.Sprint(len(full)-100)); code != 1 {
+		t.Errorf("-out over -max-output-bytes exited with %d, want 1", code)
+	}
+	checkDir(previous)
+
+	if _, code := runCLI(t, "-file", path, "-out", out); code != 0 {
+		t.Fatalf("-out exited with %d", code)
+	}
+	checkDir(full)
+	if info, err := os.Stat(out); err != nil || info.Mode().Perm() != 0o644 {
+		t.Errorf("-out file has mode %v (%v), want 0644", info.Mode(), err)
+	}
+
+	if _, code := runCLI(t, "-file", path, "-out", filepath.Join(dir, "missing", "results.txt")); code != 1 {
+		t.Errorf("-out in a missing directory exited with %d, want 1", code)
+	}
+}
+
+// Checks that onebrc.WriteResultsSorted in FormatOfficial prints what -output official prints,
+// means ending in a half of a tenth rounded up
+func TestOfficialMatchesLibrary(t *testing.T) {
+	input := "a;1.0\na;1.5\nb;-1.0\nb;-1.5\nc;0.1\nc;0.2\nd;12.3\nd;12.4\nd;-0.1\nd;-0.1\ne;-0.05\n"
+	want := "{a=1.0/1.3/1.5, b=-1.5/-1.2/-1.0, c=0.1/0.2/0.2, d=-0.1/6.1/12.4, e=-0.1/-0.1/-0.1}\n"
+	path := writeTestInput(t, "measurements.txt", input)
+	cli, code := runCLI(t, "-file", path, "-output", "official")
+	if code != 0 || string(cli) != want {
+		t.Fatalf("-output official exited with %d and printed %s, want %s", code, cli, want)
+	}
+
+	results, err := onebrc.Process(context.Background(), strings.NewReader(input), onebrc.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var library bytes.Buffer
+	if err := onebrc.WriteResultsSorted(&library, results.Stations, nil, onebrc.FormatOfficial); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(library.Bytes(), cli) {
+		t.Errorf("FormatOfficial wrote %s, -output official printed %s", library.Bytes(), cli)
+	}
+}
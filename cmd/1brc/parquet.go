@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/parquet-go/parquet-go"
+
+	"example.com/mod/internal/parse"
+	"example.com/mod/internal/stats"
+)
+
+// Function to read the name and number columns of a local Parquet file, its row groups in parallel
+func readParquet(ctx context.Context, path string) error {
+	file, err := openInputFile(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	pf, err := parquet.OpenFile(file, info.Size())
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	countBytesRead(info.Size())
+
+	// Look the columns up by name, only flat (top-level) columns are supported
+	nameColumn, ok := pf.Schema().Lookup(nameField)
+	if !ok {
+		return fmt.Errorf("%s: no %q column", path, nameField)
+	}
+	valueColumn, ok := pf.Schema().Lookup(valueField)
+	if !ok {
+		return fmt.Errorf("%s: no %q column", path, valueField)
+	}
+	if nameColumn.MaxRepetitionLevel > 0 || valueColumn.MaxRepetitionLevel > 0 {
+		return fmt.Errorf("%s: repeated %q or %q columns are not supported", path, nameField, valueField)
+	}
+
+	// Number the first row of every row group, the row groups are then handed to a fixed set of
+	// workers so a file with thousands of small row groups does not start thousands of goroutines
+	rowGroups := pf.RowGroups()
+	firstRows := make([]int64, len(rowGroups))
+	for i := 1; i < len(rowGroups); i++ {
+		firstRows[i] = firstRows[i-1] + rowGroups[i-1].NumRows()
+	}
+	indexes := make(chan int, len(rowGroups))
+	for i := range rowGroups {
+		indexes <- i
+	}
+	close(indexes)
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(rowGroups))
+	for range min(workers, len(rowGroups)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			table := workerTables.NewTable()
+			defer workerTables.Release(table)
+			for i := range indexes {
+				columns := rowGroups[i].ColumnChunks()
+				errs[i] = readRowGroup(ctx, table, columns[nameColumn.ColumnIndex], columns[valueColumn.ColumnIndex], firstRows[i])
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function to fold the rows of one row group into the stats, reading both columns in lockstep;
+// the row number in the file stands in for the byte offset of text input
+func readRowGroup(ctx context.Context, table *stats.Table, nameChunk, valueChunk parquet.ColumnChunk, firstRow int64) error {
+	names := newColumnReader(nameChunk)
+	defer names.close()
+	values := newColumnReader(valueChunk)
+	defer values.close()
+
+	nameBuf := make([]parquet.Value, batchSize)
+	valueBuf := make([]parquet.Value, batchSize)
+	rowNumber, rowsCounted := firstRow, table.Rows
+	defer countRowsRead(table, &rowsCounted)
+	for !stopRequested(ctx) {
+		n, err := names.read(nameBuf)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		m, valueErr := values.read(valueBuf[:n])
+		if valueErr != nil && valueErr != io.EOF {
+			return valueErr
+		}
+		if m != n {
+			return errors.New("parquet columns hold different numbers of values")
+		}
+
+		for i := 0; i < n; i++ {
+			processParquetValue(table, nameBuf[i], valueBuf[i], rowNumber)
+			rowNumber++
+		}
+		countRowsRead(table, &rowsCounted)
+		if err == io.EOF {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Function to fold one row of a Parquet file into a worker's table; the row is only formatted as
+// a line for the errors, valid rows are folded without building it
+func processParquetValue(table *stats.Table, nameValue, numberValue parquet.Value, rowNumber int64) {
+	if nameValue.IsNull() || numberValue.IsNull() {
+		line := parquetLine(nameValue, numberValue)
+		reportParseError(fmt.Errorf("null value: %s", line), line, rowNumber)
+		return
+	}
+
+	name := strings.TrimSpace(string(nameValue.ByteArray()))
+	if name == "" {
+		line := parquetLine(nameValue, numberValue)
+		reportParseError(fmt.Errorf("empty name: %s", line), line, rowNumber)
+		return
+	}
+	name = lineParser.Canonical(name)
+
+	number, err := parquetNumber(numberValue)
+	if err != nil {
+		reportParseError(err, parquetLine(nameValue, numberValue), rowNumber)
+		return
+	}
+	tenths, ok := parse.RoundTenths(parse.ToCelsius(number, lineParser.Unit))
+	if !ok {
+		reportParseError(fmt.Errorf("number out of range: %s", numberValue.String()), parquetLine(nameValue, numberValue), rowNumber)
+		return
+	}
+
+	if !lineParser.Range.Accept(tenths, 1) {
+		return
+	}
+	table.Update(name, tenths, 1)
+}
+
+// Function to format a row of a Parquet file as the line -rejectFile and the errors show for it
+func parquetLine(nameValue, numberValue parquet.Value) string {
+	return nameValue.String() + lineParser.Delimiter + numberValue.String()
+}
+
+// Function to convert a value of a numeric (or numeric string) Parquet column to a float64
+func parquetNumber(v parquet.Value) (float64, error) {
+	switch v.Kind() {
+	case parquet.Double:
+		return v.Double(), nil
+	case parquet.Float:
+		// Go through the shortest decimal form so 12.3 stays 12.3 instead of 12.300000190734863
+		return strconv.ParseFloat(strconv.FormatFloat(float64(v.Float()), 'f', -1, 32), 64)
+	case parquet.Int32, parquet.Int64:
+		return float64(v.Int64()), nil
+	case parquet.ByteArray, parquet.FixedLenByteArray:
+		numberStr := strings.TrimSpace(string(v.ByteArray()))
+		if tenths, ok := parse.Tenths(numberStr); ok {
+			return float64(tenths) / 10, nil
+		}
+		number, err := strconv.ParseFloat(numberStr, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number: %s", numberStr)
+		}
+		return number, nil
+	default:
+		return 0, fmt.Errorf("unsupported %v value in %q column", v.Kind(), valueField)
+	}
+}
+
+// Struct to read the values of a column chunk across its pages
+type columnReader struct {
+	pages  parquet.Pages
+	values parquet.ValueReader
+}
+
+// Function to start reading the values of a column chunk
+func newColumnReader(c parquet.ColumnChunk) *columnReader {
+	return &columnReader{pages: c.Pages()}
+}
+
+// Function to fill buf with the next values, moving on to the next page as pages run out;
+// it returns io.EOF once the chunk is exhausted. The values stay valid until the next read
+func (r *columnReader) read(buf []parquet.Value) (int, error) {
+	filled := 0
+	for filled < len(buf) {
+		if r.values == nil {
+			// Values may point into the buffers of the previous page, copy them before it is reused
+			for i := 0; i < filled; i++ {
+				buf[i] = buf[i].Clone()
+			}
+			page, err := r.pages.ReadPage()
+			if err != nil {
+				return filled, err
+			}
+			r.values = page.Values()
+		}
+		n, err := r.values.ReadValues(buf[filled:])
+		filled += n
+		if err == io.EOF {
+			r.values = nil
+		} else if err != nil {
+			return filled, err
+		}
+	}
+	return filled, nil
+}
+
+// Function to release the pages of the column chunk
+func (r *columnReader) close() {
+	r.pages.Close()
+}
+
+// Function to write the results as a Parquet file with a station column and a column per
+// result column, count as INT64 and every other stat as DOUBLE
+func printParquet(w io.Writer) error {
+	group := parquet.Group{"station": parquet.String()}
+	for _, c := range resultColumns(nil) {
+		if c.name == "count" {
+			group[c.name] = parquet.Int(64)
+		} else {
+			group[c.name] = parquet.Leaf(parquet.DoubleType)
+		}
+	}
+	schema := parquet.NewSchema("results", group)
+	writer := parquet.NewWriter(w, schema)
+
+	for _, r := range collectResults() {
+		values := map[string]parquet.Value{"station": parquet.ValueOf(r.name)}
+		for _, c := range resultColumns(&r.stats) {
+			if c.name == "count" {
+				values[c.name] = parquet.ValueOf(int64(r.stats.Count))
+				continue
+			}
+			// The formatted value, so the file holds what -precision prints
+			number, err := strconv.ParseFloat(c.value, 64)
+			if err != nil {
+				return err
+			}
+			values[c.name] = parquet.ValueOf(number)
+		}
+
+		// The group orders its columns by name, place the values accordingly
+		row := make(parquet.Row, len(schema.Columns()))
+		for i, path := range schema.Columns() {
+			row[i] = values[path[0]].Level(0, 0, i)
+		}
+		if _, err := writer.WriteRows([]parquet.Row{row}); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// Struct to hold a row of a -format parquet test file with a FLOAT reading, either column may be null
+type parquetFloatRow struct {
+	Station *string  `parquet:"station,optional"`
+	Temp    *float32 `parquet:"temp,optional"`
+}
+
+// Function to write rows to a Parquet file in a temporary directory of the test, ending a row group
+// every rowsPerGroup rows, returning its path
+func writeParquetInput[T any](t *testing.T, rows []T, rowsPerGroup int64) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "measurements.parquet")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	writer := parquet.NewGenericWriter[T](file, parquet.MaxRowsPerRowGroup(rowsPerGroup))
+	if _, err := writer.Write(rows); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Function to count the row groups of a Parquet file
+func parquetRowGroups(t *testing.T, path string) int {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pf, err := parquet.OpenFile(file, info.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(pf.RowGroups())
+}
+
+// Checks -format parquet over a FLOAT column split into several row groups read in parallel: the
+// readings go through their shortest decimal form, null and empty names and null readings are
+// reported with their row number
+func TestParquetInput(t *testing.T) {
+	name := func(s string) *string { return &s }
+	temp := func(f float32) *float32 { return &f }
+	rows := []parquetFloatRow{
+		{name("Hamburg"), temp(12.3)},
+		{name("Bulawayo"), temp(8.9)},
+		{nil, temp(1)},
+		{name("Hamburg"), temp(-3.4)},
+		{name(" "), temp(2)},
+		{name("Bulawayo"), nil},
+		{name("Palembang"), temp(0.45)}, // 0.449999988 as a float32
+		{name("Hamburg"), temp(99.9)},
+	}
+	path := writeParquetInput(t, rows, 3)
+	if groups := parquetRowGroups(t, path); groups != 3 {
+		t.Fatalf("test file has %d row groups, want 3", groups)
+	}
+
+	rejects := filepath.Join(t.TempDir(), "rejects.txt")
+	stdout, code := runCLI(t, "-file", path, "-format", "parquet", "-workers", "2", "-rejectFile", rejects)
+	if code != 0 {
+		t.Fatalf("run exited with %d", code)
+	}
+	want := "Letter: b, Name: Bulawayo, Min: 8.90, Max: 8.90, Avg: 8.90\n" +
+		"Letter: h, Name: Hamburg, Min: -3.40, Max: 99.90, Avg: 36.27\n" +
+		"Letter: p, Name: Palembang, Min: 0.50, Max: 0.50, Avg: 0.50\n"
+	if got := string(stdout); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	data, err := os.ReadFile(rejects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRejects := path + ":2\tnull value: <null>;1\t<null>;1\n" +
+		path + ":4\tempty name:  ;2\t ;2\n" +
+		path + ":5\tnull value: Bulawayo;<null>\tBulawayo;<null>\n"
+	// The row groups are read in parallel, their rejects may interleave
+	lines := strings.SplitAfter(string(data), "\n")
+	sort.Strings(lines)
+	if got := strings.Join(lines, ""); got != wantRejects {
+		t.Errorf("-rejectFile holds\n%s\nwant\n%s", got, wantRejects)
+	}
+}
+
+// Checks that -output parquet reads back through -format parquet: the mean column of every station
+// becomes its only reading, the INT64 count column reads as whole numbers
+func TestParquetRoundTrip(t *testing.T) {
+	input := writeTestInput(t, "measurements.txt", "Hamburg;12.0\nBulawayo;8.9\nHamburg;-3.4\nPalembang;38.8\nHamburg;34.3\n")
+	results := filepath.Join(t.TempDir(), "results.parquet")
+	if _, code := runCLI(t, "-file", input, "-output", "parquet", "-aggs", "min,max,mean,count", "-out", results); code != 0 {
+		t.Fatalf("-output parquet exited with %d", code)
+	}
+
+	tests := []struct {
+		valueField string
+		want       string
+	}{
+		{"mean", "Letter: b, Name: Bulawayo, Min: 8.90, Max: 8.90, Avg: 8.90\n" +
+			"Letter: h, Name: Hamburg, Min: 14.30, Max: 14.30, Avg: 14.30\n" +
+			"Letter: p, Name: Palembang, Min: 38.80, Max: 38.80, Avg: 38.80\n"},
+		{"max", "Letter: b, Name: Bulawayo, Min: 8.90, Max: 8.90, Avg: 8.90\n" +
+			"Letter: h, Name: Hamburg, Min: 34.30, Max: 34.30, Avg: 34.30\n" +
+			"Letter: p, Name: Palembang, Min: 38.80, Max: 38.80, Avg: 38.80\n"},
+		{"count", "Letter: b, Name: Bulawayo, Min: 1.00, Max: 1.00, Avg: 1.00\n" +
+			"Letter: h, Name: Hamburg, Min: 3.00, Max: 3.00, Avg: 3.00\n" +
+			"Letter: p, Name: Palembang, Min: 1.00, Max: 1.00, Avg: 1.00\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.valueField, func(t *testing.T) {
+			stdout, code := runCLI(t, "-file", results, "-format", "parquet", "-nameField", "station", "-valueField", tt.valueField)
+			if code != 0 {
+				t.Fatalf("reading the results back exited with %d", code)
+			}
+			if got := string(stdout); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+
+	// The file only holds the -aggs columns
+	if _, code := runCLI(t, "-file", results, "-format", "parquet", "-nameField", "station", "-valueField", "sum"); code != 1 {
+		t.Errorf("reading a sum column -aggs left out exited with %d, want 1", code)
+	}
+}
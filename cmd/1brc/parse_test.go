@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Checks that -groupBy and -group-col aggregate every (station, category) pair on its own
+func TestCompositeKey(t *testing.T) {
+	path := writeTestInput(t, "measurements.txt", "Hamburg;indoor;21.0\nHamburg;outdoor;5.0\nHamburg;indoor;23.0\nBulawayo;outdoor;30.0\nHamburg;outdoor;-1.0\n")
+	want := "station,min,max,mean,count\nBulawayo/outdoor,30.00,30.00,30.00,1\nHamburg/indoor,21.00,23.00,22.00,2\nHamburg/outdoor,-1.00,5.00,2.00,2\n"
+	for _, grouping := range [][]string{{"-groupBy", "0,1"}, {"-group-col", "1"}} {
+		args := append([]string{"-file", path, "-value-col", "2", "-aggs", "min,max,mean,count", "-output", "csv"}, grouping...)
+		got, code := runCLI(t, args...)
+		if code != 0 || string(got) != want {
+			t.Errorf("%v exited with %d and printed\n%s\nwant\n%s", grouping, code, got, want)
+		}
+	}
+
+	// Without grouping the categories of a station merge
+	got, code := runCLI(t, "-file", path, "-value-col", "2", "-aggs", "min,max,mean,count", "-output", "csv")
+	if want := "station,min,max,mean,count\nBulawayo,30.00,30.00,30.00,1\nHamburg,-1.00,23.00,12.00,4\n"; code != 0 || string(got) != want {
+		t.Errorf("ungrouped run exited with %d and printed\n%s\nwant\n%s", code, got, want)
+	}
+}
+
+// Checks that -weight-col counts every row as many readings as its weight, giving the same stats as
+// the expanded rows, and that rows with a zero or negative weight are malformed
+func TestWeightColumn(t *testing.T) {
+	weighted := writeTestInput(t, "weighted.txt", "Hamburg;12.0;3\nHamburg;-3.4;1\nBulawayo;8.9;2\nHamburg;0.5;4\n")
+	expanded := writeTestInput(t, "expanded.txt", strings.Repeat("Hamburg;12.0\n", 3)+"Hamburg;-3.4\n"+
+		strings.Repeat("Bulawayo;8.9\n", 2)+strings.Repeat("Hamburg;0.5\n", 4))
+	output := []string{"-aggs", "min,max,mean,count,sum", "-stddev", "-output", "csv", "-precision", "3"}
+
+	want, code := runCLI(t, append([]string{"-file", expanded}, output...)...)
+	if code != 0 {
+		t.Fatalf("expanded run exited with %d", code)
+	}
+	got, code := runCLI(t, append([]string{"-file", weighted, "-weight-col", "2"}, output...)...)
+	if code != 0 || string(got) != string(want) {
+		t.Errorf("weighted run exited with %d and printed\n%s\nwant, as the expanded rows:\n%s", code, got, want)
+	}
+	if !strings.Contains(string(want), "Hamburg,-3.400,12.000,4.325,8,34.600") {
+		t.Errorf("expanded run printed\n%s\nwant a weighted mean of 4.325 over 8 Hamburg readings", want)
+	}
+
+	for _, weight := range []string{"0", "-2", "1.5", "x"} {
+		path := writeTestInput(t, "invalid.txt", "Hamburg;12.0;1\nHamburg;-3.4;"+weight+"\n")
+		got, code := runCLI(t, "-file", path, "-weight-col", "2", "-aggs", "count", "-output", "csv")
+		if code != 0 || string(got) != "station,count\nHamburg,1\n" {
+			t.Errorf("weight %s exited with %d and printed\n%s\nwant the row left out", weight, code, got)
+		}
+		if _, code := runCLI(t, "-file", path, "-weight-col", "2", "-onError", "fail"); code != 1 {
+			t.Errorf("weight %s with -onError fail exited with %d, want 1", weight, code)
+		}
+	}
+}
+
+// Checks that sums of heavily weighted rows overflowing an int64 of tenths stay exact, also the
+// sums that overflow on the way and end up back in range
+func TestWeightColumnOverflow(t *testing.T) {
+	var input strings.Builder
+	for range 10 {
+		input.WriteString("Bulawayo;999.9;1000000000000000\nBulawayo;999.9;1000000000000000\n")
+		input.WriteString("Hamburg;999.9;1000000000000000\n")
+	}
+	input.WriteString("Hamburg;12.3;1\n")
+	for range 10 {
+		input.WriteString("Hamburg;-999.9;1000000000000000\n")
+	}
+	path := writeTestInput(t, "overflow.txt", input.String())
+
+	for _, rounding := range []string{"float", "halfUp"} {
+		got, code := runCLI(t, "-file", path, "-weight-col", "2", "-aggs", "min,max,mean,count,sum", "-output", "csv",
+			"-rounding", rounding, "-workers", "3")
+		want := "station,min,max,mean,count,sum\nBulawayo,999.90,999.90,999.90,20000000000000000,19998000000000000000.00\n" +
+			"Hamburg,-999.90,999.90,0.00,20000000000000001,12.30\n"
+		if code != 0 || string(got) != want {
+			t.Errorf("-rounding %s exited with %d and printed\n%s\nwant\n%s", rounding, code, got, want)
+		}
+	}
+}
+
+// Checks that -delimiter auto reads semicolon, comma and tab inputs alike and stops on an ambiguous one
+func TestDelimiterAuto(t *testing.T) {
+	want := "Letter: b, Name: Bulawayo, Min: 8.90, Max: 8.90, Avg: 8.90\nLetter: h, Name: Hamburg, Min: -3.40, Max: 12.00, Avg: 4.30\n"
+	for _, separator := range []string{";", ",", "\t"} {
+		path := writeTestInput(t, "measurements.txt", strings.ReplaceAll("Hamburg;12.0\nBulawayo;8.9\nHamburg;-3.4\n", ";", separator))
+		got, code := runCLI(t, "-file", path, "-delimiter", "auto")
+		if code != 0 || string(got) != want {
+			t.Errorf("%q input exited with %d and printed\n%s\nwant\n%s", separator, code, got, want)
+		}
+	}
+
+	path := writeTestInput(t, "measurements.txt", "a;1;x,2,y\n")
+	if got, code := runCLI(t, "-file", path, "-delimiter", "auto", "-weight-col", "2"); code != 1 || len(got) != 0 {
+		t.Errorf("ambiguous input exited with %d and printed %q, want 1 and nothing", code, got)
+	}
+}
+
+// Checks that -quoted keeps delimiters and escaped quotes inside quoted names
+func TestQuotedFields(t *testing.T) {
+	path := writeTestInput(t, "measurements.txt", "\"Foo;Bar\";12.3\n\"Say \"\"hi\"\";x\";1.0\n\"Foo;Bar\";-2.0\n")
+	got, code := runCLI(t, "-file", path, "-quoted", "-output", "json")
+	want := `[
+  {"station": "Foo;Bar", "min": -2.00, "max": 12.30, "mean": 5.15, "count": 2},
+  {"station": "Say \"hi\";x", "min": 1.00, "max": 1.00, "mean": 1.00, "count": 1}
+]
+`
+	if code != 0 || string(got) != want {
+		t.Errorf("-quoted exited with %d and printed\n%s\nwant\n%s", code, got, want)
+	}
+}
+
+// Checks that -input-unit converts F readings to Celsius before they are aggregated
+func TestInputUnit(t *testing.T) {
+	path := writeTestInput(t, "measurements.txt", "Hamburg;53.6\nHamburg;26.6\nBulawayo;48.02\nHamburg;-4\n")
+	got, code := runCLI(t, "-file", path, "-input-unit", "F", "-aggs", "min,max,mean", "-output", "csv", "-precision", "1")
+	if want := "station,min,max,mean,count\nBulawayo,8.9,8.9,8.9,1\nHamburg,-20.0,12.0,-3.7,3\n"; code != 0 || string(got) != want {
+		t.Errorf("-input-unit F exited with %d and printed\n%s\nwant\n%s", code, got, want)
+	}
+}
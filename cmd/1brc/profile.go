@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	_ "net/http/pprof" // Registers the /debug/pprof handlers
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"sync"
+	"time"
+)
+
+// Function to start the profiles requested by -cpuprofile, -memprofile and -trace around the
+// processing phase, returning a function that stops them and writes them out; it may be
+// called more than once, only the first call counts
+func startProfiling() (func(), error) {
+	var cpuFile *os.File
+	if cpuProfilePath != "" {
+		file, err := os.Create(cpuProfilePath)
+		if err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(file); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("starting CPU profile: %w", err)
+		}
+		cpuFile = file
+	}
+
+	var traceFile *os.File
+	if tracePath != "" {
+		file, err := os.Create(tracePath)
+		if err != nil {
+			stopCPUProfile(cpuFile)
+			return nil, err
+		}
+		if err := trace.Start(file); err != nil {
+			file.Close()
+			stopCPUProfile(cpuFile)
+			return nil, fmt.Errorf("starting trace: %w", err)
+		}
+		traceFile = file
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if traceFile != nil {
+				trace.Stop()
+				if err := traceFile.Close(); err != nil {
+					slog.Error("writing trace failed", "file", tracePath, "err", err)
+				}
+			}
+			stopCPUProfile(cpuFile)
+			if memProfilePath != "" {
+				if err := writeHeapProfile(memProfilePath); err != nil {
+					slog.Error("writing memory profile failed", "file", memProfilePath, "err", err)
+				}
+			}
+		})
+	}, nil
+}
+
+// Function to stop the CPU profile written to file, if one is running
+func stopCPUProfile(file *os.File) {
+	if file == nil {
+		return
+	}
+	pprof.StopCPUProfile()
+	if err := file.Close(); err != nil {
+		slog.Error("writing CPU profile failed", "file", cpuProfilePath, "err", err)
+	}
+}
+
+// Function to write a heap profile to path, collecting garbage first so it shows live memory
+func writeHeapProfile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	runtime.GC()
+	err = pprof.WriteHeapProfile(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Function to serve the live /debug/pprof endpoints on addr for the rest of the run, also
+// sampling blocking and mutex contention so the stalls of the pipeline show up
+func startPprofServer(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	runtime.SetBlockProfileRate(int(time.Millisecond))
+	runtime.SetMutexProfileFraction(100)
+	go func() {
+		if err := http.Serve(listener, nil); err != nil {
+			slog.Error("pprof server stopped", "err", err)
+		}
+	}()
+	slog.Info("serving pprof", "url", "http://"+listener.Addr().String()+"/debug/pprof/")
+	return nil
+}
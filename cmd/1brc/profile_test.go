@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// Checks that -cpuprofile and -memprofile each write a non-empty gzipped pprof profile, together or
+// alone, and that a profile that cannot be created fails the run
+func TestProfiles(t *testing.T) {
+	path := writeTestInput(t, "measurements.txt", testMeasurements(20000))
+	tests := []struct {
+		name  string
+		flags []string
+	}{
+		{"cpu", []string{"-cpuprofile"}},
+		{"memory", []string{"-memprofile"}},
+		{"both", []string{"-cpuprofile", "-memprofile"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			args := []string{"-file", path, "-q"}
+			for _, flag := range tt.flags {
+				args = append(args, flag, filepath.Join(dir, flag[1:]+".pprof"))
+			}
+			if _, code := runCLI(t, args...); code != 0 {
+				t.Fatalf("%v exited with %d", args, code)
+			}
+			for _, flag := range tt.flags {
+				data, err := os.ReadFile(filepath.Join(dir, flag[1:]+".pprof"))
+				if err != nil || !bytes.HasPrefix(data, []byte{0x1f, 0x8b}) || len(data) < 100 {
+					t.Errorf("%s wrote %d bytes (%v), want a gzipped profile", flag, len(data), err)
+				}
+			}
+		})
+	}
+
+	if _, code := runCLI(t, "-file", path, "-cpuprofile", filepath.Join(t.TempDir(), "missing", "cpu.pprof")); code != 1 {
+		t.Errorf("unwritable -cpuprofile exited with %d, want 1", code)
+	}
+}
+
+// Checks that -trace writes an execution trace holding the regions of the run, and that a trace
+// that cannot be created fails the run
+func TestTrace(t *testing.T) {
+	path := writeTestInput(t, "measurements.txt", testMeasurements(20000))
+	out := filepath.Join(t.TempDir(), "trace.out")
+	if _, code := runCLI(t, "-file", path, "-q", "-trace", out); code != 0 {
+		t.Fatalf("-trace exited with %d", code)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := data[:min(len(data), 16)]
+	if !bytes.HasPrefix(header, []byte("go 1.")) || !bytes.Contains(header, []byte(" trace\x00")) {
+		t.Errorf("-trace wrote %d bytes starting %q, want an execution trace", len(data), header)
+	}
+	for _, region := range []string{"readInput", "mergeTables"} {
+		if !bytes.Contains(data, []byte(region)) {
+			t.Errorf("trace holds no %s region", region)
+		}
+	}
+
+	if _, code := runCLI(t, "-file", path, "-trace", filepath.Join(t.TempDir(), "missing", "trace.out")); code != 1 {
+		t.Errorf("unwritable -trace exited with %d, want 1", code)
+	}
+}
+
+// Checks that -gcpercent and -memlimit tune the collector before the run, which prints the same
+// results with the collector off, and that an invalid -memlimit exits 2
+func TestGCFlags(t *testing.T) {
+	path := writeTestInput(t, "measurements.txt", testMeasurements(1000))
+	want, code := runCLI(t, "-file", path)
+	if code != 0 {
+		t.Fatalf("run exited with %d", code)
+	}
+	got, stderr, code := runCLIOutput(t, "-file", path, "-v", "-gcpercent", "-1", "-memlimit", "64MiB")
+	if code != 0 || !bytes.Equal(got, want) {
+		t.Errorf("run with -gcpercent -1 -memlimit 64MiB exited with %d and printed\n%s\nwant\n%s", code, got, want)
+	}
+	for _, logged := range []string{`msg="set GC percentage" gcpercent=-1`, `msg="set memory limit" bytes=67108864`} {
+		if !bytes.Contains(stderr, []byte(logged)) {
+			t.Errorf("run did not log %s:\n%s", logged, stderr)
+		}
+	}
+
+	for _, limit := range []string{"lots", "-1MiB", "64XB"} {
+		if _, code := runCLI(t, "-file", path, "-memlimit", limit); code != 2 {
+			t.Errorf("-memlimit %s exited with %d, want 2", limit, code)
+		}
+	}
+}
+
+// Checks that -pprofAddr serves the live pprof endpoints while the run reads its input
+func TestPprofServer(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("SIGINT cannot be sent to a process on Windows")
+	}
+	fetched := false
+	_, stderr, _ := runCLIStream(t, func(cmd *exec.Cmd, line string) {
+		_, url, ok := strings.Cut(line, `msg="serving pprof" url=`)
+		if !ok {
+			return
+		}
+		defer cmd.Process.Signal(os.Interrupt)
+		resp, err := http.Get(url + "goroutine?debug=1")
+		if err != nil {
+			t.Errorf("fetching the goroutine profile: %v", err)
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil || resp.StatusCode != http.StatusOK || !bytes.Contains(body, []byte("goroutine profile:")) {
+			t.Errorf("goroutine profile returned %s (%v):\n%s", resp.Status, err, body)
+		}
+		fetched = true
+	}, "-pprofAddr", "127.0.0.1:0")
+	if !fetched {
+		t.Errorf("the run did not serve pprof:\n%s", stderr)
+	}
+
+	path := writeTestInput(t, "measurements.txt", testMeasurements(10))
+	if _, code := runCLI(t, "-file", path, "-pprofAddr", "127.0.0.1:-1"); code != 1 {
+		t.Errorf("-pprofAddr that cannot be listened on exited with %d, want 1", code)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Time between two redraws of the progress line
+const progressInterval = 250 * time.Millisecond
+
+// Struct to hold one -progress-json event, written as a line of its own
+type progressEvent struct {
+	Bytes      int64   `json:"bytes"`
+	TotalBytes int64   `json:"total_bytes"` // 0 when the size of the inputs is not known
+	Rows       int64   `json:"rows"`
+	Pct        float64 `json:"pct"`     // Percentage of the total bytes read, 100 once reading finished
+	Elapsed    float64 `json:"elapsed"` // Seconds since reading started
+	Rate       float64 `json:"rate"`    // Bytes read per second
+}
+
+// Function to draw a progress line with the bytes read, the throughput and, when the size of
+// the inputs is known, the percentage done and the time left, as long as stderr is a terminal,
+// and to write a -progress-json event at the same interval. It returns a function that clears the
+// line and writes the last event, at 100% when finished is set, once reading is done; it may be
+// called more than once
+func startProgress(paths []string) (func(finished bool), error) {
+	var events io.Writer
+	switch progressJSON {
+	case "":
+	case "stderr":
+		events = os.Stderr
+	default:
+		file, err := os.Create(progressJSON)
+		if err != nil {
+			return nil, err
+		}
+		events = file
+	}
+	// The progress line would mix with events written to stderr
+	draw := !quiet && isTerminal(os.Stderr) && progressJSON != "stderr"
+	if !draw && events == nil {
+		return func(bool) {}, nil
+	}
+	total := inputSize(paths)
+
+	done := make(chan bool, 1)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		start := time.Now()
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				read := atomic.LoadInt64(&bytesRead)
+				if draw {
+					fmt.Fprint(os.Stderr, "\r\033[K"+formatProgress(read, total, time.Since(start)))
+				}
+				if events != nil {
+					writeProgressEvent(events, newProgressEvent(read, total, atomic.LoadInt64(&rowsRead), time.Since(start)))
+				}
+			case finished := <-done:
+				if draw {
+					fmt.Fprint(os.Stderr, "\r\033[K")
+				}
+				if events != nil {
+					// The workers are done, so their tables can be counted directly
+					event := newProgressEvent(atomic.LoadInt64(&bytesRead), total, aggregatedRows(), time.Since(start))
+					if finished {
+						event.Pct = 100
+					}
+					writeProgressEvent(events, event)
+				}
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func(finished bool) {
+		once.Do(func() {
+			done <- finished
+			wg.Wait()
+			if closer, ok := events.(io.Closer); ok && events != os.Stderr {
+				if err := closer.Close(); err != nil {
+					slog.Warn("closing -progress-json failed", "err", err)
+				}
+			}
+		})
+	}, nil
+}
+
+// Function to compute a -progress-json event from the bytes and rows read after elapsed
+func newProgressEvent(read, total, rows int64, elapsed time.Duration) progressEvent {
+	event := progressEvent{Bytes: read, TotalBytes: total, Rows: rows, Elapsed: elapsed.Seconds()}
+	if elapsed > 0 {
+		event.Rate = float64(read) / elapsed.Seconds()
+	}
+	if total > 0 {
+		event.Pct = 100 * float64(min(read, total)) / float64(total)
+	}
+	return event
+}
+
+// Function to write a -progress-json event as one line of JSON
+func writeProgressEvent(w io.Writer, event progressEvent) {
+	line, _ := json.Marshal(event)
+	if _, err := w.Write(append(line, '\n')); err != nil {
+		slog.Debug("writing -progress-json failed", "err", err)
+	}
+}
+
+// Function to tell whether f is a terminal rather than a file or a pipe
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Function to sum the sizes of the inputs, 0 when any of them is stdin, remote or read through
+// a decoder, as the bytes counted are then not those of the file
+func inputSize(paths []string) int64 {
+	var total int64
+	for _, path := range paths {
+		if path == "-" || isRemote(path) {
+			return 0
+		}
+		compressed, utf16, err := sniffInputFile(path)
+		if err != nil || compressed || utf16 {
+			return 0
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return 0
+		}
+		total += info.Size()
+	}
+	return total
+}
+
+// Function to format the progress after elapsed: "1.2 GiB / 13.0 GiB (9.2%), 410.0 MiB/s, ETA 29s"
+func formatProgress(read, total int64, elapsed time.Duration) string {
+	rate := float64(read) / elapsed.Seconds()
+	if total <= 0 {
+		return fmt.Sprintf("%s, %s/s", formatBytes(float64(read)), formatBytes(rate))
+	}
+
+	line := fmt.Sprintf("%s / %s (%.1f%%), %s/s", formatBytes(float64(read)), formatBytes(float64(total)), 100*float64(min(read, total))/float64(total), formatBytes(rate))
+	if rate > 0 && read < total {
+		eta := time.Duration(float64(total-read) / rate * float64(time.Second))
+		line += ", ETA " + eta.Round(time.Second).String()
+	}
+	return line
+}
+
+// Function to format a number of bytes with a binary unit, e.g. 1.5 GiB
+func formatBytes(n float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	unit := 0
+	for n >= 1024 && unit < len(units)-1 {
+		n /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %s", n, units[unit])
+}
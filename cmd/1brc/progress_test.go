@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Function to read the -progress-json events of a run, failing the test on a line that is not an
+// event or a percentage going down
+func readProgressEvents(t *testing.T, data []byte) []progressEvent {
+	t.Helper()
+	var events []progressEvent
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var event progressEvent
+		decoder := json.NewDecoder(strings.NewReader(scanner.Text()))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&event); err != nil {
+			t.Fatalf("progress line %q is not an event: %v", scanner.Text(), err)
+		}
+		if n := len(events); n > 0 && (event.Pct < events[n-1].Pct || event.Bytes < events[n-1].Bytes || event.Rows < events[n-1].Rows) {
+			t.Errorf("event %+v goes back from %+v", event, events[n-1])
+		}
+		events = append(events, event)
+	}
+	if len(events) == 0 {
+		t.Fatal("no progress events written")
+	}
+	return events
+}
+
+// Checks that -progress-json ends with a 100% event counting every byte and row of a file
+func TestProgressJSON(t *testing.T) {
+	input := testMeasurements(5000)
+	path := writeTestInput(t, "measurements.txt", input)
+	events := filepath.Join(t.TempDir(), "progress.jsonl")
+	if _, code := runCLI(t, "-file", path, "-progress-json", events, "-workers", "3"); code != 0 {
+		t.Fatalf("run exited with %d", code)
+	}
+	data, err := os.ReadFile(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := readProgressEvents(t, data)
+	last := got[len(got)-1]
+	size := int64(len(input))
+	if last.Pct != 100 || last.Bytes != size || last.TotalBytes != size || last.Rows != 5000 {
+		t.Errorf("last event is %+v, want 100%% of %d bytes and 5000 rows", last, size)
+	}
+
+	// A file that cannot be created fails the run
+	if _, code := runCLI(t, "-file", path, "-progress-json", filepath.Join(t.TempDir(), "missing", "progress.jsonl")); code != 1 {
+		t.Errorf("unwritable -progress-json exited with %d, want 1", code)
+	}
+}
+
+// Checks that -progress-json stderr writes an event per interval while a slow stdin is read, the
+// rows and bytes growing before the last event at 100%
+func TestProgressJSONStream(t *testing.T) {
+	r, w := io.Pipe()
+	go func() {
+		for i := range 6 {
+			fmt.Fprintf(w, "Hamburg;%d.5\nBulawayo;-%d.0\n", i, i)
+			time.Sleep(progressInterval / 2)
+		}
+		w.Close()
+	}()
+	stdout, stderr, code := runCLIInput(t, r, "-progress-json", "stderr", "-q", "-batchSize", "1")
+	if code != 0 || !strings.Contains(string(stdout), "Hamburg") {
+		t.Fatalf("run exited with %d and printed\n%s", code, stdout)
+	}
+
+	got := readProgressEvents(t, stderr)
+	if len(got) < 3 {
+		t.Errorf("got %d events over %v, want one per %v", len(got), 3*progressInterval, progressInterval)
+	}
+	if last := got[len(got)-1]; last.Pct != 100 || last.Rows != 12 || last.TotalBytes != 0 {
+		t.Errorf("last event is %+v, want 100%% of 12 rows of unknown size", last)
+	}
+	for _, event := range got[:len(got)-1] {
+		if event.Pct != 0 {
+			t.Errorf("event %+v has a percentage of an input of unknown size", event)
+		}
+	}
+	if len(got) > 1 && got[len(got)-2].Rows == 0 {
+		t.Errorf("no rows counted before the stream ended: %+v", got)
+	}
+}
@@ -0,0 +1,601 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"unicode/utf8"
+
+	"example.com/mod/internal/codec"
+	"example.com/mod/internal/reader"
+	"example.com/mod/internal/stats"
+)
+
+// Number of full batches per consumer that may wait before the reader blocks
+const batchesPerConsumer = 2
+
+// Function to build the error for an input that ends before -skipLines lines were skipped
+func errShortHeader(lines int) error {
+	return fmt.Errorf("input has only %d lines, fewer than the %d to skip (see -skipLines)", lines, skipLines)
+}
+
+// Function to read a stream, transparently decompressing it when it starts with known magic bytes
+// and transcoding it when it starts with a UTF-16 byte order mark; input names it in errors.
+// -format csv records go to readCSV, the lines of the other formats through onebrc.Process
+func readStream(ctx context.Context, r io.Reader, input string) error {
+	decoded, err := codec.Open(r, input, workers)
+	if err != nil {
+		return err
+	}
+	defer decoded.Close()
+
+	if inputFormat == "csv" {
+		return readCSV(ctx, decoded)
+	}
+	return readProcessed(ctx, countingReader{decoded})
+}
+
+// Function to read a file as a stream, for compressed or transcoded files that cannot be split by offset
+func readFileStream(ctx context.Context, path string) error {
+	file, err := openInputFile(path)
+	if err != nil {
+		return fmt.Errorf("opening file: %w", err)
+	}
+	defer file.Close()
+
+	return readStream(ctx, file, path)
+}
+
+// Struct to hold a batch of -format csv records for the workers of readCSV
+type recordBatch struct {
+	records [][]string
+	offsets []int64 // Byte offset of each record in the input
+}
+
+// Function to read a stream as RFC 4180 records, whose quoted fields may span several lines.
+// The records are handed in batches to -workers goroutines, each folding them into a table of its own
+func readCSV(ctx context.Context, r io.Reader) error {
+	reader := csv.NewReader(r)
+	reader.Comma, _ = utf8.DecodeRuneInString(lineParser.Delimiter)
+	reader.FieldsPerRecord = -1
+
+	batches := make(chan *recordBatch, workers*batchesPerConsumer)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			table := workerTables.NewTable()
+			defer workerTables.Release(table)
+			rowsCounted := table.Rows
+			for b := range batches {
+				for i, record := range b.records {
+					if i%cancelCheckLines == 0 && ctx.Err() != nil {
+						break
+					}
+					processRecord(table, record, b.offsets[i])
+				}
+				countRowsRead(table, &rowsCounted)
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(batches)
+
+	b := new(recordBatch)
+	send := func() {
+		select {
+		case batches <- b:
+		case <-ctx.Done():
+		}
+		b = new(recordBatch)
+	}
+
+	// Only the records actually read count, malformed ones are reported and skipped apart
+	var counted int64
+	for records := 0; !stopRequested(ctx); {
+		offset := reader.InputOffset()
+		if records%batchSize == 0 {
+			countBytesRead(offset - counted)
+			counted = offset
+		}
+		record, err := reader.Read()
+		if err == io.EOF {
+			countBytesRead(reader.InputOffset() - counted)
+			if records < skipLines {
+				return errShortHeader(records)
+			}
+			if len(b.records) > 0 {
+				send()
+			}
+			return nil
+		}
+		if err != nil {
+			// Malformed records are reported and skipped like malformed lines
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				reportParseError(err, "", offset)
+				continue
+			}
+			return fmt.Errorf("reading file: %w", err)
+		}
+		records++
+
+		// Skip the first records (headers, comments)
+		if records <= skipLines {
+			continue
+		}
+		b.records = append(b.records, record)
+		b.offsets = append(b.offsets, offset)
+		if len(b.records) == batchSize {
+			send()
+		}
+	}
+	return nil
+}
+
+// Function to expand the glob patterns among the inputs into the matching files, in order
+func expandInputs(inputs []string) ([]string, error) {
+	var paths []string
+	stdin := false
+	for _, input := range inputs {
+		if input == "-" {
+			if stdin {
+				return nil, errors.New("stdin can only be read once")
+			}
+			stdin = true
+			paths = append(paths, input)
+			continue
+		}
+
+		// Remote objects are fetched as named
+		if isRemote(input) {
+			paths = append(paths, input)
+			continue
+		}
+
+		matches, err := filepath.Glob(input)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", input, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match %q", input)
+		}
+		paths = append(paths, matches...)
+	}
+	return paths, nil
+}
+
+// Function to read one input: remote objects over HTTP, stdin, compressed and UTF-16 files and
+// -aggregator runs as a stream, other files in parallel byte ranges either memory-mapped or
+// through positioned reads.
+// Cancelling ctx stops the readers and the workers within a few lines
+func readInput(ctx context.Context, path string) error {
+	streamed := false
+	if path != "-" && !isRemote(path) {
+		compressed, utf16, err := sniffInputFile(path)
+		if err != nil {
+			return err
+		}
+		streamed = compressed || utf16 || inputFormat == "csv" || aggregatorName != ""
+	}
+
+	switch {
+	case sequential:
+		return readSequential(ctx, path)
+	case inputFormat == "parquet" && (path == "-" || isRemote(path) || useMmap || activeCheckpoint != nil):
+		return errors.New("-format parquet only supports local files read without -mmap or -checkpoint")
+	case inputFormat == "parquet":
+		return readParquet(ctx, path)
+	case activeCheckpoint != nil && (path == "-" || isRemote(path) || streamed || useMmap):
+		return errors.New("-checkpoint only supports local uncompressed files read without -mmap")
+	case isRemote(path) && useMmap:
+		return errors.New("-mmap cannot be used with remote input")
+	case isRemote(path):
+		return readRemote(ctx, path)
+	case (path == "-" || streamed) && useMmap:
+		return errors.New("-mmap cannot be used with stdin, compressed or UTF-16 input")
+	case path == "-":
+		return readStream(ctx, os.Stdin, "stdin")
+	case streamed:
+		return readFileStream(ctx, path)
+	case useMmap:
+		return readMapped(ctx, path)
+	default:
+		return readChunked(ctx, path)
+	}
+}
+
+// Struct to describe a byte range of the input holding whole lines
+type chunk struct {
+	start, end int64
+}
+
+// Function to sniff the delimiter from the first data line when -delimiter auto is set
+func resolveDelimiter(line string) error {
+	if lineParser.Delimiter != "auto" || inputFormat == "jsonl" {
+		return nil
+	}
+	detected, err := lineParser.DetectDelimiter(line)
+	if err != nil {
+		return err
+	}
+	lineParser.Delimiter = detected
+	if explain {
+		slog.Info("detected delimiter", "delimiter", detected)
+	}
+	return nil
+}
+
+// Function to find where the measurements start, after the header lines
+func findDataStart(r io.ReaderAt, size int64) (int64, error) {
+	// Step over a UTF-8 byte order mark so it does not end up in the first name
+	offset, err := codec.UTF8BOMLength(r)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < skipLines; i++ {
+		if offset >= size {
+			return 0, errShortHeader(i)
+		}
+		next, err := reader.NextLineStart(r, offset, size)
+		if err != nil {
+			return 0, err
+		}
+		offset = next
+	}
+	return offset, nil
+}
+
+// Function to read the first data line so the delimiter can be detected before the workers start
+func detectFromFirstLine(r io.ReaderAt, start, size int64) error {
+	if lineParser.Delimiter != "auto" || inputFormat == "jsonl" || start >= size {
+		return nil
+	}
+	end, err := reader.NextLineStart(r, start, size)
+	if err != nil {
+		return err
+	}
+	line := make([]byte, end-start)
+	if _, err := r.ReadAt(line, start); err != nil && err != io.EOF {
+		return err
+	}
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	return resolveDelimiter(string(bytes.TrimSuffix(line, []byte("\r"))))
+}
+
+// Function to split [start, size) into up to n ranges that each begin at the start of a line
+func splitRanges(r io.ReaderAt, start, size int64, n int) ([]chunk, error) {
+	var chunks []chunk
+	chunkStart := start
+	for i := 1; i <= n && chunkStart < size; i++ {
+		chunkEnd := size
+		if i < n {
+			// Move the nominal boundary forward to the start of the next line
+			boundary := start + (size-start)*int64(i)/int64(n)
+			if boundary <= chunkStart {
+				continue
+			}
+			aligned, err := reader.NextLineStart(r, boundary-1, size)
+			if err != nil {
+				return nil, err
+			}
+			chunkEnd = aligned
+		}
+		chunks = append(chunks, chunk{start: chunkStart, end: chunkEnd})
+		chunkStart = chunkEnd
+	}
+	return chunks, nil
+}
+
+// Function to split the input into newline-aligned ranges and read each one in its own goroutine,
+// or to read it in blocks through the -io-workers pool
+func readChunked(ctx context.Context, path string) error {
+	file, err := openInputFile(path)
+	if err != nil {
+		return fmt.Errorf("opening file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("opening file: %w", err)
+	}
+
+	// Every range is read through a descriptor of its own while -max-open-files leaves room for
+	// one, the others share the descriptor of the file
+	openRange := func(c chunk) (io.ReadCloser, error) {
+		rangeFile, opened, err := tryOpenInputFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if !opened {
+			return sectionReadCloser{io.NewSectionReader(file, c.start, c.end-c.start), io.NopCloser(nil)}, nil
+		}
+		return sectionReadCloser{io.NewSectionReader(rangeFile, c.start, c.end-c.start), rangeFile}, nil
+	}
+	switch {
+	case activeCheckpoint != nil:
+		err = activeCheckpoint.readRanges(ctx, path, file, info, openRange)
+	case ioWorkers > 0:
+		err = readBlocks(ctx, file.File, info.Size())
+	default:
+		err = readRanges(ctx, file, info.Size(), openRange)
+	}
+	if err != nil {
+		return fmt.Errorf("reading file: %w", err)
+	}
+	return nil
+}
+
+// Struct to read a section of a file and close the file once done
+type sectionReadCloser struct {
+	*io.SectionReader
+	io.Closer
+}
+
+// Function to split a random-access input into newline-aligned ranges, opening and processing
+// each one in its own goroutine
+func readRanges(ctx context.Context, r io.ReaderAt, size int64, openRange func(c chunk) (io.ReadCloser, error)) error {
+	chunks, err := planRanges(r, size)
+	if err != nil {
+		return err
+	}
+	return runRanges(ctx, chunks, openRange, nil)
+}
+
+// Function to find the measurements in a random-access input and split them into newline-aligned ranges
+func planRanges(r io.ReaderAt, size int64) ([]chunk, error) {
+	start, err := findDataStart(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if err := detectFromFirstLine(r, start, size); err != nil {
+		return nil, err
+	}
+	return splitRanges(r, start, size, workers)
+}
+
+// Function to process each range in its own goroutine, recording in offsets (when given)
+// the offset up to which the lines of each range have been folded into the stats
+func runRanges(ctx context.Context, chunks []chunk, openRange func(c chunk) (io.ReadCloser, error), offsets []*int64) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(chunks))
+	for i, c := range chunks {
+		var offset *int64
+		if offsets != nil {
+			offset = offsets[i]
+		}
+		wg.Add(1)
+		go func(i int, c chunk) {
+			defer wg.Done()
+			errs[i] = readRange(ctx, openRange, c, offset)
+		}(i, c)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function to read and process the lines of one range, keeping offset (when given) at the end
+// of the last processed line; a cancelled ctx stops it in the middle of a batch
+func readRange(ctx context.Context, openRange func(c chunk) (io.ReadCloser, error), c chunk, offset *int64) error {
+	rc, err := openRange(c)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	scanner, consumed := reader.NewLineReader(rc, c.start, readBuffer, maxLineLength)
+	table := workerTables.NewTable()
+	defer workerTables.Release(table)
+
+	// Fold lines in batches while holding the checkpoint lock shared, so a checkpoint never
+	// sees a line in the stats without its offset or the other way around
+	counted, rowsCounted := c.start, table.Rows
+	for more := true; more && !stopRequested(ctx); {
+		activeCheckpoint.readLock()
+		for i := 0; i < batchSize; i++ {
+			if i%cancelCheckLines == 0 && ctx.Err() != nil {
+				break
+			}
+			lineStart := *consumed
+			if more = scanner.Scan(); !more {
+				break
+			}
+			processLine(table, scanner.Bytes(), lineStart)
+		}
+		if offset != nil {
+			*offset = *consumed
+		}
+		countBytesRead(*consumed - counted)
+		counted = *consumed
+		countRowsRead(table, &rowsCounted)
+		activeCheckpoint.readUnlock()
+	}
+	return scanner.Err()
+}
+
+// Function to read the file as one memory-mapped byte slice, slicing lines directly out of it
+func readMapped(ctx context.Context, path string) error {
+	data, unmap, err := mmapFile(path)
+	if err != nil {
+		return fmt.Errorf("mapping file: %w", err)
+	}
+	defer unmap()
+
+	r := bytes.NewReader(data)
+	size := int64(len(data))
+	start, err := findDataStart(r, size)
+	if err != nil {
+		return err
+	}
+	if err := detectFromFirstLine(r, start, size); err != nil {
+		return err
+	}
+	chunks, err := splitRanges(r, start, size, workers)
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for _, c := range chunks {
+		wg.Add(1)
+		go func(c chunk) {
+			defer wg.Done()
+			table := workerTables.NewTable()
+			defer workerTables.Release(table)
+			processBlock(ctx, table, data[c.start:c.end], c.start, 0)
+		}(c)
+	}
+	wg.Wait()
+	return nil
+}
+
+// Function to process the lines of an in-memory range starting at offset base of the file,
+// failing on a line longer than limit unless it is 0
+func processBlock(ctx context.Context, table *stats.Table, data []byte, base int64, limit int) error {
+	size := int64(len(data))
+	offset, counted, rowsCounted := base, base, table.Rows
+	defer func() {
+		// The last line may have lacked the newline counted after it
+		countBytesRead(min(offset, base+size) - counted)
+		countRowsRead(table, &rowsCounted)
+	}()
+	for lines := 1; len(data) > 0 && !stopRequested(ctx); lines++ {
+		// Cut the next line, the last one may lack a trailing newline
+		end := bytes.IndexByte(data, '\n')
+		var line []byte
+		if end < 0 {
+			line, data = data, nil
+		} else {
+			line, data = data[:end], data[end+1:]
+		}
+		if limit > 0 && len(line) > limit {
+			return reader.ErrLineTooLong
+		}
+		processLine(table, reader.DropCR(line), offset)
+		offset += int64(len(line)) + 1
+		if lines%batchSize == 0 {
+			countBytesRead(offset - counted)
+			counted = offset
+			countRowsRead(table, &rowsCounted)
+		}
+	}
+	return nil
+}
+
+// Struct to hold a newline-aligned block of the input read by an -io-workers goroutine
+type block struct {
+	data  *[]byte // Buffer of the block, handed back to the pool once its lines are folded
+	start int64   // Offset of the first byte in the input
+}
+
+// Function to read the measurements of a file in newline-aligned blocks of about -readBuffer
+// bytes: -io-workers goroutines issue the positioned reads and hand the blocks over a shared
+// channel to -workers goroutines, each folding their lines into a table of its own
+func readBlocks(ctx context.Context, file *os.File, size int64) error {
+	start, err := findDataStart(file, size)
+	if err != nil {
+		return err
+	}
+	if err := detectFromFirstLine(file, start, size); err != nil {
+		return err
+	}
+
+	// The first failure stops the planner and both pools
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var failure error
+	var failureMutex sync.Mutex
+	fail := func(err error) {
+		failureMutex.Lock()
+		if failure == nil {
+			failure = err
+		}
+		failureMutex.Unlock()
+		cancel()
+	}
+
+	// Plan the blocks ahead of the reads, each ending at the start of a line
+	chunks := make(chan chunk, ioWorkers)
+	go func() {
+		defer close(chunks)
+		for blockStart := start; blockStart < size && !stopRequested(ctx); {
+			blockEnd := size
+			if boundary := blockStart + int64(readBuffer); boundary < size {
+				aligned, err := reader.NextLineStart(file, boundary-1, size)
+				if err != nil {
+					fail(err)
+					return
+				}
+				blockEnd = aligned
+			}
+			select {
+			case chunks <- chunk{start: blockStart, end: blockEnd}:
+			case <-ctx.Done():
+				return
+			}
+			blockStart = blockEnd
+		}
+	}()
+
+	// Block buffers are recycled once folded, so at most a few per worker are alive
+	buffers := sync.Pool{New: func() any {
+		buf := make([]byte, 0, readBuffer)
+		return &buf
+	}}
+	blocks := make(chan block, workers*batchesPerConsumer)
+	var readers sync.WaitGroup
+	for range ioWorkers {
+		readers.Go(func() {
+			for c := range chunks {
+				buf := buffers.Get().(*[]byte)
+				*buf = slices.Grow((*buf)[:0], int(c.end-c.start))[:c.end-c.start]
+				if _, err := file.ReadAt(*buf, c.start); err != nil && err != io.EOF {
+					fail(err)
+					return
+				}
+				select {
+				case blocks <- block{data: buf, start: c.start}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		})
+	}
+	go func() {
+		readers.Wait()
+		close(blocks)
+	}()
+
+	// Blocks are still drained once the run is stopped so no reader blocks on the channel
+	var folders sync.WaitGroup
+	for range workers {
+		folders.Go(func() {
+			table := workerTables.NewTable()
+			defer workerTables.Release(table)
+			for b := range blocks {
+				if err := processBlock(ctx, table, *b.data, b.start, maxLineLength); err != nil {
+					fail(err)
+				}
+				buffers.Put(b.data)
+			}
+		})
+	}
+	folders.Wait()
+	return failure
+}
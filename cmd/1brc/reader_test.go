@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"example.com/mod/internal/stats"
+)
+
+func TestReadCSVSkipLines(t *testing.T) {
+	// The second line is a malformed record, it must not count as one of the two skipped
+	input := "station;reading\n\"bad\"quote;1.0\n# celsius;0.0\nHamburg;12.0\nHamburg;-3.4\n"
+	path := filepath.Join(t.TempDir(), "measurements.csv")
+	if err := os.WriteFile(path, []byte(input), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, code := runCLI(t, "-file", path, "-format", "csv", "-skipLines", "2", "-workers", "3", "-batchSize", "1")
+	if want := "Letter: h, Name: Hamburg, Min: -3.40, Max: 12.00, Avg: 4.30\n"; code != 0 || string(got) != want {
+		t.Errorf("exited with %d and printed %q, want %q", code, got, want)
+	}
+}
+
+// Function to build count lines of readings of a few stations, some of them with CRLF endings
+func testMeasurements(count int) string {
+	var b strings.Builder
+	for i := range count {
+		fmt.Fprintf(&b, "%s;%d.%d", []string{"Hamburg", "Bulawayo", "St. John's", "東京"}[i%4], i%97-48, i%10)
+		b.WriteString([]string{"\n", "\r\n"}[i%3/2])
+	}
+	return b.String()
+}
+
+// Checks that the -io-workers pool prints what the byte ranges print, whatever the number of
+// readers and of workers and with blocks much smaller than the lines, and that it enforces
+// -maxLineLength like the line readers
+func TestIOWorkers(t *testing.T) {
+	path := writeTestInput(t, "measurements.txt", "station;temp\n"+testMeasurements(5000))
+	args := []string{"-file", path, "-skipLines", "1", "-output", "csv", "-stddev"}
+	want, code := runCLI(t, args...)
+	if code != 0 {
+		t.Fatalf("byte ranges exited with %d", code)
+	}
+	for _, knobs := range [][]string{
+		{"-io-workers", "1", "-workers", "1"},
+		{"-io-workers", "3", "-workers", "2", "-readBuffer", "100"},
+		{"-io-workers", "2", "-workers", "5", "-readBuffer", "1"},
+	} {
+		got, code := runCLI(t, append(args, knobs...)...)
+		if code != 0 || string(got) != string(want) {
+			t.Errorf("%v exited with %d and printed\n%s\nwant\n%s", knobs, code, got, want)
+		}
+	}
+
+	if _, stderr, code := runCLIOutput(t, "-file", path, "-io-workers", "2", "-maxLineLength", "8"); code != 1 || !strings.Contains(string(stderr), "line longer than -maxLineLength") {
+		t.Errorf("-maxLineLength 8 exited with %d, want 1 on a long line:\n%s", code, stderr)
+	}
+	for _, invalid := range [][]string{{"-io-workers", "-1"}, {"-io-workers", "2", "-mmap"}} {
+		if _, code := runCLI(t, append([]string{"-file", path}, invalid...)...); code != 2 {
+			t.Errorf("%v exited with %d, want 2", invalid, code)
+		}
+	}
+}
+
+// Measures reading a local file through the -io-workers pool against one byte range per worker,
+// varying the readers and the workers: go test -run '^$' -bench IOWorkers ./cmd/1brc
+func BenchmarkIOWorkers(b *testing.B) {
+	input := testMeasurements(1 << 20)
+	path := writeTestInput(b, "measurements.txt", input)
+	savedTables, savedIO, savedWorkers := workerTables, ioWorkers, workers
+	savedBatch, savedBuffer, savedLine := batchSize, readBuffer, maxLineLength
+	b.Cleanup(func() {
+		workerTables, ioWorkers, workers = savedTables, savedIO, savedWorkers
+		batchSize, readBuffer, maxLineLength = savedBatch, savedBuffer, savedLine
+	})
+	batchSize, readBuffer, maxLineLength = 1000, 1<<20, 1<<20
+
+	for _, readers := range []int{0, 1, 2, 4} {
+		for _, folders := range []int{1, 2, 4, 8} {
+			b.Run(fmt.Sprintf("io-workers=%d/workers=%d", readers, folders), func(b *testing.B) {
+				ioWorkers, workers = readers, folders
+				b.SetBytes(int64(len(input)))
+				for b.Loop() {
+					workerTables = stats.NewSet(stats.Options{Min: true, Max: true, Sum: true})
+					if err := readChunked(context.Background(), path); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
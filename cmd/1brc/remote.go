@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"example.com/mod/internal/codec"
+)
+
+// Function to tell whether an input names a remote object rather than a local file
+func isRemote(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") || strings.HasPrefix(path, "s3://")
+}
+
+// Struct to describe a remote object read through HTTP GET requests, optionally signed for S3
+type remoteObject struct {
+	ctx    context.Context // Context of the run, cancelling it aborts the requests in flight
+	client *http.Client
+	url    *url.URL
+	size   int64
+	s3     *s3Credentials
+}
+
+// Struct to hold the credentials used to sign S3 requests
+type s3Credentials struct {
+	region, accessKey, secretKey, sessionToken string
+}
+
+// Function to resolve an input URL, turning s3://bucket/key into the HTTPS URL of the object
+func resolveRemote(path string) (*url.URL, *s3Credentials, error) {
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid URL %q: %w", path, err)
+	}
+	if u.Scheme != "s3" {
+		return u, nil, nil
+	}
+
+	bucket, key := u.Host, strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || key == "" {
+		return nil, nil, fmt.Errorf("invalid S3 URL %q, expected s3://bucket/key", path)
+	}
+
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	// Use path-style addressing against a custom endpoint, virtual-hosted style against AWS
+	var resolved *url.URL
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+		resolved, err = url.Parse(strings.TrimSuffix(endpoint, "/") + "/" + bucket)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid AWS_ENDPOINT_URL: %w", err)
+		}
+	} else {
+		resolved = &url.URL{Scheme: "https", Host: bucket + ".s3." + region + ".amazonaws.com"}
+	}
+	resolved.Path += "/" + key
+	resolved.RawPath = awsURIEncode(resolved.Path)
+
+	// Requests stay unsigned without credentials, which works for public buckets
+	var credentials *s3Credentials
+	if accessKey := os.Getenv("AWS_ACCESS_KEY_ID"); accessKey != "" {
+		credentials = &s3Credentials{
+			region:       region,
+			accessKey:    accessKey,
+			secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		}
+	}
+	return resolved, credentials, nil
+}
+
+// Function to read a remote object, in parallel ranged downloads when the server supports them
+func readRemote(ctx context.Context, path string) error {
+	u, credentials, err := resolveRemote(path)
+	if err != nil {
+		return err
+	}
+	object := &remoteObject{ctx: ctx, client: http.DefaultClient, url: u, s3: credentials}
+
+	resp, err := object.do(http.MethodHead, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	object.size = resp.ContentLength
+
+	// Compressed or UTF-16 objects, servers without range support and -aggregator runs can only be streamed
+	streamed := object.size < 0 || resp.Header.Get("Accept-Ranges") != "bytes" || inputFormat == "csv"
+	if codec.ByExtension(u.Path) != nil || aggregatorName != "" {
+		streamed = true
+	}
+	if !streamed {
+		prefix := make([]byte, codec.MaxMagicLength)
+		n, err := object.ReadAt(prefix, 0)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		streamed = codec.NeedsDecoding(prefix[:n])
+	}
+	if streamed {
+		resp, err := object.do(http.MethodGet, "")
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		return readStream(ctx, resp.Body, path)
+	}
+
+	if err := readRanges(ctx, object, object.size, object.openRange); err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	return nil
+}
+
+// Function to read len(p) bytes at offset off with a ranged GET request
+func (o *remoteObject) ReadAt(p []byte, off int64) (int, error) {
+	if off >= o.size {
+		return 0, io.EOF
+	}
+	end := off + int64(len(p))
+	if end > o.size {
+		end = o.size
+	}
+
+	rc, err := o.openRange(chunk{start: off, end: end})
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+
+	n, err := io.ReadFull(rc, p[:end-off])
+	if err == nil && end == o.size {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Function to stream one byte range of the object
+func (o *remoteObject) openRange(c chunk) (io.ReadCloser, error) {
+	resp, err := o.do(http.MethodGet, fmt.Sprintf("bytes=%d-%d", c.start, c.end-1))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("fetching %s: server ignored the range request", o.url.Redacted())
+	}
+	return &rangeBody{ReadCloser: resp.Body, url: o.url.Redacted(), c: c, left: c.end - c.start}, nil
+}
+
+// Struct to read the body of a ranged response, failing when it ends before the whole range so a
+// short answer never silently drops lines
+type rangeBody struct {
+	io.ReadCloser
+	url  string
+	c    chunk
+	left int64 // Bytes of the range not read yet
+}
+
+// Function to read the body, turning an early end into an error naming the range
+func (b *rangeBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.left -= int64(n)
+	if err == io.EOF && b.left > 0 {
+		err = fmt.Errorf("fetching %s: range %d-%d ended %d bytes early: %w", b.url, b.c.start, b.c.end-1, b.left, io.ErrUnexpectedEOF)
+	}
+	return n, err
+}
+
+// Function to send a request for the object, signing it for S3 when credentials are set
+func (o *remoteObject) do(method, byteRange string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(o.ctx, method, o.url.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if byteRange != "" {
+		req.Header.Set("Range", byteRange)
+	}
+	if o.s3 != nil {
+		o.s3.sign(req, time.Now().UTC())
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("fetching %s: %s", o.url.Redacted(), resp.Status)
+	}
+	return resp, nil
+}
+
+// Function to sign a request with AWS Signature Version 4, leaving the payload unsigned unless
+// X-Amz-Content-Sha256 already holds its hash
+func (c *s3Credentials) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if req.Header.Get("X-Amz-Content-Sha256") == "" {
+		req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	}
+	if c.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.sessionToken)
+	}
+
+	// Sign the host, the range and every x-amz-* header, in sorted order
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		if lower := strings.ToLower(name); lower == "range" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	scope := date + "/" + c.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), date)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+c.accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// Function to compute an HMAC-SHA256 of data under key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Function to URI-encode a path the way AWS canonicalizes it, keeping only unreserved characters and '/'
+func awsURIEncode(path string) string {
+	var encoded strings.Builder
+	for i := 0; i < len(path); i++ {
+		b := path[i]
+		if ('A' <= b && b <= 'Z') || ('a' <= b && b <= 'z') || ('0' <= b && b <= '9') ||
+			b == '-' || b == '_' || b == '.' || b == '~' || b == '/' {
+			encoded.WriteByte(b)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+	return encoded.String()
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+// Function to pick the default of -max-open-files where the descriptor limit cannot be read
+func defaultMaxOpenFiles() int {
+	return fallbackMaxOpenFiles
+}
@@ -0,0 +1,15 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// Function to pick the default of -max-open-files: half the soft descriptor limit of the
+// process, leaving the rest to the output, the reject file and the servers
+func defaultMaxOpenFiles() int {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil || limit.Cur == 0 || limit.Cur > 1<<20 {
+		return fallbackMaxOpenFiles
+	}
+	return max(int(limit.Cur/2), 1)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"slices"
+
+	"example.com/mod/internal/parse"
+	"example.com/mod/internal/reader"
+	"example.com/mod/internal/stats"
+)
+
+// Function to check that every input can be read a second time by -self-verify
+func checkSelfVerifyInputs(paths []string) error {
+	for _, path := range paths {
+		if path == "-" || isRemote(path) {
+			return errors.New("-self-verify only supports local files")
+		}
+		compressed, utf16, err := sniffInputFile(path)
+		if err != nil {
+			return err
+		}
+		if compressed || utf16 {
+			return errors.New("-self-verify only supports uncompressed UTF-8 files")
+		}
+	}
+	return nil
+}
+
+// Function to aggregate the inputs again for -self-verify, each in one pass into a single table
+// that no merge touches, and to compare it with the merged results of the run. The first
+// station whose stats differ is logged; percentile digests depend on the merge order and are
+// not compared
+func runSelfVerify(ctx context.Context, paths []string) (bool, error) {
+	// A parser of its own leaves the alias and range counters of the run alone
+	verifier := newLineParser(lineParser.Aliases)
+	verifier.Delimiter = lineParser.Delimiter
+	whole := stats.NewTable(tableOptions())
+	for _, path := range paths {
+		if err := foldWholeFile(ctx, path, func(line []byte) {
+			name, tenths, weight, err := verifier.ParseLine(parse.ByteString(line))
+			if err == nil && verifier.Range.Accept(tenths, weight) {
+				whole.Update(name, tenths, weight)
+			}
+		}); err != nil {
+			return false, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	merged := make(map[string]stats.NameStats)
+	for _, shard := range statsShards {
+		maps.Insert(merged, shard.All())
+	}
+	expected := maps.Collect(whole.All())
+	union := maps.Clone(merged)
+	maps.Insert(union, maps.All(expected))
+	names := slices.Sorted(maps.Keys(union))
+
+	for _, name := range names {
+		got, inMerged := merged[name]
+		want, inWhole := expected[name]
+		if !inMerged || !inWhole || !sameStats(got, want) {
+			slog.Error("self-verify found a diverging station", "station", name,
+				"merged", describeStats(got, inMerged), "wholeFile", describeStats(want, inWhole))
+			return false, nil
+		}
+	}
+	if rows := workerTables.Rows(); rows != whole.Rows {
+		slog.Error("self-verify found diverging row counts", "merged", rows, "wholeFile", whole.Rows)
+		return false, nil
+	}
+	slog.Info("self-verify matched the whole-file pass", "stations", len(names), "rows", whole.Rows)
+	return true, nil
+}
+
+// Function to read the data lines of a file with a single line reader, handing each to fold
+func foldWholeFile(ctx context.Context, path string, fold func(line []byte)) error {
+	file, err := openInputFile(path)
+	if err != nil {
+		return fmt.Errorf("opening file: %w", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("opening file: %w", err)
+	}
+	start, err := findDataStart(file, info.Size())
+	if err != nil {
+		return err
+	}
+
+	lines, _ := reader.NewLineReader(io.NewSectionReader(file, start, info.Size()-start), start, readBuffer, maxLineLength)
+	for i := 0; lines.Scan(); i++ {
+		if i%cancelCheckLines == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		fold(lines.Bytes())
+	}
+	return lines.Err()
+}
+
+// Function to tell whether two stats agree on everything but their percentile digest
+func sameStats(a, b stats.NameStats) bool {
+	return a.Min == b.Min && a.Max == b.Max && a.Sum == b.Sum && a.SumHigh == b.SumHigh && a.SumSquares == b.SumSquares &&
+		a.SumSquaresHigh == b.SumSquaresHigh && a.Count == b.Count && slices.Equal(a.Histogram, b.Histogram)
+}
+
+// Function to describe the stats of a station in a -self-verify report
+func describeStats(s stats.NameStats, found bool) string {
+	if !found {
+		return "missing"
+	}
+	return fmt.Sprintf("min=%d max=%d sum=%v sumSquares=%v count=%d histogram=%v (tenths)", s.Min, s.Max, s.BigSum(),
+		s.BigSumSquares(), s.Count, s.Histogram)
+}
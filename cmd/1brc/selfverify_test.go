@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"example.com/mod/internal/stats"
+)
+
+// Checks that -self-verify passes on the parallel and merged paths, and rejects inputs it cannot
+// read a second time
+func TestSelfVerify(t *testing.T) {
+	path := writeTestInput(t, "measurements.txt", "station;temp\n"+testMeasurements(3000)+"no delimiter\n")
+	for _, knobs := range [][]string{
+		{"-workers", "4", "-batchSize", "3", "-readBuffer", "64"},
+		{"-mmap", "-workers", "3", "-stddev", "-percentiles", "50,99"},
+		{"-io-workers", "2", "-workers", "3", "-readBuffer", "100", "-histogram", "buckets=-10,0,10"},
+	} {
+		args := append([]string{"-file", path, "-skipLines", "1", "-self-verify"}, knobs...)
+		if _, stderr, code := runCLIOutput(t, args...); code != 0 || !bytes.Contains(stderr, []byte("self-verify matched")) {
+			t.Errorf("%v exited with %d:\n%s", knobs, code, stderr)
+		}
+	}
+
+	compressed := writeGzippedInput(t, "measurements.txt.gz", "Hamburg;12.0\n")
+	for _, args := range [][]string{{"-file", compressed}, {"-file", path, "-sequential"}, {"-file", path, "-format", "csv"}} {
+		if _, code := runCLI(t, append(args, "-self-verify")...); code != 2 {
+			t.Errorf("%v with -self-verify exited with %d, want 2", args, code)
+		}
+	}
+}
+
+// Checks that merged results differing from the whole-file pass report the first diverging station
+func TestSelfVerifyDivergence(t *testing.T) {
+	path := writeTestInput(t, "measurements.txt", "Hamburg;12.0\nBulawayo;8.9\nHamburg;-3.0\n")
+	savedParser, savedTables, savedShards := lineParser, workerTables, statsShards
+	savedColumns, savedValue, savedWeight := groupColumns, valueCol, weightCol
+	savedBuffer, savedLine, savedLogger := readBuffer, maxLineLength, slog.Default()
+	t.Cleanup(func() {
+		lineParser, workerTables, statsShards = savedParser, savedTables, savedShards
+		groupColumns, valueCol, weightCol = savedColumns, savedValue, savedWeight
+		readBuffer, maxLineLength = savedBuffer, savedLine
+		slog.SetDefault(savedLogger)
+	})
+	groupColumns, valueCol, weightCol = []int{0}, 1, -1
+	readBuffer, maxLineLength = 4096, 4096
+	var logged bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logged, nil)))
+
+	for _, test := range []struct {
+		name    string
+		tamper  func(table *stats.Table)
+		station string
+	}{
+		{name: "matching", tamper: func(*stats.Table) {}},
+		{name: "count", tamper: func(table *stats.Table) { table.Update("Hamburg", 0, 1) }, station: "Hamburg"},
+		{name: "extra station", tamper: func(table *stats.Table) { table.Update("Abha", 0, 1) }, station: "Abha"},
+	} {
+		logged.Reset()
+		lineParser = newLineParser(nil)
+		lineParser.Delimiter = ";"
+		workerTables = stats.NewSet(tableOptions())
+		table := workerTables.NewTable()
+		for _, line := range []string{"Hamburg;12.0", "Bulawayo;8.9", "Hamburg;-3.0"} {
+			processLine(table, []byte(line), 0)
+		}
+		test.tamper(table)
+		statsShards = workerTables.Merge()
+
+		verified, err := runSelfVerify(context.Background(), []string{path})
+		if err != nil || verified != (test.station == "") {
+			t.Errorf("%s: runSelfVerify = %v, %v\n%s", test.name, verified, err, logged.String())
+		}
+		if test.station != "" && !strings.Contains(logged.String(), "station="+test.station+" ") {
+			t.Errorf("%s: report does not name %s:\n%s", test.name, test.station, logged.String())
+		}
+	}
+}
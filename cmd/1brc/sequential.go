@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"example.com/mod/internal/codec"
+	"example.com/mod/internal/parse"
+	"example.com/mod/internal/stats"
+)
+
+// Function to read one input for -sequential: a single goroutine reads it line by line, splits
+// and parses every line with the standard library and keeps every reading of a name, the stats
+// only being computed at the end. It is slow and allocates for every row, but simple enough to
+// check the parallel readers, the fast-path parser and the merge against
+func readSequential(ctx context.Context, path string) error {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		if isRemote(path) {
+			return errors.New("-sequential only supports local uncompressed files and stdin")
+		}
+		compressed, _, err := sniffInputFile(path)
+		if err != nil {
+			return err
+		}
+		if compressed {
+			return errors.New("-sequential only supports local uncompressed files and stdin")
+		}
+		file, err := openInputFile(path)
+		if err != nil {
+			return fmt.Errorf("opening file: %w", err)
+		}
+		defer file.Close()
+		r = file
+	}
+	reader := bufio.NewReader(codec.DecodeText(bufio.NewReader(r)))
+
+	readings := make(map[string][]int16)
+	var offset int64
+	for lines := 0; !stopRequested(ctx); lines++ {
+		raw, err := reader.ReadString('\n')
+		if raw == "" && err == io.EOF {
+			if lines < skipLines {
+				return errShortHeader(lines)
+			}
+			break
+		}
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("reading file: %w", err)
+		}
+		start := offset
+		offset += int64(len(raw))
+		countBytesRead(int64(len(raw)))
+		if lines < skipLines {
+			continue
+		}
+
+		line := strings.TrimSuffix(strings.TrimSuffix(raw, "\n"), "\r")
+		if lines == skipLines {
+			if err := resolveDelimiter(line); err != nil {
+				return err
+			}
+		}
+		name, tenths, err := parseSequential(line)
+		if err != nil {
+			reportParseError(err, line, start)
+			continue
+		}
+		if lineParser.Range.Accept(tenths, 1) {
+			readings[name] = append(readings[name], tenths)
+		}
+	}
+
+	// Only now fold the readings of every name into its stats
+	table := workerTables.NewTable()
+	defer workerTables.Release(table)
+	rowsCounted := table.Rows
+	defer countRowsRead(table, &rowsCounted)
+	for name, values := range readings {
+		s := stats.NameStats{Min: math.MaxInt16, Max: math.MinInt16, Count: len(values)}
+		for _, tenths := range values {
+			s.Min = min(s.Min, tenths)
+			s.Max = max(s.Max, tenths)
+			s.Sum += int64(tenths)
+			s.SumSquares += int64(tenths) * int64(tenths)
+		}
+		table.Merge(name, s)
+		table.Rows += int64(len(values))
+	}
+	return nil
+}
+
+// Function to parse a "name;number" line for -sequential with strings.Split and strconv.ParseFloat,
+// without any of the fast paths of parse.Parser
+func parseSequential(line string) (string, int16, error) {
+	parts := strings.Split(line, lineParser.Delimiter)
+	if len(parts) != 2 {
+		return "", 0, fmt.Errorf("invalid format: %s", line)
+	}
+	name := strings.TrimSpace(parts[0])
+	if name == "" {
+		return "", 0, fmt.Errorf("empty name: %s", line)
+	}
+	number, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid number: %s", strings.TrimSpace(parts[1]))
+	}
+	tenths := math.Round(parse.ToCelsius(number, lineParser.Unit) * 10)
+	if math.IsNaN(tenths) || tenths < math.MinInt16 || tenths > math.MaxInt16 {
+		return "", 0, fmt.Errorf("number out of range: %s", strings.TrimSpace(parts[1]))
+	}
+	return lineParser.Canonical(name), int16(tenths), nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"example.com/mod/internal/stats"
+)
+
+// Results sorted by name that the -serve endpoints answer from, nil while the inputs are still
+// being aggregated
+var servedResults atomic.Pointer[[]result]
+
+// Function to start serving the JSON endpoints of -serve on addr: /stations, /stations/{name}
+// and /top?by=max&n=10. They answer 503 until publishResults is called once the results are printed
+func startQueryServer(addr string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	server := &http.Server{Handler: queryHandler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("query server stopped", "err", err)
+		}
+	}()
+	slog.Info("serving results", "url", "http://"+listener.Addr().String()+"/stations")
+	return server, nil
+}
+
+// Function to route the endpoints of -serve
+func queryHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /stations", func(w http.ResponseWriter, r *http.Request) {
+		if results, ok := loadResults(w); ok {
+			writeStations(w, results)
+		}
+	})
+	mux.HandleFunc("GET /stations/{name}", serveStation)
+	mux.HandleFunc("GET /top", serveTop)
+	return mux
+}
+
+// Function to make the merged stats the answer of the -serve endpoints
+func publishResults() {
+	publishTables(statsShards[:])
+}
+
+// Function to make the stats of tables, which share no names, the answer of the -serve endpoints.
+// The tables must not change afterwards, the endpoints read them without locking
+func publishTables(tables []*stats.Table) {
+	results := tableResults(tables)
+	servedResults.Store(&results)
+}
+
+// Function to collect the stats of tables, which share no names, as results sorted by name
+func tableResults(tables []*stats.Table) []result {
+	var results []result
+	for _, shard := range tables {
+		for name, stats := range shard.All() {
+			results = append(results, result{name: name, stats: stats})
+		}
+	}
+	slices.SortFunc(results, func(a, b result) int {
+		return strings.Compare(a.name, b.name)
+	})
+	return results
+}
+
+// Function to find the result of a name among results sorted by name
+func findResult(results []result, name string) (result, bool) {
+	i, found := slices.BinarySearchFunc(results, name, func(r result, name string) int {
+		return strings.Compare(r.name, name)
+	})
+	if !found {
+		return result{}, false
+	}
+	return results[i], true
+}
+
+// Function to keep serving the results until SIGINT or SIGTERM, then shut the servers down,
+// letting the requests in flight finish
+func serveUntilSignalled(servers []*http.Server) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	<-ctx.Done()
+	stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Error("stopping server failed", "addr", server.Addr, "err", err)
+		}
+	}
+}
+
+// Function to answer /stations/{name} with the stats of one station
+func serveStation(w http.ResponseWriter, r *http.Request) {
+	results, ok := loadResults(w)
+	if !ok {
+		return
+	}
+	name := r.PathValue("name")
+	station, found := findResult(results, name)
+	if !found {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no station %q", name))
+		return
+	}
+	object, err := stationJSON(station)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintln(w, object)
+}
+
+// Function to answer /top with the n (10 by default) most extreme stations ranked by the by
+// stat (avg by default), as -top and -by would print them
+func serveTop(w http.ResponseWriter, r *http.Request) {
+	results, ok := loadResults(w)
+	if !ok {
+		return
+	}
+
+	by := cmp.Or(r.URL.Query().Get("by"), "avg")
+	if by == "mean" {
+		by = "avg"
+	}
+	if by != "avg" && by != "max" && by != "min" && by != "count" {
+		writeError(w, http.StatusBadRequest, "by must be avg, max, min or count")
+		return
+	}
+	n := 10
+	if value := r.URL.Query().Get("n"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			writeError(w, http.StatusBadRequest, "n must be a positive integer")
+			return
+		}
+		n = parsed
+	}
+
+	ranked := slices.Clone(results)
+	sortResults(ranked, by)
+	writeStations(w, ranked[:min(n, len(ranked))])
+}
+
+// Function to take the published results, answering 503 while there are none yet
+func loadResults(w http.ResponseWriter) ([]result, bool) {
+	results := servedResults.Load()
+	if results == nil {
+		writeError(w, http.StatusServiceUnavailable, "results are still being aggregated")
+		return nil, false
+	}
+	return *results, true
+}
+
+// Function to write results as a JSON array of the objects -output json prints
+func writeStations(w http.ResponseWriter, results []result) {
+	var body strings.Builder
+	body.WriteString("[")
+	for i, r := range results {
+		object, err := stationJSON(r)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if i > 0 {
+			body.WriteString(",")
+		}
+		body.WriteString("\n  " + object)
+	}
+	body.WriteString("\n]\n")
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, body.String())
+}
+
+// Function to answer a request with a JSON error object and the status code
+func writeError(w http.ResponseWriter, status int, message string) {
+	quoted, _ := json.Marshal(message)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "{\"error\": %s}\n", quoted)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"example.com/mod/internal/stats"
+)
+
+// Checks the -serve endpoints against a few published results
+func TestQueryHandler(t *testing.T) {
+	servedResults.Store(nil)
+	aggregates, _ = parseAggregates("min,max,mean")
+	fieldPrecision, rounding = FieldPrecision{1, 1, 1}, "float"
+	t.Cleanup(func() { servedResults.Store(nil) })
+	handler := queryHandler()
+
+	get := func(target string) (int, string) {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+		return recorder.Code, recorder.Body.String()
+	}
+	if code, _ := get("/stations"); code != http.StatusServiceUnavailable {
+		t.Errorf("/stations before publishing answered %d, want 503", code)
+	}
+
+	servedResults.Store(&[]result{
+		{name: "Bulawayo", stats: stats.NameStats{Min: -46, Max: 89, Sum: 44, Count: 2}},
+		{name: "Hamburg", stats: stats.NameStats{Min: -1, Max: 342, Sum: 461, Count: 3}},
+		{name: "St. John's", stats: stats.NameStats{Min: -210, Max: -210, Sum: -210, Count: 1}},
+	})
+	tests := []struct {
+		target string
+		code   int
+		want   string
+	}{
+		{"/stations", http.StatusOK, `"station": "Bulawayo", "min": -4.6, "max": 8.9, "mean": 2.2, "count": 2},
+  {"station": "Hamburg"`},
+		{"/stations/St.%20John's", http.StatusOK, `{"station": "St. John's", "min": -21.0, "max": -21.0, "mean": -21.0, "count": 1}`},
+		{"/stations/Cracow", http.StatusNotFound, `{"error": "no station \"Cracow\""}`},
+		{"/top?by=max&n=1", http.StatusOK, "[\n  {\"station\": \"Hamburg\", \"min\": -0.1, \"max\": 34.2, \"mean\": 15.4, \"count\": 3}\n]"},
+		{"/top?by=min&n=2", http.StatusOK, "\"St. John's\"" + `, "min": -21.0, "max": -21.0, "mean": -21.0, "count": 1},
+  {"station": "Bulawayo"`},
+		{"/top?n=0", http.StatusBadRequest, "n must be a positive integer"},
+		{"/top?by=sum", http.StatusBadRequest, "by must be"},
+	}
+	for _, test := range tests {
+		code, body := get(test.target)
+		if code != test.code || !strings.Contains(body, test.want) {
+			t.Errorf("%s answered %d with\n%s\nwant %d with %q", test.target, code, body, test.code, test.want)
+		}
+	}
+}
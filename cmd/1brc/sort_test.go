@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Readings where every sort key ties between stations: Bulawayo and Hamburg hold the same stats,
+// Cracow shares the mean of both and Palembang, Abha shares the count of Cracow
+const sortTestInput = "Hamburg;20.0\nPalembang;30.0\nBulawayo;10.0\nCracow;15.0\nHamburg;10.0\n" +
+	"Abha;5.0\nPalembang;0.0\nBulawayo;20.0\nPalembang;15.0\n"
+
+// Function to run the command over the sort test input with args, returning the printed
+// station names in order
+func sortedStations(t *testing.T, args ...string) string {
+	t.Helper()
+	path := writeTestInput(t, "measurements.txt", sortTestInput)
+	stdout, code := runCLI(t, append([]string{"-file", path, "-output", "csv", "-aggs", "min,max,mean,count", "-workers", "3"}, args...)...)
+	if code != 0 {
+		t.Fatalf("%v exited with %d", args, code)
+	}
+	var names []string
+	for _, line := range strings.Split(strings.TrimSpace(string(stdout)), "\n")[1:] {
+		name, _, _ := strings.Cut(line, ",")
+		names = append(names, name)
+	}
+	return strings.Join(names, ",")
+}
+
+// Checks the order of every -sort key, name and min lowest first and max, avg and count highest
+// first, with ties broken by name
+func TestSortOrders(t *testing.T) {
+	tests := []struct{ sort, want string }{
+		{"name", "Abha,Bulawayo,Cracow,Hamburg,Palembang"},
+		{"min", "Palembang,Abha,Bulawayo,Hamburg,Cracow"},
+		{"max", "Palembang,Bulawayo,Hamburg,Cracow,Abha"},
+		{"avg", "Bulawayo,Cracow,Hamburg,Palembang,Abha"},
+		{"count", "Palembang,Bulawayo,Hamburg,Abha,Cracow"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			if got := sortedStations(t, "-sort", tt.sort); got != tt.want {
+				t.Errorf("-sort %s printed %s, want %s", tt.sort, got, tt.want)
+			}
+		})
+	}
+
+	path := writeTestInput(t, "measurements.txt", sortTestInput)
+	for _, args := range [][]string{{"-sort", "median"}, {"-sort", "avg", "-aggs", "min,max"}} {
+		if _, code := runCLI(t, append([]string{"-file", path}, args...)...); code != 2 {
+			t.Errorf("%v exited with %d, want 2", args, code)
+		}
+	}
+}
+
+// Checks that -top N -by keeps the N most extreme stations in -by order whatever -sort says, ties
+// at the cut broken by name, and all of them in that order when N exceeds the station count
+func TestTopBy(t *testing.T) {
+	tests := []struct {
+		top, by, want string
+	}{
+		{"2", "min", "Palembang,Abha"},
+		{"1", "max", "Palembang"},
+		{"2", "max", "Palembang,Bulawayo"},
+		{"3", "avg", "Bulawayo,Cracow,Hamburg"},
+		{"4", "count", "Palembang,Bulawayo,Hamburg,Abha"},
+		{"5", "min", "Palembang,Abha,Bulawayo,Hamburg,Cracow"},
+		{"10", "avg", "Bulawayo,Cracow,Hamburg,Palembang,Abha"},
+		{"1000", "count", "Palembang,Bulawayo,Hamburg,Abha,Cracow"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.by+" "+tt.top, func(t *testing.T) {
+			if got := sortedStations(t, "-top", tt.top, "-by", tt.by, "-sort", "name"); got != tt.want {
+				t.Errorf("-top %s -by %s printed %s, want %s", tt.top, tt.by, got, tt.want)
+			}
+		})
+	}
+
+	path := writeTestInput(t, "measurements.txt", sortTestInput)
+	for _, args := range [][]string{{"-top", "-1"}, {"-top", "2", "-by", "name"}, {"-top", "2", "-by", "avg", "-aggs", "min,max"}} {
+		if _, code := runCLI(t, append([]string{"-file", path}, args...)...); code != 2 {
+			t.Errorf("%v exited with %d, want 2", args, code)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"example.com/mod/internal/stats"
+)
+
+// Bytes of input text (or Parquet file bytes) read, added by the readers as they go
+var bytesRead int64
+
+// Start of the run, for the elapsed time in the summary
+var runStart = time.Now()
+
+// Function to count bytes a reader got through, readers call it after every batch so the progress shows them
+func countBytesRead(n int64) {
+	atomic.AddInt64(&bytesRead, n)
+}
+
+// Rows folded into the tables so far, published by the readers along with their batches for the
+// -progress-json events; the tables themselves are only counted once the workers are done
+var rowsRead int64
+
+// Function to publish the rows a worker folded into table since it last did, when its row count
+// was counted
+func countRowsRead(table *stats.Table, counted *int64) {
+	atomic.AddInt64(&rowsRead, table.Rows-*counted)
+	*counted = table.Rows
+}
+
+// Function to count the rows folded into the tables of all workers
+func aggregatedRows() int64 {
+	return workerTables.Rows()
+}
+
+// Function to print the end-of-run summary: rows, malformed rows, distinct stations, bytes read,
+// elapsed time and throughput
+func printSummary(w io.Writer) {
+	elapsed := time.Since(runStart)
+	aggregated := aggregatedRows()
+	malformed := atomic.LoadInt64(&malformedLines)
+	rows := aggregated + malformed + outOfRangeReadings()
+	if outOfRange == "flag" {
+		// Flagged readings are aggregated as well, do not count them twice
+		rows -= outOfRangeReadings()
+	}
+
+	var stations string
+	if estimateDistinct {
+		stations = fmt.Sprintf("~%d", workerTables.EstimateDistinct())
+	} else if distinct := aggregatedDistinct.Load(); distinct > 0 {
+		stations = fmt.Sprintf("~%d", distinct)
+	} else {
+		count := 0
+		for _, shard := range statsShards {
+			count += shard.Len()
+		}
+		stations = fmt.Sprint(count)
+	}
+
+	fmt.Fprintln(w, "Summary:")
+	fmt.Fprintf(w, "  Rows processed:    %d\n", rows)
+	fmt.Fprintf(w, "  Rows aggregated:   %d\n", aggregated)
+	fmt.Fprintf(w, "  Malformed rows:    %d\n", malformed)
+	if lineParser.Aliases != nil {
+		fmt.Fprintf(w, "  Remapped rows:     %d\n", lineParser.Remapped.Load())
+	}
+	fmt.Fprintf(w, "  Distinct stations: %s\n", stations)
+	fmt.Fprintf(w, "  Bytes read:        %d\n", atomic.LoadInt64(&bytesRead))
+	fmt.Fprintf(w, "  Elapsed:           %s\n", elapsed.Round(time.Millisecond))
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		fmt.Fprintf(w, "  Rows/sec:          %.0f\n", float64(rows)/seconds)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// Function to print the outcome of a -validate run, reporting whether every row was valid
+func printValidation(w io.Writer) (bool, error) {
+	valid := aggregatedRows()
+	malformed := atomic.LoadInt64(&malformedLines)
+	violations := outOfRangeReadings()
+	checked := valid + malformed + violations
+	if outOfRange == "flag" {
+		// Flagged readings were counted as valid rows as well
+		valid -= violations
+		checked -= violations
+	}
+
+	var err error
+	report := func(format string, args ...any) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+	report("Rows checked:   %d\n", checked)
+	report("Valid rows:     %d\n", valid)
+	report("Malformed rows: %d\n", malformed)
+	if validRange.enabled {
+		report("Out of range:   %d (outside %s)\n", violations, validRange.String())
+	}
+	return malformed == 0 && violations == 0, err
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Number of differing lines verify prints before only counting the rest
+const maxReportedMismatches = 20
+
+// Numbers compared within -tolerance by verify, everything around them must match exactly
+var verifyNumber = regexp.MustCompile(`[-+]?[0-9]+(\.[0-9]+)?([eE][-+]?[0-9]+)?`)
+
+// Bytes separating the fields of the output formats, a number is a value only when it is the
+// whole field between two of them
+const verifySeparators = "=:/,;|{}[]\t"
+
+// Function to compare the results with the -expected baseline line by line, numbers within
+// -tolerance, printing the differing lines to w and reporting whether all of them matched
+func verifyResults(w io.Writer) (bool, error) {
+	expected, err := os.ReadFile(expectedPath)
+	if err != nil {
+		return false, err
+	}
+	var actual bytes.Buffer
+	if err := writeResults(&actual); err != nil {
+		return false, err
+	}
+
+	expectedLines := strings.Split(strings.TrimSuffix(string(expected), "\n"), "\n")
+	actualLines := strings.Split(strings.TrimSuffix(actual.String(), "\n"), "\n")
+	mismatches := 0
+	for i := range max(len(expectedLines), len(actualLines)) {
+		want, got := "", ""
+		if i < len(expectedLines) {
+			want = expectedLines[i]
+		}
+		if i < len(actualLines) {
+			got = actualLines[i]
+		}
+		if i < len(expectedLines) && i < len(actualLines) && linesMatch(want, got, verifyTolerance) {
+			continue
+		}
+		mismatches++
+		if mismatches <= maxReportedMismatches {
+			fmt.Fprintf(w, "line %d:\n  expected: %s\n  actual:   %s\n", i+1, want, got)
+		}
+	}
+
+	if mismatches > 0 {
+		_, err = fmt.Fprintf(w, "FAIL: %d of %d lines differ from %s\n", mismatches, len(expectedLines), expectedPath)
+		return false, err
+	}
+	_, err = fmt.Fprintf(w, "OK: %d lines match %s\n", len(expectedLines), expectedPath)
+	return true, err
+}
+
+// Function to check whether two result lines are the same apart from numbers differing by no
+// more than tolerance
+func linesMatch(want, got string, tolerance float64) bool {
+	if want == got {
+		return true
+	}
+	wantNumbers := valueSpans(want)
+	gotNumbers := valueSpans(got)
+	if len(wantNumbers) != len(gotNumbers) {
+		return false
+	}
+
+	wantAt, gotAt := 0, 0
+	for i := range wantNumbers {
+		w, g := wantNumbers[i], gotNumbers[i]
+		if want[wantAt:w[0]] != got[gotAt:g[0]] {
+			return false
+		}
+		a, errA := strconv.ParseFloat(want[w[0]:w[1]], 64)
+		b, errB := strconv.ParseFloat(got[g[0]:g[1]], 64)
+		// A little slack on top of the tolerance so 0.1 apart is within -tolerance 0.1
+		if errA != nil || errB != nil || math.Abs(a-b) > tolerance+1e-9 {
+			return false
+		}
+		wantAt, gotAt = w[1], g[1]
+	}
+	return want[wantAt:] == got[gotAt:]
+}
+
+// Function to find the numbers of a result line that are values, leaving out the ones inside a
+// name such as the 12 of "Station 12" so names still have to match exactly
+func valueSpans(line string) [][]int {
+	var spans [][]int
+	for _, span := range verifyNumber.FindAllStringIndex(line, -1) {
+		before := strings.TrimRight(line[:span[0]], " ")
+		after := strings.TrimLeft(line[span[1]:], " ")
+		if (before == "" || strings.IndexByte(verifySeparators, before[len(before)-1]) >= 0) &&
+			(after == "" || strings.IndexByte(verifySeparators, after[0]) >= 0) {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
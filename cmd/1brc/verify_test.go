@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestLinesMatch(t *testing.T) {
+	tests := []struct {
+		want, got string
+		match     bool
+	}{
+		{"Name: Hamburg, Min: -4.60, Max: 8.90, Avg: 2.15", "Name: Hamburg, Min: -4.70, Max: 8.90, Avg: 2.10", true},
+		{"Name: Hamburg, Min: -4.60, Max: 8.90, Avg: 2.15", "Name: Hamburg, Min: -4.80, Max: 8.90, Avg: 2.15", false},
+		{"Name: Station 12, Min: 1.00", "Name: Station 13, Min: 1.00", false},
+		{"{Station 12=1.0/2.0/3.0, Abha=4.0/5.0/6.0}", "{Station 13=1.0/2.0/3.0, Abha=4.0/5.0/6.0}", false},
+		{"{Station 12=1.0/2.0/3.0, Abha=4.0/5.0/6.0}", "{Station 12=1.1/2.0/2.9, Abha=4.0/5.1/6.0}", true},
+		{`{"name": "Station 12", "min": 1.0}`, `{"name": "Station 13", "min": 1.0}`, false},
+		{`{"name": "Station 12", "min": 1.0}`, `{"name": "Station 12", "min": 0.9}`, true},
+		{"| Station 12 |  1.0 |", "| Station 12 |  1.1 |", true},
+		{"Station 12,1.0,2.0", "Station 13,1.0,2.0", false},
+	}
+	for _, test := range tests {
+		if got := linesMatch(test.want, test.got, 0.1); got != test.match {
+			t.Errorf("linesMatch(%q, %q, 0.1) = %v, want %v", test.want, test.got, got, test.match)
+		}
+	}
+}
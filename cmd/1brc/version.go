@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+)
+
+// Function to print the module version, the VCS revision the binary was built from and the
+// Go version, so benchmark results can be tied to an exact build
+func printVersion(w io.Writer) {
+	version, revision, modified, built := "(devel)", "unknown", "", ""
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if info.Main.Version != "" {
+			version = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				revision = setting.Value
+			case "vcs.time":
+				built = " (" + setting.Value + ")"
+			case "vcs.modified":
+				if setting.Value == "true" {
+					modified = "-dirty"
+				}
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "1brc %s\n", version)
+	fmt.Fprintf(w, "revision: %s%s%s\n", revision, modified, built)
+	fmt.Fprintf(w, "go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
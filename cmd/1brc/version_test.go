@@ -0,0 +1,19 @@
+package main
+
+import (
+	"regexp"
+	"runtime"
+	"testing"
+)
+
+// Checks that -version prints the module version, the VCS revision and the Go version the binary
+// was built with and exits 0 without reading any input
+func TestVersion(t *testing.T) {
+	want := regexp.MustCompile(`^1brc \S+\nrevision: \S+( \(\S+\))?\ngo: ` + regexp.QuoteMeta(runtime.Version()+" "+runtime.GOOS+"/"+runtime.GOARCH) + "\n$")
+	for _, args := range [][]string{{"-version"}, {"run", "-version", "-file", "missing.txt"}} {
+		got, code := runCLI(t, args...)
+		if code != 0 || !want.Match(got) {
+			t.Errorf("%v exited with %d and printed\n%s\nwant 0 and the build information", args, code, got)
+		}
+	}
+}
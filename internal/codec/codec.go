@@ -0,0 +1,125 @@
+// Package codec turns the bytes of an input into plain UTF-8 text: it recognizes and decompresses
+// gzip and zstd streams and strips byte order marks, transcoding UTF-16 on the fly
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Struct to describe a supported compression format
+type Codec struct {
+	Name      string
+	Extension string
+	magic     []byte
+}
+
+// Compression formats recognized by extension or by the magic bytes at the start of the stream
+var Codecs = []Codec{
+	{Name: "gzip", Extension: ".gz", magic: []byte{0x1f, 0x8b}},
+	{Name: "zstd", Extension: ".zst", magic: []byte{0x28, 0xb5, 0x2f, 0xfd}},
+}
+
+// Length of the longest magic byte sequence
+const MaxMagicLength = 4
+
+// Function to find the codec whose magic bytes start the given prefix, or nil for plain text
+func Sniff(prefix []byte) *Codec {
+	for i := range Codecs {
+		if bytes.HasPrefix(prefix, Codecs[i].magic) {
+			return &Codecs[i]
+		}
+	}
+	return nil
+}
+
+// Function to find the codec of a path by its extension, or nil when it has none of theirs
+func ByExtension(path string) *Codec {
+	for i := range Codecs {
+		if strings.HasSuffix(path, Codecs[i].Extension) {
+			return &Codecs[i]
+		}
+	}
+	return nil
+}
+
+// Function to tell whether an input holds compressed data, by the extension of its path or by the
+// magic bytes its prefix starts with
+func IsCompressed(path string, prefix []byte) bool {
+	return ByExtension(path) != nil || Sniff(prefix) != nil
+}
+
+// Function to read the first MaxMagicLength bytes of an input, or all of a shorter one, enough
+// to tell its codec and byte order mark apart
+func ReadPrefix(r io.Reader) ([]byte, error) {
+	prefix := make([]byte, MaxMagicLength)
+	read, err := io.ReadFull(r, prefix)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return prefix[:read], nil
+}
+
+// Function to wrap a stream in the decompressor of the codec, zstd decoding blocks on up to
+// concurrency goroutines
+func (c *Codec) Decompress(r io.Reader, concurrency int) (io.ReadCloser, error) {
+	switch c.Name {
+	case "gzip":
+		return gzip.NewReader(r)
+	case "zstd":
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(concurrency))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported codec: %s", c.Name)
+	}
+}
+
+// Struct to prefix the errors of a decompressor with its codec and the input, so a truncated or
+// corrupt file reads as "gzip data.gz: unexpected EOF"
+type codecReader struct {
+	r     io.Reader
+	codec string
+	input string
+}
+
+// Function to read decompressed bytes, wrapping the errors other than io.EOF
+func (c codecReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("%s %s: %w", c.codec, c.input, err)
+	}
+	return n, err
+}
+
+// Struct to read the decoded text of a stream and close its decompressor once done
+type textReader struct {
+	io.Reader
+	io.Closer
+}
+
+// Function to open a stream as plain UTF-8 text, transparently decompressing it when it starts
+// with known magic bytes and transcoding it when it starts with a UTF-16 byte order mark; input
+// names the stream in the errors and concurrency bounds the goroutines of the decompressor
+func Open(r io.Reader, input string, concurrency int) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	prefix, _ := buffered.Peek(MaxMagicLength)
+	c := Sniff(prefix)
+	if c == nil {
+		return io.NopCloser(DecodeText(buffered)), nil
+	}
+
+	decompressed, err := c.Decompress(buffered, concurrency)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s stream: %s: %w", c.Name, input, err)
+	}
+	return textReader{DecodeText(bufio.NewReader(codecReader{decompressed, c.Name, input})), decompressed}, nil
+}
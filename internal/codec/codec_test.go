@@ -0,0 +1,98 @@
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestTruncatedCodecError(t *testing.T) {
+	input := strings.Repeat("Hamburg;12.0\nBulawayo;8.9\n", 100)
+	var gzipped, zstded bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	gw.Write([]byte(input))
+	gw.Close()
+	zw, _ := zstd.NewWriter(&zstded)
+	zw.Write([]byte(input))
+	zw.Close()
+
+	for _, test := range []struct {
+		codec      string
+		compressed []byte
+	}{
+		{"gzip", gzipped.Bytes()},
+		{"zstd", zstded.Bytes()},
+	} {
+		c := Sniff(test.compressed)
+		if c == nil || c.Name != test.codec {
+			t.Fatalf("Sniff of %s data = %v", test.codec, c)
+		}
+		truncated := test.compressed[:len(test.compressed)-8]
+		decoded, err := Open(bytes.NewReader(truncated), "data"+c.Extension, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.ReadAll(decoded)
+		decoded.Close()
+		want := test.codec + " data" + c.Extension + ": "
+		if err == nil || !strings.HasPrefix(err.Error(), want) || errors.Is(err, io.EOF) {
+			t.Errorf("reading truncated %s data = %v, want an error starting with %q", test.codec, err, want)
+		}
+	}
+}
+
+// Checks that Open gives the same text for plain, BOM-prefixed, gzip, zstd and UTF-16 inputs
+func TestOpen(t *testing.T) {
+	const text = "Zürich;1.5\nHamburg;-3.4\n"
+	var gzipped, zstded bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	gw.Write([]byte("\xef\xbb\xbf" + text))
+	gw.Close()
+	zw, _ := zstd.NewWriter(&zstded)
+	zw.Write([]byte(text))
+	zw.Close()
+
+	for _, test := range []struct {
+		name       string
+		input      []byte
+		compressed bool
+	}{
+		{"plain", []byte(text), false},
+		{"UTF-8 BOM", []byte("\xef\xbb\xbf" + text), false},
+		{"gzip", gzipped.Bytes(), true},
+		{"zstd", zstded.Bytes(), true},
+		{"UTF-16", utf16Bytes(text), false},
+	} {
+		decoded, err := Open(bytes.NewReader(test.input), test.name, 2)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		got, err := io.ReadAll(decoded)
+		decoded.Close()
+		if err != nil || string(got) != text {
+			t.Errorf("%s input decoded to %q, %v, want %q", test.name, got, err, text)
+		}
+		if compressed := Sniff(test.input) != nil; compressed != test.compressed {
+			t.Errorf("%s input sniffed as compressed %v", test.name, compressed)
+		}
+		if needs := NeedsDecoding(test.input); needs != (test.compressed || test.name == "UTF-16") {
+			t.Errorf("%s input needs decoding %v", test.name, needs)
+		}
+	}
+}
+
+// Function to encode text as little-endian UTF-16 behind its byte order mark
+func utf16Bytes(text string) []byte {
+	encoded := bytes.Clone(utf16LEBOM)
+	for _, unit := range utf16.Encode([]rune(text)) {
+		encoded = binary.LittleEndian.AppendUint16(encoded, unit)
+	}
+	return encoded
+}
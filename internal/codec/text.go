@@ -0,0 +1,137 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Byte order marks recognized at the start of the input
+var (
+	utf8BOM    = []byte{0xef, 0xbb, 0xbf}
+	utf16LEBOM = []byte{0xff, 0xfe}
+	utf16BEBOM = []byte{0xfe, 0xff}
+)
+
+// Function to return the byte order of a UTF-16 byte order mark starting prefix, or nil if there is none
+func utf16Order(prefix []byte) binary.ByteOrder {
+	switch {
+	case bytes.HasPrefix(prefix, utf16LEBOM):
+		return binary.LittleEndian
+	case bytes.HasPrefix(prefix, utf16BEBOM):
+		return binary.BigEndian
+	default:
+		return nil
+	}
+}
+
+// Function to tell whether a stream starting with prefix is compressed or UTF-16, so it cannot be
+// read as UTF-8 text in byte ranges
+func NeedsDecoding(prefix []byte) bool {
+	return Sniff(prefix) != nil || utf16Order(prefix) != nil
+}
+
+// Function to return the length of the UTF-8 byte order mark at the start of r, or 0 if there is none
+func UTF8BOMLength(r io.ReaderAt) (int64, error) {
+	prefix := make([]byte, len(utf8BOM))
+	n, err := r.ReadAt(prefix, 0)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	if bytes.Equal(prefix[:n], utf8BOM) {
+		return int64(len(utf8BOM)), nil
+	}
+	return 0, nil
+}
+
+// Function to tell whether the prefix of an input starts with a UTF-16 byte order mark
+func IsUTF16(prefix []byte) bool {
+	return utf16Order(prefix) != nil
+}
+
+// Function to strip a byte order mark from a stream, transcoding UTF-16 input to UTF-8 on the fly
+func DecodeText(r *bufio.Reader) io.Reader {
+	prefix, _ := r.Peek(len(utf8BOM))
+	if bytes.HasPrefix(prefix, utf8BOM) {
+		r.Discard(len(utf8BOM))
+		return r
+	}
+	if order := utf16Order(prefix); order != nil {
+		r.Discard(len(utf16LEBOM))
+		return &utf16Reader{r: r, order: order}
+	}
+	return r
+}
+
+// Reader that decodes a UTF-16 stream into UTF-8
+type utf16Reader struct {
+	r       *bufio.Reader
+	order   binary.ByteOrder
+	pending []byte
+	held    []uint16 // Code unit read after an unpaired high surrogate, decoded next
+	err     error
+}
+
+// Function to fill p with UTF-8 bytes, decoding as many code units as needed
+func (u *utf16Reader) Read(p []byte) (int, error) {
+	for len(u.pending) < len(p) && u.err == nil {
+		r, err := u.readRune()
+		if err != nil {
+			u.err = err
+			break
+		}
+		u.pending = utf8.AppendRune(u.pending, r)
+	}
+
+	n := copy(p, u.pending)
+	u.pending = u.pending[n:]
+	if n > 0 {
+		return n, nil
+	}
+	return 0, u.err
+}
+
+// Function to decode the next rune, combining surrogate pairs. An unpaired surrogate decodes to
+// U+FFFD without swallowing the code unit after it
+func (u *utf16Reader) readRune() (rune, error) {
+	first, err := u.readUnit()
+	if err != nil {
+		return 0, err
+	}
+	if !utf16.IsSurrogate(rune(first)) {
+		return rune(first), nil
+	}
+	if first >= 0xdc00 {
+		return utf8.RuneError, nil
+	}
+	second, err := u.readUnit()
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		u.err = err
+		return utf8.RuneError, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if second < 0xdc00 || second > 0xdfff {
+		u.held = append(u.held, second)
+		return utf8.RuneError, nil
+	}
+	return utf16.DecodeRune(rune(first), rune(second)), nil
+}
+
+// Function to read one 16-bit code unit, an odd trailing byte is an unexpected EOF
+func (u *utf16Reader) readUnit() (uint16, error) {
+	if len(u.held) > 0 {
+		unit := u.held[0]
+		u.held = u.held[1:]
+		return unit, nil
+	}
+	var unit [2]byte
+	if _, err := io.ReadFull(u.r, unit[:]); err != nil {
+		return 0, err
+	}
+	return u.order.Uint16(unit[:]), nil
+}
@@ -0,0 +1,40 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"unicode/utf16"
+)
+
+func TestUTF16Reader(t *testing.T) {
+	tests := []struct {
+		name  string
+		units []uint16
+		want  string
+	}{
+		{"basic", utf16.Encode([]rune("Zürich;1.5\n")), "Zürich;1.5\n"},
+		{"surrogate pair", utf16.Encode([]rune("𝔸;2\n")), "𝔸;2\n"},
+		{"unpaired low surrogate", []uint16{'a', 0xdc00, 'b', ';', '1'}, "a�b;1"},
+		{"unpaired high surrogate", []uint16{'a', 0xd800, 'b', ';', '1'}, "a�b;1"},
+		{"two high surrogates", []uint16{0xd800, 0xd83d, 0xde00}, "�😀"},
+		{"high surrogate at the end", []uint16{'a', 0xd800}, "a�"},
+	}
+	for _, test := range tests {
+		for _, order := range []binary.AppendByteOrder{binary.LittleEndian, binary.BigEndian} {
+			input := bytes.Clone(utf16LEBOM)
+			if order == binary.BigEndian {
+				input = bytes.Clone(utf16BEBOM)
+			}
+			for _, unit := range test.units {
+				input = order.AppendUint16(input, unit)
+			}
+			got, err := io.ReadAll(DecodeText(bufio.NewReader(bytes.NewReader(input))))
+			if err != nil || string(got) != test.want {
+				t.Errorf("%s in %v: got %q, %v, want %q", test.name, order, got, err, test.want)
+			}
+		}
+	}
+}
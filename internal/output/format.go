@@ -0,0 +1,81 @@
+// Package output formats the numbers and rows of the results
+package output
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Function to format a value with the given number of decimals
+func FormatValue(value float64, decimals int) string {
+	if decimals < 0 {
+		decimals = 0
+	}
+	// With zero decimals FormatFloat omits the decimal point entirely
+	formatted := strconv.FormatFloat(value, 'f', decimals, 64)
+
+	// Normalize negative zero (e.g. "-0" or "-0.00") to its unsigned form
+	if strings.HasPrefix(formatted, "-") && strings.Trim(formatted[1:], "0.") == "" {
+		formatted = formatted[1:]
+	}
+	return formatted
+}
+
+// Function to round the fraction numerator/denominator (denominator > 0) to the given decimals
+// with halves going up, as the reference's Math.round does, computing
+// floor(numerator * 10^decimals / denominator + 1/2) exactly in big integers
+func RoundHalfUp(numerator, denominator int64, decimals int) string {
+	return RoundHalfUpBig(big.NewInt(numerator), denominator, decimals)
+}
+
+// Function to round the fraction numerator/denominator like RoundHalfUp, for numerators that
+// may not fit an int64
+func RoundHalfUpBig(numerator *big.Int, denominator int64, decimals int) string {
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(max(decimals, 0))), nil)
+	n := new(big.Int).Mul(numerator, scale)
+	n.Mul(n, big.NewInt(2)).Add(n, big.NewInt(denominator))
+	d := big.NewInt(2 * denominator)
+
+	// Div rounds toward negative infinity for a positive divisor
+	rounded := new(big.Int).Div(n, d)
+
+	// Place the decimal point, a rounded zero never gets a sign
+	digits := new(big.Int).Abs(rounded).String()
+	sign := ""
+	if rounded.Sign() < 0 {
+		sign = "-"
+	}
+	if decimals <= 0 {
+		return sign + digits
+	}
+	if len(digits) <= decimals {
+		digits = strings.Repeat("0", decimals-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-decimals] + "." + digits[len(digits)-decimals:]
+}
+
+// Function to pad the cells of a table row to the column widths, the first cell left-aligned
+func TableRow(cells []string, widths []int) string {
+	padded := make([]string, len(cells))
+	for i, cell := range cells {
+		padding := strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell))
+		if i == 0 {
+			padded[i] = cell + padding
+		} else {
+			padded[i] = padding + cell
+		}
+	}
+	return strings.TrimRight(strings.Join(padded, "  "), " ")
+}
+
+// Function to join the cells of a markdown table row, escaping the pipes and backslashes of names
+func MarkdownRow(cells []string) string {
+	escaper := strings.NewReplacer(`\`, `\\`, "|", `\|`)
+	escaped := make([]string, len(cells))
+	for i, cell := range cells {
+		escaped[i] = escaper.Replace(cell)
+	}
+	return "| " + strings.Join(escaped, " | ") + " |"
+}
@@ -0,0 +1,64 @@
+package output
+
+import "testing"
+
+func TestFormatValue(t *testing.T) {
+	for _, c := range []struct {
+		value    float64
+		decimals int
+		want     string
+	}{
+		{12.345, 2, "12.35"},
+		{2.525, 2, "2.52"}, // The float64 nearest 2.525 lies below it
+		{-0.004, 2, "0.00"},
+		{-0.4, 0, "0"},
+		{-0.6, 0, "-1"},
+		{12.5, -1, "12"},
+		{99.9, 1, "99.9"},
+	} {
+		if got := FormatValue(c.value, c.decimals); got != c.want {
+			t.Errorf("FormatValue(%v, %d) = %q, want %q", c.value, c.decimals, got, c.want)
+		}
+	}
+}
+
+func TestRoundHalfUp(t *testing.T) {
+	for _, c := range []struct {
+		numerator, denominator int64
+		decimals               int
+		want                   string
+	}{
+		{2525, 1000, 2, "2.53"},
+		{-2525, 1000, 2, "-2.52"}, // Halves go up, toward positive infinity
+		{-5, 1000, 2, "0.00"},
+		{-6, 1000, 2, "-0.01"},
+		{5, 10, 0, "1"},
+		{-5, 10, 0, "0"},
+		{1, 3, 4, "0.3333"},
+		{2, 3, 1, "0.7"},
+		{123, 1, -2, "123"},
+		{1, 1000, 5, "0.00100"},
+		{9_223_372_036_854_775_807, 10, 1, "922337203685477580.7"},
+	} {
+		if got := RoundHalfUp(c.numerator, c.denominator, c.decimals); got != c.want {
+			t.Errorf("RoundHalfUp(%d, %d, %d) = %q, want %q", c.numerator, c.denominator, c.decimals, got, c.want)
+		}
+	}
+}
+
+func TestTableRow(t *testing.T) {
+	got := TableRow([]string{"İzmir", "-1.5", "12"}, []int{7, 5, 2})
+	if want := "İzmir     -1.5  12"; got != want {
+		t.Errorf("TableRow = %q, want %q", got, want)
+	}
+	if got := TableRow([]string{"Hamburg", ""}, []int{8, 3}); got != "Hamburg" {
+		t.Errorf("TableRow with an empty last cell = %q, want the trailing padding trimmed", got)
+	}
+}
+
+func TestMarkdownRow(t *testing.T) {
+	got := MarkdownRow([]string{`a|b\c`, "1.5"})
+	if want := `| a\|b\\c | 1.5 |`; got != want {
+		t.Errorf("MarkdownRow = %q, want %q", got, want)
+	}
+}
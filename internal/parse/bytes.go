@@ -0,0 +1,13 @@
+package parse
+
+import "unsafe"
+
+// Function to view bytes as a string without copying them, for as long as the bytes stay unchanged
+func ByteString(b []byte) string {
+	return unsafe.String(unsafe.SliceData(b), len(b))
+}
+
+// Function to view a string as bytes without copying it, the bytes must never be written to
+func StringBytes(s string) []byte {
+	return unsafe.Slice(unsafe.StringData(s), len(s))
+}
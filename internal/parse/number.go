@@ -0,0 +1,62 @@
+// Package parse converts the readings of the input to the integer tenths of a degree the stats
+// are kept in
+package parse
+
+import "math"
+
+// Function to parse a temperature of the 1BRC shape (-99.9 to 99.9 with exactly one decimal)
+// into tenths, reporting false for anything else
+func Tenths(s string) (int16, bool) {
+	negative := false
+	if len(s) > 0 && s[0] == '-' {
+		negative = true
+		s = s[1:]
+	}
+
+	// One or two integer digits, a dot and a single decimal digit
+	if len(s) != 3 && len(s) != 4 {
+		return 0, false
+	}
+	if s[len(s)-2] != '.' {
+		return 0, false
+	}
+
+	var tenths int16
+	for i := 0; i < len(s); i++ {
+		if i == len(s)-2 {
+			continue
+		}
+		digit := s[i] - '0'
+		if digit > 9 {
+			return 0, false
+		}
+		tenths = tenths*10 + int16(digit)
+	}
+
+	if negative {
+		tenths = -tenths
+	}
+	return tenths, true
+}
+
+// Function to round a reading to the nearest tenth, reporting false when it does not fit
+// the int16 tenths the stats keep min and max in (beyond +/-3276.7)
+func RoundTenths(number float64) (int16, bool) {
+	tenths := math.Round(number * 10)
+	if math.IsNaN(tenths) || tenths < math.MinInt16 || tenths > math.MaxInt16 {
+		return 0, false
+	}
+	return int16(tenths), true
+}
+
+// Function to convert a value in the given input unit to Celsius
+func ToCelsius(value float64, unit string) float64 {
+	switch unit {
+	case "F":
+		return (value - 32) * 5 / 9
+	case "K":
+		return value - 273.15
+	default:
+		return value
+	}
+}
@@ -0,0 +1,81 @@
+package parse
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+// Checks that Tenths agrees with ParseFloat on every value of the 1BRC shape, -99.9 to 99.9
+func TestTenthsMatchesParseFloat(t *testing.T) {
+	for want := -999; want <= 999; want++ {
+		s := strconv.FormatFloat(float64(want)/10, 'f', 1, 64)
+		got, ok := Tenths(s)
+		if !ok || int(got) != want {
+			t.Errorf("Tenths(%q) = %d, %v, want %d, true", s, got, ok, want)
+		}
+	}
+
+	// Single-digit values written with a leading zero still have the 1BRC shape
+	for s, want := range map[string]int16{"-0.0": 0, "00.5": 5, "-09.9": -99, "0.1": 1} {
+		if got, ok := Tenths(s); !ok || got != want {
+			t.Errorf("Tenths(%q) = %d, %v, want %d, true", s, got, ok, want)
+		}
+	}
+}
+
+// Checks that Tenths declines everything that is not of the 1BRC shape, leaving it to ParseFloat
+func TestTenthsRejects(t *testing.T) {
+	for _, s := range []string{
+		"", "-", ".", "1", "12", "-1", ".5", "-.5", "5.", "1.23", "100.0", "-100.0", "1..2", "1.2.",
+		"+1.0", "--1.0", "1-.0", "a.0", "1.a", "1,5", " 1.5", "1.5 ", "1e1", "NaN", "\x001.0", "é.5",
+	} {
+		if got, ok := Tenths(s); ok {
+			t.Errorf("Tenths(%q) = %d, true, want false", s, got)
+		}
+	}
+}
+
+func TestRoundTenths(t *testing.T) {
+	for _, c := range []struct {
+		number float64
+		want   int16
+		ok     bool
+	}{
+		{12.34, 123, true},
+		{12.35, 124, true},
+		{-12.35, -124, true},
+		{-0.04, 0, true},
+		{3276.7, math.MaxInt16, true},
+		{-3276.8, math.MinInt16, true},
+		{3276.74, math.MaxInt16, true},
+		{3276.75, 0, false},
+		{-3276.85, 0, false},
+		{math.Inf(1), 0, false},
+		{math.NaN(), 0, false},
+	} {
+		got, ok := RoundTenths(c.number)
+		if ok != c.ok || (ok && got != c.want) {
+			t.Errorf("RoundTenths(%v) = %d, %v, want %d, %v", c.number, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestToCelsius(t *testing.T) {
+	for _, c := range []struct {
+		value float64
+		unit  string
+		want  float64
+	}{
+		{21.5, "C", 21.5},
+		{32, "F", 0},
+		{212, "F", 100},
+		{-40, "F", -40},
+		{273.15, "K", 0},
+		{0, "K", -273.15},
+	} {
+		if got := ToCelsius(c.value, c.unit); math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("ToCelsius(%v, %q) = %v, want %v", c.value, c.unit, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package parse
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func FuzzParseLine(f *testing.F) {
+	for _, seed := range []string{
+		"Hamburg;12.0", "Bulawayo;-8.9", "St. John's;15.2", "İzmir;-0.0", "東京;99.9", "A;-99.9",
+		"no delimiter", ";1.0", "a;b;c", "a;", "a;1e400", "a;NaN", "a;-", "a;.5", "a;5.", "a;12.34",
+		"\"quoted;name\";1.5", "\xff\xfe;1.0", "a;1.0\r", " padded ; 3.5 ",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, line string) {
+		p := NewParser()
+		for _, quoted := range []bool{false, true} {
+			p.Quoted = quoted
+			name, tenths, weight, err := p.ParseLine(line)
+			if err != nil {
+				continue
+			}
+			if name == "" || weight != 1 {
+				t.Fatalf("ParseLine(%q) with quoted=%v = %q, %d, %d", line, quoted, name, tenths, weight)
+			}
+			if !quoted && strings.Contains(name, ";") {
+				t.Fatalf("ParseLine(%q) kept the delimiter in the name %q", line, name)
+			}
+		}
+
+		// The fixed-point fast path must agree with ParseFloat rounded to tenths
+		_, number, _ := strings.Cut(line, ";")
+		if tenths, ok := Tenths(number); ok {
+			value, err := strconv.ParseFloat(number, 64)
+			if err != nil || math.Round(value*10) != float64(tenths) {
+				t.Fatalf("Tenths(%q) = %d, ParseFloat gives %v (%v)", number, tenths, value, err)
+			}
+		}
+	})
+}
+
+// Checks the grouped, value and weight columns, the composite keys they form and the rows
+// lacking some of them
+func TestParseColumns(t *testing.T) {
+	p := NewParser()
+	p.GroupColumns, p.ValueColumn, p.WeightColumn = []int{2, 0}, 1, 3
+	tests := []struct {
+		line   string
+		name   string
+		tenths int16
+		weight int
+		fail   string
+	}{
+		{line: "indoor;21.5;Hamburg;4", name: "Hamburg/indoor", tenths: 215, weight: 4},
+		{line: " outdoor ;-3;Bulawayo; 1 ;extra", name: "Bulawayo/outdoor", tenths: -30, weight: 1},
+		{line: "indoor;21.5;Hamburg", fail: "invalid format"},
+		{line: "indoor;21.5;Hamburg;0", fail: "invalid weight: 0"},
+		{line: "indoor;21.5; ;2", fail: "empty name"},
+	}
+	for _, test := range tests {
+		name, tenths, weight, err := p.ParseLine(test.line)
+		if test.fail != "" {
+			if err == nil || !strings.Contains(err.Error(), test.fail) {
+				t.Errorf("ParseLine(%q) = %q, %v, want an error with %q", test.line, name, err, test.fail)
+			}
+		} else if err != nil || name != test.name || tenths != test.tenths || weight != test.weight {
+			t.Errorf("ParseLine(%q) = %q, %d, %d, %v, want %q, %d, %d", test.line, name, tenths, weight, err, test.name, test.tenths, test.weight)
+		}
+	}
+
+	for _, columns := range []struct {
+		group         []int
+		value, weight int
+	}{{[]int{0}, 0, -1}, {[]int{0, 1}, 1, -1}, {[]int{0}, 1, 0}, {[]int{0}, 1, 1}, {[]int{0}, -1, -1}} {
+		p.GroupColumns, p.ValueColumn, p.WeightColumn = columns.group, columns.value, columns.weight
+		if err := p.CheckColumns(); err == nil {
+			t.Errorf("CheckColumns accepted %+v", columns)
+		}
+	}
+}
+
+// Checks that jsonl objects give their name and their number, be it a JSON number or a string
+func TestParseJSONLine(t *testing.T) {
+	p := NewParser()
+	p.Format = "jsonl"
+	for _, test := range []struct {
+		line, name string
+		tenths     int16
+		fail       string
+	}{
+		{line: `{"station": "Hamburg", "temp": 12.3}`, name: "Hamburg", tenths: 123},
+		{line: `{"temp": "-3.45", "station": " Bulawayo ", "other": [1]}`, name: "Bulawayo", tenths: -35},
+		{line: `{"station": 7, "temp": 1}`, fail: `non-string "station"`},
+		{line: `{"station": "Hamburg"}`, fail: `missing "temp"`},
+		{line: `{"station": "Hamburg", "temp": "warm"}`, fail: "invalid number"},
+		{line: `Hamburg;12.3`, fail: "invalid JSON"},
+	} {
+		name, tenths, _, err := p.ParseLine(test.line)
+		if test.fail != "" {
+			if err == nil || !strings.Contains(err.Error(), test.fail) {
+				t.Errorf("ParseLine(%q) = %q, %v, want an error with %q", test.line, name, err, test.fail)
+			}
+		} else if err != nil || name != test.name || tenths != test.tenths {
+			t.Errorf("ParseLine(%q) = %q, %d, %v, want %q, %d", test.line, name, tenths, err, test.name, test.tenths)
+		}
+	}
+}
+
+func TestDetectDelimiter(t *testing.T) {
+	tests := []struct {
+		line       string
+		columns    int    // Columns of the rows, a third one holding the weight
+		want, fail string // fail is part of the error when detection must fail
+	}{
+		{line: "Hamburg;12.0", want: ";"},
+		{line: "Hamburg,12.0", want: ","},
+		{line: "Hamburg\t12.0", want: "\t"},
+		{line: "St. John's;-3.4", want: ";"},
+		{line: "Hamburg, Germany;12.0", want: ";"},
+		{line: "Hamburg;12,5", want: ","}, // "12,5" is no number, so the comma split is the only pair
+		{line: "Hamburg;12.0", columns: 3, fail: "could not detect"},
+		{line: "Hamburg;12.0;3", columns: 3, want: ";"},
+		{line: "a;1;x,2,y", columns: 3, fail: "ambiguous"}, // a | 1 | x,2,y and a;1;x | 2 | y
+		{line: "no delimiter", fail: "could not detect"},
+		{line: "a;b;1.0", fail: "could not detect"},
+		{line: "", fail: "could not detect"},
+	}
+	for _, test := range tests {
+		p := NewParser()
+		if test.columns == 3 {
+			p.WeightColumn = 2
+		}
+		got, err := p.DetectDelimiter(test.line)
+		if test.fail != "" {
+			if err == nil || !strings.Contains(err.Error(), test.fail) {
+				t.Errorf("DetectDelimiter(%q) = %q, %v, want an error with %q", test.line, got, err, test.fail)
+			}
+		} else if err != nil || got != test.want {
+			t.Errorf("DetectDelimiter(%q) = %q, %v, want %q", test.line, got, err, test.want)
+		}
+	}
+}
+
+// Checks that quoted fields keep delimiters and escaped quotes inside names
+func TestQuotedFields(t *testing.T) {
+	p := NewParser()
+	p.Quoted = true
+	tests := []struct {
+		line   string
+		name   string
+		tenths int16
+		ok     bool
+	}{
+		{`"Foo;Bar";12.3`, "Foo;Bar", 123, true},
+		{`"Say ""hi"";x";1.0`, `Say "hi";x`, 10, true},
+		{`"";1.0`, "", 0, false},
+		{`plain;-3.0`, "plain", -30, true},
+		{`"a;b";"-4.5"`, "a;b", -45, true},
+		{`"unterminated;4.0`, "", 0, false},
+		{`"a";"b";1.0`, "", 0, false},
+		{`Foo;Bar;12.3`, "", 0, false},
+	}
+	for _, test := range tests {
+		name, tenths, _, err := p.ParseLine(test.line)
+		if (err == nil) != test.ok || name != test.name || tenths != test.tenths {
+			t.Errorf("ParseLine(%q) = %q, %d, %v, want %q, %d and ok %v", test.line, name, tenths, err, test.name, test.tenths, test.ok)
+		}
+	}
+}
+
+// Checks that F and K readings are converted to Celsius, and that the converted readings must
+// fit the int16 tenths of the stats (-3276.8 to 3276.7 C)
+func TestInputUnit(t *testing.T) {
+	tests := []struct {
+		unit, number string
+		tenths       int16
+		ok           bool
+	}{
+		{"C", "12.3", 123, true},
+		{"F", "32", 0, true},
+		{"F", "212", 1000, true},
+		{"F", "-40", -400, true},
+		{"F", "98.6", 370, true},
+		{"K", "273.15", 0, true},
+		{"K", "0", -2732, true}, // -273.15 rounds to the nearest tenth
+		{"C", "3276.7", 32767, true},
+		{"C", "3276.8", 0, false},
+		{"C", "-3276.8", -32768, true},
+		{"C", "-3276.9", 0, false},
+		{"F", "5930.06", 32767, true}, // 3276.7 C
+		{"F", "5930.3", 0, false},     // 3276.83 C
+		{"F", "-5866.24", -32768, true},
+		{"F", "-5866.5", 0, false},
+		{"K", "3549.85", 32767, true},
+		{"K", "3549.9", 0, false},
+		{"K", "-3003.65", -32768, true},
+		{"K", "-3003.7", 0, false},
+	}
+	for _, test := range tests {
+		p := NewParser()
+		p.Unit = test.unit
+		_, tenths, _, err := p.ParseLine("a;" + test.number)
+		if test.ok && (err != nil || tenths != test.tenths) {
+			t.Errorf("ParseLine of %s %s = %d, %v, want %d", test.number, test.unit, tenths, err, test.tenths)
+		}
+		if !test.ok && (err == nil || !strings.Contains(err.Error(), "number out of range")) {
+			t.Errorf("ParseLine of %s %s = %d, %v, want number out of range", test.number, test.unit, tenths, err)
+		}
+	}
+}
+
+// Checks that aliased names are replaced and counted, and that readings outside a range are
+// counted with their weight and only kept with Keep
+func TestAliasesAndRange(t *testing.T) {
+	p := NewParser()
+	p.Aliases = map[string]string{"NYC": "New York City"}
+	for _, line := range []string{"NYC;1.0", " NYC ;2.0", "New York City;3.0", "NYCX;4.0"} {
+		p.ParseLine(line)
+	}
+	if name, _, _, _ := p.ParseLine("NYC;1.0"); name != "New York City" || p.Remapped.Load() != 3 {
+		t.Errorf("alias gave %q after %d remapped rows, want New York City after 3", name, p.Remapped.Load())
+	}
+
+	var unchecked *Range
+	if !unchecked.Accept(math.MaxInt16, 1) {
+		t.Error("a nil range rejected a reading")
+	}
+	for _, keep := range []bool{false, true} {
+		r := &Range{Min: -10, Max: 10, Keep: keep}
+		accepted := []bool{r.Accept(-100, 1), r.Accept(100, 1), r.Accept(-101, 2), r.Accept(101, 3)}
+		if accepted[0] != true || accepted[1] != true || accepted[2] != keep || accepted[3] != keep || r.Violations.Load() != 5 {
+			t.Errorf("range with Keep %v accepted %v and counted %d violations, want 5", keep, accepted, r.Violations.Load())
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Package reader splits inputs into lines: a block reader handing out lines without copying them
+// and the search for line boundaries the inputs are split into ranges at
+package reader
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// Reader splitting its input into lines itself: it reads blocks of a fixed size and hands
+// the lines out as slices of the block, so a line is only ever copied when a block boundary
+// cuts it and the rest of the block moves to the front
+type LineReader struct {
+	r          io.Reader
+	buf        []byte
+	limit      int // Size the buffer may grow to for a single line
+	start, end int // Bytes of buf not handed out yet
+	line       []byte
+	consumed   int64 // Offset just past the last line returned, including its terminator
+	eof        bool
+	err        error
+}
+
+// Error of a line that does not fit into the maximum line length
+var ErrLineTooLong = errors.New("line longer than -maxLineLength")
+
+// Function to create a line reader reading blocks of bufferSize bytes and accepting lines of up to
+// maxLineLength bytes, whose offsets count from start, returning it with a pointer to the offset
+// just past the last line it returned
+func NewLineReader(r io.Reader, start int64, bufferSize, maxLineLength int) (*LineReader, *int64) {
+	l := &LineReader{r: r, buf: make([]byte, bufferSize), limit: max(bufferSize, maxLineLength), consumed: start}
+	return l, &l.consumed
+}
+
+// Function to advance to the next line, without its "\n" or "\r\n", reporting false at the
+// end of the input or on an error
+func (l *LineReader) Scan() bool {
+	for {
+		if i := bytes.IndexByte(l.buf[l.start:l.end], '\n'); i >= 0 {
+			l.line = DropCR(l.buf[l.start : l.start+i])
+			l.start += i + 1
+			l.consumed += int64(i + 1)
+			return true
+		}
+		if l.err != nil {
+			l.line = nil
+			return false
+		}
+		if l.eof {
+			// The last line may lack its newline
+			if l.start == l.end {
+				l.line = nil
+				return false
+			}
+			l.line = DropCR(l.buf[l.start:l.end])
+			l.consumed += int64(l.end - l.start)
+			l.start = l.end
+			return true
+		}
+		l.fill()
+	}
+}
+
+// Function to read the next block behind the partial line left in the buffer, growing the
+// buffer up to the maximum line length when a single line fills it
+func (l *LineReader) fill() {
+	if l.start > 0 {
+		l.end = copy(l.buf, l.buf[l.start:l.end])
+		l.start = 0
+	}
+	if l.end == len(l.buf) {
+		if len(l.buf) >= l.limit {
+			l.err = ErrLineTooLong
+			return
+		}
+		grown := make([]byte, min(2*len(l.buf), l.limit))
+		copy(grown, l.buf[:l.end])
+		l.buf = grown
+	}
+
+	n, err := l.r.Read(l.buf[l.end:])
+	l.end += n
+	if err == io.EOF {
+		l.eof = true
+	} else if err != nil {
+		l.err = err
+	}
+}
+
+// Function to return the current line, valid until the next call to Scan
+func (l *LineReader) Bytes() []byte {
+	return l.line
+}
+
+// Function to return the error that stopped the reader, nil at the end of the input
+func (l *LineReader) Err() error {
+	return l.err
+}
+
+// Function to drop the carriage return of a Windows line ending
+func DropCR(line []byte) []byte {
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		return line[:len(line)-1]
+	}
+	return line
+}
+
+// Size of the reads used to look for line boundaries
+const boundaryReadSize = 4096
+
+// Function to find the offset just past the first newline at or after offset, or size if there is none
+func NextLineStart(r io.ReaderAt, offset, size int64) (int64, error) {
+	buf := make([]byte, boundaryReadSize)
+	for offset < size {
+		n, err := r.ReadAt(buf, offset)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return offset + int64(i) + 1, nil
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if n == 0 {
+			break
+		}
+		offset += int64(n)
+	}
+	return size, nil
+}
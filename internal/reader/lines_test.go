@@ -0,0 +1,109 @@
+package reader
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// Reader handing out its chunks one per Read call, for lines cut between two reads
+type chunkReader struct {
+	chunks []string
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if len(c.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, c.chunks[0])
+	if c.chunks[0] = c.chunks[0][n:]; c.chunks[0] == "" {
+		c.chunks = c.chunks[1:]
+	}
+	return n, nil
+}
+
+// Function to read all lines with the offset each one starts at
+func readLines(t *testing.T, r io.Reader, bufferSize, maxLineLength int) ([]string, []int64, error) {
+	t.Helper()
+	lines, consumed := NewLineReader(r, 3, bufferSize, maxLineLength)
+	var got []string
+	var offsets []int64
+	for offset := *consumed; lines.Scan(); offset = *consumed {
+		got = append(got, string(lines.Bytes()))
+		offsets = append(offsets, offset)
+	}
+	return got, offsets, lines.Err()
+}
+
+// Checks that a line cut between two reads, even in the middle of its number, comes out once and whole
+func TestLineReaderSplitReads(t *testing.T) {
+	input := "Hamburg;12.3\r\nBulawayo;8.9\n\nSt. John's;-15.2"
+	want := []string{"Hamburg;12.3", "Bulawayo;8.9", "", "St. John's;-15.2"}
+	wantOffsets := []int64{3, 17, 30, 31}
+
+	readers := map[string]func() io.Reader{
+		"two chunks": func() io.Reader {
+			return &chunkReader{[]string{"Hamburg;12.", "3\r\nBulawayo;8.9\n\nSt. John's;-1", "5.2"}}
+		},
+		"cut before \\n": func() io.Reader {
+			return &chunkReader{[]string{"Hamburg;12.3\r", "\nBulawayo;8.9", "\n\nSt. John's;-15.2"}}
+		},
+		"one byte":      func() io.Reader { return iotest.OneByteReader(strings.NewReader(input)) },
+		"data with EOF": func() io.Reader { return iotest.DataErrReader(strings.NewReader(input)) },
+		"half reads":    func() io.Reader { return iotest.HalfReader(strings.NewReader(input)) },
+		"one read":      func() io.Reader { return strings.NewReader(input) },
+	}
+	for name, newReader := range readers {
+		for _, bufferSize := range []int{4, 16, 4096} {
+			got, offsets, err := readLines(t, newReader(), bufferSize, 64)
+			if err != nil || !reflect.DeepEqual(got, want) || !reflect.DeepEqual(offsets, wantOffsets) {
+				t.Errorf("%s with %d-byte blocks = %q at %v, %v, want %q at %v", name, bufferSize, got, offsets, err, want, wantOffsets)
+			}
+		}
+	}
+}
+
+func TestLineReaderTooLong(t *testing.T) {
+	got, _, err := readLines(t, strings.NewReader("short\n"+strings.Repeat("x", 40)+"\nafter\n"), 8, 32)
+	if !errors.Is(err, ErrLineTooLong) || !reflect.DeepEqual(got, []string{"short"}) {
+		t.Errorf("reading a 40-byte line with a 32-byte limit = %q, %v, want [short] and ErrLineTooLong", got, err)
+	}
+
+	// A line of exactly the limit still fits once the buffer has grown
+	got, _, err = readLines(t, strings.NewReader(strings.Repeat("y", 31)+"\n"), 8, 32)
+	if err != nil || len(got) != 1 || len(got[0]) != 31 {
+		t.Errorf("reading a 31-byte line with a 32-byte limit = %q, %v", got, err)
+	}
+}
+
+func TestLineReaderError(t *testing.T) {
+	failure := errors.New("disk on fire")
+	got, _, err := readLines(t, io.MultiReader(strings.NewReader("a;1\nb;"), iotest.ErrReader(failure)), 16, 64)
+	if !errors.Is(err, failure) || !reflect.DeepEqual(got, []string{"a;1"}) {
+		t.Errorf("reading through a failing reader = %q, %v, want [a;1] and the read error, not the partial line", got, err)
+	}
+}
+
+func TestNextLineStart(t *testing.T) {
+	input := "a;1\nbb;2\n" + strings.Repeat("c", 5000) + ";3\nd;4"
+	r := strings.NewReader(input)
+	size := int64(len(input))
+	tests := []struct {
+		offset, want int64
+	}{
+		{0, 4},
+		{3, 4},
+		{4, 9},
+		{9, int64(strings.Index(input, "d;4"))},
+		{size - 1, size},
+		{size, size},
+	}
+	for _, test := range tests {
+		if got, err := NextLineStart(r, test.offset, size); err != nil || got != test.want {
+			t.Errorf("NextLineStart(%d) = %d, %v, want %d", test.offset, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// Package stats holds what is kept per station: the tables of their stats, t-digests for
+// percentiles and HyperLogLog for counting distinct names
+package stats
+
+import (
+	"math"
+	"slices"
+)
+
+// Compression of the digests, the number of centroids stays within a small multiple of it
+const digestCompression = 100
+
+// Number of readings buffered before a digest is compressed
+const digestBufferSize = 500
+
+// Struct to hold a cluster of nearby readings of a digest
+type centroid struct {
+	mean, weight float64
+}
+
+// Merging t-digest estimating the quantiles of the readings of one name in bounded memory,
+// keeping the clusters small near the tails so high and low percentiles stay accurate
+type Digest struct {
+	centroids []centroid // Compressed clusters, sorted by mean
+	buffer    []centroid // Readings not compressed yet, in arrival order
+	min, max  float64
+}
+
+// Function to create an empty digest
+func NewDigest() *Digest {
+	return &Digest{min: math.Inf(1), max: math.Inf(-1)}
+}
+
+// Function to add a reading to the digest, counting it weight times
+func (d *Digest) Add(value float64, weight int) {
+	d.buffer = append(d.buffer, centroid{mean: value, weight: float64(weight)})
+	d.min = min(d.min, value)
+	d.max = max(d.max, value)
+	if len(d.buffer) >= digestBufferSize {
+		d.compress()
+	}
+}
+
+// Function to fold the clusters of another digest into this one, leaving the other untouched
+func (d *Digest) MergeFrom(other *Digest) {
+	d.buffer = append(d.buffer, other.centroids...)
+	d.buffer = append(d.buffer, other.buffer...)
+	d.min = min(d.min, other.min)
+	d.max = max(d.max, other.max)
+	d.compress()
+}
+
+// Function to copy the digest, so merged results never share clusters with a worker's digest
+func (d *Digest) Clone() *Digest {
+	return &Digest{
+		centroids: slices.Clone(d.centroids),
+		buffer:    slices.Clone(d.buffer),
+		min:       d.min,
+		max:       d.max,
+	}
+}
+
+// Function to sort the buffered readings into the clusters and merge neighbouring clusters
+// as long as they stay within the size the k1 scale function allows at their quantile
+func (d *Digest) compress() {
+	if len(d.buffer) == 0 {
+		return
+	}
+	all := append(d.centroids, d.buffer...)
+	d.buffer = d.buffer[:0]
+	slices.SortFunc(all, func(a, b centroid) int {
+		switch {
+		case a.mean < b.mean:
+			return -1
+		case a.mean > b.mean:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	total := 0.0
+	for _, c := range all {
+		total += c.weight
+	}
+
+	merged := make([]centroid, 0, len(all))
+	current := all[0]
+	before := 0.0
+	limit := digestQuantileLimit(0)
+	for _, c := range all[1:] {
+		if (before+current.weight+c.weight)/total <= limit {
+			current.mean += (c.mean - current.mean) * c.weight / (current.weight + c.weight)
+			current.weight += c.weight
+			continue
+		}
+		merged = append(merged, current)
+		before += current.weight
+		limit = digestQuantileLimit(before / total)
+		current = c
+	}
+	d.centroids = append(merged, current)
+}
+
+// Function to find the quantile up to which a cluster starting at quantile q may grow,
+// one unit further along the k1 scale k(q) = compression/(2*pi) * asin(2q-1)
+func digestQuantileLimit(q float64) float64 {
+	k := digestCompression/(2*math.Pi)*math.Asin(2*q-1) + 1
+	if k >= digestCompression/4 {
+		return 1
+	}
+	return (math.Sin(k*2*math.Pi/digestCompression) + 1) / 2
+}
+
+// Function to estimate the value below which the fraction q of the readings fall,
+// interpolating between the centers of neighbouring clusters
+func (d *Digest) Quantile(q float64) float64 {
+	d.compress()
+	if len(d.centroids) == 0 {
+		return math.NaN()
+	}
+	if len(d.centroids) == 1 {
+		return d.centroids[0].mean
+	}
+
+	total := 0.0
+	for _, c := range d.centroids {
+		total += c.weight
+	}
+	target := q * total
+
+	// Below the center of the first cluster interpolate from the smallest reading
+	first := d.centroids[0]
+	if target < first.weight/2 {
+		return d.min + (first.mean-d.min)*target/(first.weight/2)
+	}
+
+	cumulative := 0.0
+	for i := 0; i+1 < len(d.centroids); i++ {
+		left, right := d.centroids[i], d.centroids[i+1]
+		leftCenter := cumulative + left.weight/2
+		rightCenter := cumulative + left.weight + right.weight/2
+		if target <= rightCenter {
+			return left.mean + (right.mean-left.mean)*(target-leftCenter)/(rightCenter-leftCenter)
+		}
+		cumulative += left.weight
+	}
+
+	// Past the center of the last cluster interpolate up to the largest reading
+	last := d.centroids[len(d.centroids)-1]
+	lastCenter := total - last.weight/2
+	return last.mean + (d.max-last.mean)*(target-lastCenter)/(last.weight/2)
+}
@@ -0,0 +1,82 @@
+package stats
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+// Checks the estimated quantiles of shuffled uniform readings, the tails within a hundredth of
+// the range and the middle within a percent
+func TestDigestQuantiles(t *testing.T) {
+	const n = 100_000
+	d := NewDigest()
+	for _, i := range rand.New(rand.NewPCG(1, 2)).Perm(n) {
+		d.Add(float64(i), 1)
+	}
+
+	for _, c := range []struct{ q, tolerance float64 }{
+		{0, 0}, {0.001, 0.0005}, {0.01, 0.001}, {0.25, 0.01}, {0.5, 0.01}, {0.9, 0.01}, {0.99, 0.001}, {0.999, 0.0005}, {1, 0},
+	} {
+		if got, want := d.Quantile(c.q), c.q*(n-1); math.Abs(got-want) > c.tolerance*n {
+			t.Errorf("quantile %v is %v, want %v within %v", c.q, got, want, c.tolerance*n)
+		}
+	}
+	if len(d.centroids) > 10*digestCompression {
+		t.Errorf("digest keeps %d centroids, want at most %d", len(d.centroids), 10*digestCompression)
+	}
+}
+
+// Checks that weights count a reading several times, as adding it that often does
+func TestDigestWeights(t *testing.T) {
+	weighted, repeated := NewDigest(), NewDigest()
+	for i := range 1000 {
+		weight := 1 + i%5
+		weighted.Add(float64(i), weight)
+		for range weight {
+			repeated.Add(float64(i), 1)
+		}
+	}
+	for _, q := range []float64{0.1, 0.5, 0.9} {
+		if got, want := weighted.Quantile(q), repeated.Quantile(q); math.Abs(got-want) > 10 {
+			t.Errorf("weighted quantile %v is %v, want about %v", q, got, want)
+		}
+	}
+}
+
+// Checks that merged digests estimate as one fed all readings would, and that MergeFrom and
+// Clone leave the other digest alone
+func TestDigestMerge(t *testing.T) {
+	low, high := NewDigest(), NewDigest()
+	for i := range 5000 {
+		low.Add(float64(i), 1)
+		high.Add(float64(5000+i), 1)
+	}
+	before := high.Quantile(0.5)
+
+	merged := low.Clone()
+	merged.MergeFrom(high)
+	if got := merged.Quantile(0.5); math.Abs(got-5000) > 100 {
+		t.Errorf("median of the merged digest is %v, want about 5000", got)
+	}
+	if merged.Quantile(0) != 0 || merged.Quantile(1) != 9999 {
+		t.Errorf("merged digest spans %v to %v, want 0 to 9999", merged.Quantile(0), merged.Quantile(1))
+	}
+	if got := high.Quantile(0.5); got != before {
+		t.Errorf("median of the merged-in digest moved from %v to %v", before, got)
+	}
+	if got := low.Quantile(1); got != 4999 {
+		t.Errorf("cloned digest tops out at %v after the merge, want 4999", got)
+	}
+}
+
+func TestDigestEmpty(t *testing.T) {
+	if got := NewDigest().Quantile(0.5); !math.IsNaN(got) {
+		t.Errorf("empty digest estimates %v, want NaN", got)
+	}
+	single := NewDigest()
+	single.Add(12.5, 3)
+	if got := single.Quantile(0.9); got != 12.5 {
+		t.Errorf("digest of one reading estimates %v, want 12.5", got)
+	}
+}
@@ -0,0 +1,61 @@
+package stats
+
+import (
+	"math"
+	"math/bits"
+)
+
+// Number of hash bits picking a register, 2^14 registers give a standard error of about 0.8%
+const hllPrecision = 14
+
+// HyperLogLog sketch estimating the number of distinct names in a fixed 16 KiB, whatever their number
+type HyperLogLog struct {
+	registers [1 << hllPrecision]uint8
+}
+
+// Function to create an empty sketch
+func NewHyperLogLog() *HyperLogLog {
+	return &HyperLogLog{}
+}
+
+// Function to add the hash of a name to the sketch
+func (h *HyperLogLog) Add(hash uint64) {
+	// FNV leaves the high bits poorly mixed for short names, so scramble them first (murmur3 fmix64)
+	hash ^= hash >> 33
+	hash *= 0xff51afd7ed558ccd
+	hash ^= hash >> 33
+	hash *= 0xc4ceb9fe1a85ec53
+	hash ^= hash >> 33
+
+	// The top bits pick the register, the run of zeros in the rest is the observation
+	index := hash >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(hash<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	h.registers[index] = max(h.registers[index], rank)
+}
+
+// Function to fold another sketch into this one
+func (h *HyperLogLog) Merge(other *HyperLogLog) {
+	for i, rank := range other.registers {
+		h.registers[i] = max(h.registers[i], rank)
+	}
+}
+
+// Function to estimate the number of distinct names added, switching to linear counting for small sets
+func (h *HyperLogLog) Estimate() uint64 {
+	m := float64(len(h.registers))
+	sum := 0.0
+	zeros := 0
+	for _, rank := range h.registers {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(math.Round(estimate))
+}
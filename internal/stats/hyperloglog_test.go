@@ -0,0 +1,44 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// Checks the estimates from a few names, counted linearly, up to a million, within three
+// standard errors of the sketch
+func TestHyperLogLogEstimate(t *testing.T) {
+	if got := NewHyperLogLog().Estimate(); got != 0 {
+		t.Errorf("empty sketch estimates %d names", got)
+	}
+
+	h := NewHyperLogLog()
+	added := 0
+	for _, n := range []int{10, 1000, 10_000, 100_000, 1_000_000} {
+		for ; added < n; added++ {
+			h.Add(HashName(fmt.Sprint("station ", added)))
+		}
+		if got := h.Estimate(); math.Abs(float64(got)-float64(n))/float64(n) > 0.025 {
+			t.Errorf("estimated %d distinct names after adding %d", got, n)
+		}
+	}
+}
+
+// Checks that adding a name again and merging overlapping sketches do not count names twice
+func TestHyperLogLogMerge(t *testing.T) {
+	a, b := NewHyperLogLog(), NewHyperLogLog()
+	for i := range 20_000 {
+		a.Add(HashName(fmt.Sprint("station ", i)))
+		a.Add(HashName(fmt.Sprint("station ", i)))
+		b.Add(HashName(fmt.Sprint("station ", 10_000+i)))
+	}
+	only := b.Estimate()
+	a.Merge(b)
+	if got := a.Estimate(); math.Abs(float64(got)-30_000)/30_000 > 0.025 {
+		t.Errorf("merged sketch estimates %d names, want about 30000", got)
+	}
+	if got := b.Estimate(); got != only {
+		t.Errorf("merged-in sketch went from %d to %d names", only, got)
+	}
+}
@@ -0,0 +1,460 @@
+package stats
+
+import (
+	"iter"
+	"math"
+	"math/big"
+	"math/bits"
+	"slices"
+	"sync"
+)
+
+// Number of slots a table starts with, enough for the 10,000 stations of 1BRC below half load
+const InitialTableCapacity = 1 << 15
+
+// Number of shards Set.Merge splits the merged stats into by a hash of the full name, a power of two
+const (
+	shardBits  = 4
+	ShardCount = 1 << shardBits
+)
+
+// Struct to select what the tables compute for every name; the count is always kept
+type Options struct {
+	Min, Max        bool    // Keep the lowest and the highest reading
+	Sum             bool    // Keep the sum of the readings, for the mean and the sum
+	Spread          bool    // Keep the sum and the sum of the squared readings, for the variance
+	Percentiles     bool    // Feed the readings to a Digest per name
+	HistogramBounds []int16 // Ascending bucket boundaries in tenths, each the inclusive lower end of the bucket above it; nil keeps no histogram
+	Distinct        bool    // Only add the names to a HyperLogLog sketch instead of keeping their stats
+	RowsOnly        bool    // Only count the rows, keeping nothing per name
+}
+
+// Struct to hold the stats of one name, in integer tenths of a degree so summing billions of
+// readings does not accumulate floating point rounding error. The sums are held in two limbs,
+// High*2^64 + Low, so heavily weighted or extreme readings overflowing an int64 stay exact; the
+// high limbs stay zero while the sums fit the int64 of the low ones
+type NameStats struct {
+	Min, Max       int16
+	Sum            int64
+	SumHigh        int64 // Multiples of 2^64 the sum holds beyond Sum
+	SumSquares     int64 // Sum of the squared readings, for the variance
+	SumSquaresHigh int64 // Multiples of 2^64 the sum of the squares holds beyond SumSquares
+	Count          int
+	Digest         *Digest // Sketch of the readings with Options.Percentiles, nil otherwise
+	Histogram      []int64 // Readings per bucket of Options.HistogramBounds, nil otherwise
+}
+
+// Struct to hold one slot of a Table
+type entry struct {
+	hash  uint64
+	name  []byte // Copy of the name, nil while the slot is empty
+	stats NameStats
+}
+
+// Open-addressing hash table with linear probing holding the stats of one worker, keyed by
+// the raw name bytes; lookups never allocate and only a newly seen name is copied
+type Table struct {
+	options  Options
+	entries  []entry // Power-of-two number of slots
+	size     int
+	Rows     int64        // Rows folded in, for the summary
+	distinct *HyperLogLog // Sketch the names go into instead of the slots with Options.Distinct
+}
+
+// Function to create an empty table computing what options select
+func NewTable(options Options) *Table {
+	return NewTableSized(options, InitialTableCapacity)
+}
+
+// Function to create an empty table computing what options select that starts with room for
+// slots entries, rounded up to a power of two, for tables that each hold a fraction of the names
+func NewTableSized(options Options, slots int) *Table {
+	t := &Table{options: options, entries: make([]entry, 1<<bits.Len(uint(max(slots, 2)-1)))}
+	if options.Distinct {
+		t.distinct = NewHyperLogLog()
+	}
+	return t
+}
+
+// Function to compute the 64-bit FNV-1a hash of a name
+func HashName(name string) uint64 {
+	hash := uint64(14695981039346656037)
+	for i := 0; i < len(name); i++ {
+		hash ^= uint64(name[i])
+		hash *= 1099511628211
+	}
+	return hash
+}
+
+// Function to pick the shard of a name in the shards of Set.Merge
+func ShardOf(name string) int {
+	return shardIndex(HashName(name))
+}
+
+// Function to pick the shard of a name from the top bits of its hash, the table slots use the low bits
+func shardIndex(hash uint64) int {
+	return int(hash >> (64 - shardBits))
+}
+
+// Function to update the stats for a name, counting the reading in tenths weight times
+func (t *Table) Update(name string, tenths int16, weight int) {
+	t.Rows++
+	if t.options.RowsOnly {
+		return
+	}
+	if t.distinct != nil {
+		t.distinct.Add(HashName(name))
+		return
+	}
+
+	// Only compute what the options ask for, the count is always kept
+	other := NameStats{Count: weight}
+	if t.options.Min {
+		other.Min = tenths
+	}
+	if t.options.Max {
+		other.Max = tenths
+	}
+	if t.options.Sum || t.options.Spread {
+		other.Sum, other.SumHigh = mulWide(int64(tenths), weight)
+	}
+	if t.options.Spread {
+		other.SumSquares, other.SumSquaresHigh = mulWide(int64(tenths)*int64(tenths), weight)
+	}
+	merged := t.mergeHashed(HashName(name), name, other)
+
+	// Feed the reading to the digest of the name when percentiles are requested
+	if t.options.Percentiles {
+		if merged.Digest == nil {
+			merged.Digest = NewDigest()
+		}
+		merged.Digest.Add(float64(tenths), weight)
+	}
+
+	// Count the reading in its bucket when a histogram is requested
+	if t.options.HistogramBounds != nil {
+		if merged.Histogram == nil {
+			merged.Histogram = make([]int64, len(t.options.HistogramBounds)+1)
+		}
+		merged.Histogram[t.options.bucket(tenths)] += int64(weight)
+	}
+}
+
+// Function to find the histogram bucket of a reading in tenths
+func (o *Options) bucket(tenths int16) int {
+	bucket, _ := slices.BinarySearchFunc(o.HistogramBounds, tenths, func(bound, t int16) int {
+		if bound <= t {
+			return -1
+		}
+		return 1
+	})
+	return bucket
+}
+
+// Function to fold already aggregated stats for a name into the table
+func (t *Table) Merge(name string, other NameStats) {
+	t.mergeHashed(HashName(name), name, other)
+}
+
+// Function to fold every name, the rows and the distinct sketch of another table into this
+// one, leaving the other table as it was
+func (t *Table) MergeTable(other *Table) {
+	for _, e := range other.entries {
+		if e.name != nil {
+			t.mergeHashed(e.hash, string(e.name), e.stats)
+		}
+	}
+	t.Rows += other.Rows
+	if other.distinct != nil {
+		if t.distinct == nil {
+			t.distinct = NewHyperLogLog()
+		}
+		t.distinct.Merge(other.distinct)
+	}
+}
+
+// Function to estimate the number of distinct names of a table with Options.Distinct, or to
+// count them otherwise
+func (t *Table) EstimateDistinct() uint64 {
+	if t.distinct != nil {
+		return t.distinct.Estimate()
+	}
+	return uint64(t.size)
+}
+
+// Function to fold stats into the table for a name whose hash is already known, returning
+// the stats stored for the name
+func (t *Table) mergeHashed(hash uint64, name string, other NameStats) *NameStats {
+	mask := uint64(len(t.entries) - 1)
+	for i := hash & mask; ; i = (i + 1) & mask {
+		e := &t.entries[i]
+		if e.name == nil {
+			// If the name doesn't exist yet, take a copy of the stats over that shares nothing with other
+			return t.insert(hash, name, other.Clone())
+		}
+		if e.hash == hash && string(e.name) == name {
+			e.stats = e.stats.Combine(other)
+			return &e.stats
+		}
+	}
+}
+
+// Function to store a name that is not in the table yet, doubling the table once it is half full
+func (t *Table) insert(hash uint64, name string, stats NameStats) *NameStats {
+	if 2*(t.size+1) > len(t.entries) {
+		t.grow()
+	}
+	t.size++
+	return t.place(entry{hash: hash, name: []byte(name), stats: stats})
+}
+
+// Function to put an entry into the first empty slot of its probe sequence, returning its stats
+func (t *Table) place(e entry) *NameStats {
+	mask := uint64(len(t.entries) - 1)
+	i := e.hash & mask
+	for t.entries[i].name != nil {
+		i = (i + 1) & mask
+	}
+	t.entries[i] = e
+	return &t.entries[i].stats
+}
+
+// Function to double the number of slots, placing every entry again by its stored hash
+func (t *Table) grow() {
+	old := t.entries
+	t.entries = make([]entry, 2*len(old))
+	for _, e := range old {
+		if e.name != nil {
+			t.place(e)
+		}
+	}
+}
+
+// Function to return the number of names in the table
+func (t *Table) Len() int {
+	return t.size
+}
+
+// Function to iterate over the names and stats in the table, in no particular order
+func (t *Table) All() iter.Seq2[string, NameStats] {
+	return func(yield func(string, NameStats) bool) {
+		for _, e := range t.entries {
+			if e.name != nil && !yield(string(e.name), e.stats) {
+				return
+			}
+		}
+	}
+}
+
+// Function to combine two sets of stats for the same name
+func (s NameStats) Combine(other NameStats) NameStats {
+	// Update the min, max, sum, and count based on the other stats
+	s.Min = min(s.Min, other.Min)
+	s.Max = max(s.Max, other.Max)
+	s.Sum, s.SumHigh = addWide(s.Sum, s.SumHigh, other.Sum, other.SumHigh)
+	s.SumSquares, s.SumSquaresHigh = addWide(s.SumSquares, s.SumSquaresHigh, other.SumSquares, other.SumSquaresHigh)
+	s.Count += other.Count
+
+	// Sketches are merged into copies owned by s, those of other stay with its table
+	if other.Digest != nil {
+		if s.Digest == nil {
+			s.Digest = other.Digest.Clone()
+		} else {
+			s.Digest.MergeFrom(other.Digest)
+		}
+	}
+	if other.Histogram != nil {
+		if s.Histogram == nil {
+			s.Histogram = slices.Clone(other.Histogram)
+		} else {
+			for i, count := range other.Histogram {
+				s.Histogram[i] += count
+			}
+		}
+	}
+	return s
+}
+
+// Function to copy the stats without sharing their digest or histogram
+func (s NameStats) Clone() NameStats {
+	if s.Digest != nil {
+		s.Digest = s.Digest.Clone()
+	}
+	s.Histogram = slices.Clone(s.Histogram)
+	return s
+}
+
+// Function to add two sums held as high*2^64 + low, carrying into the high limb when the low
+// ones overflow an int64
+func addWide(low, high, otherLow, otherHigh int64) (int64, int64) {
+	sum := low + otherLow
+	switch {
+	case low >= 0 && otherLow >= 0 && sum < 0:
+		high++
+	case low < 0 && otherLow < 0 && sum >= 0:
+		high--
+	}
+	return sum, high + otherHigh
+}
+
+// Function to multiply a term of the sums by a positive weight into a sum held as high*2^64 + low
+func mulWide(term int64, weight int) (int64, int64) {
+	magnitude := uint64(term)
+	if term < 0 {
+		magnitude = -magnitude
+	}
+	hi, lo := bits.Mul64(magnitude, uint64(weight))
+
+	// A low limb above the int64 range is 2^64 too large, so it borrows from the high limb
+	low, high := int64(lo), int64(hi)
+	if low < 0 {
+		high++
+	}
+	if term >= 0 {
+		return low, high
+	}
+	if low == math.MinInt64 {
+		// Negating -2^63 overflows, -(h*2^64 - 2^63) is (1-h)*2^64 - 2^63
+		return low, 1 - high
+	}
+	return -low, -high
+}
+
+// Function to return the exact sum of the readings in tenths
+func (s NameStats) BigSum() *big.Int {
+	return wideInt(s.Sum, s.SumHigh)
+}
+
+// Function to return the exact sum of the squared readings in squared tenths
+func (s NameStats) BigSumSquares() *big.Int {
+	return wideInt(s.SumSquares, s.SumSquaresHigh)
+}
+
+// Function to return the sum of the readings in tenths as the nearest float64
+func (s NameStats) FloatSum() float64 {
+	return float64(s.SumHigh)*0x1p64 + float64(s.Sum)
+}
+
+// Function to return the mean of the readings in tenths as the nearest float64
+func (s NameStats) Mean() float64 {
+	if s.SumHigh == 0 {
+		return float64(s.Sum) / float64(s.Count)
+	}
+	mean, _ := new(big.Rat).SetFrac(s.BigSum(), big.NewInt(int64(s.Count))).Float64()
+	return mean
+}
+
+// Function to turn a sum held as high*2^64 + low into a big integer
+func wideInt(low, high int64) *big.Int {
+	n := big.NewInt(high)
+	n.Lsh(n, 64)
+	return n.Add(n, big.NewInt(low))
+}
+
+// Function to compute the population variance of the readings, in squared degrees
+func (s NameStats) Variance() float64 {
+	n := float64(s.Count)
+	mean := s.Mean()
+	variance := (float64(s.SumSquaresHigh)*0x1p64+float64(s.SumSquares))/n - mean*mean
+
+	// Rounding can take the difference of two nearly equal terms just below zero
+	return max(variance, 0) / 100
+}
+
+// Struct to hold the tables the workers of a run aggregate into on their own without any
+// locking, merged into shards by name once they are done
+type Set struct {
+	options Options
+	mutex   sync.Mutex // Guards tables and idle
+	tables  []*Table
+	idle    []*Table // Tables of finished workers, handed to the next ones instead of new tables
+}
+
+// Function to create an empty set whose tables compute what options select
+func NewSet(options Options) *Set {
+	return &Set{options: options}
+}
+
+// Function to hand a new worker a private table registered for the merge, one a finished worker
+// released when there is one, so reading many small inputs one after another keeps few tables
+func (s *Set) NewTable() *Table {
+	s.mutex.Lock()
+	if n := len(s.idle); n > 0 {
+		t := s.idle[n-1]
+		s.idle = s.idle[:n-1]
+		s.mutex.Unlock()
+		return t
+	}
+	s.mutex.Unlock()
+
+	t := NewTable(s.options)
+	s.Add(t)
+	return t
+}
+
+// Function to hand back a table of the set once its worker no longer writes to it, keeping what
+// it aggregated for the merge
+func (s *Set) Release(t *Table) {
+	s.mutex.Lock()
+	s.idle = append(s.idle, t)
+	s.mutex.Unlock()
+}
+
+// Function to register a table filled elsewhere for the merge, it must compute what the options
+// of the set select
+func (s *Set) Add(t *Table) {
+	s.mutex.Lock()
+	s.tables = append(s.tables, t)
+	s.mutex.Unlock()
+}
+
+// Function to combine the tables of all workers into hash shards, merging the shards in parallel;
+// the workers must be done, or kept from writing as during a checkpoint
+func (s *Set) Merge() [ShardCount]*Table {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var shards [ShardCount]*Table
+	var wg sync.WaitGroup
+	for shard := range shards {
+		shards[shard] = NewTable(Options{})
+		wg.Go(func() {
+			for _, t := range s.tables {
+				for _, e := range t.entries {
+					if e.name != nil && shardIndex(e.hash) == shard {
+						shards[shard].mergeHashed(e.hash, string(e.name), e.stats)
+					}
+				}
+			}
+		})
+	}
+	wg.Wait()
+	return shards
+}
+
+// Function to count the rows folded into the tables of all workers
+func (s *Set) Rows() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var rows int64
+	for _, t := range s.tables {
+		rows += t.Rows
+	}
+	return rows
+}
+
+// Function to estimate the number of distinct names from the combined sketches of all workers
+// of a set with Options.Distinct
+func (s *Set) EstimateDistinct() uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	merged := NewHyperLogLog()
+	for _, t := range s.tables {
+		if t.distinct != nil {
+			merged.Merge(t.distinct)
+		}
+	}
+	return merged.Estimate()
+}
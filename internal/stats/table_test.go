@@ -0,0 +1,367 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Options computing every stat, as -aggs with all of them and -stddev
+var allStats = Options{Min: true, Max: true, Sum: true, Spread: true}
+
+// Checks that the table keeps every name apart and findable while it doubles past its initial capacity
+func TestTableGrowth(t *testing.T) {
+	table := NewTable(allStats)
+	names := 3 * InitialTableCapacity
+	for round := range 2 {
+		for i := range names {
+			table.Merge(fmt.Sprintf("station %d", i), NameStats{Min: int16(i % 1000), Max: int16(i % 1000), Sum: int64(i), Count: 1 + round})
+		}
+	}
+
+	if table.Len() != names || len(table.entries) < 2*names {
+		t.Fatalf("table holds %d names in %d slots, want %d names below half load", table.Len(), len(table.entries), names)
+	}
+	seen := 0
+	for name, stats := range table.All() {
+		var i int
+		if _, err := fmt.Sscanf(name, "station %d", &i); err != nil {
+			t.Fatalf("table holds unexpected name %q", name)
+		}
+		if stats.Count != 3 || stats.Sum != 2*int64(i) || stats.Min != int16(i%1000) {
+			t.Errorf("%q has count %d and sum %d, want 3 and %d", name, stats.Count, stats.Sum, 2*i)
+		}
+		seen++
+	}
+	if seen != names {
+		t.Errorf("All yielded %d names, want %d", seen, names)
+	}
+}
+
+// Checks that sized tables start with a power of two of slots and still grow
+func TestNewTableSized(t *testing.T) {
+	for _, test := range []struct{ slots, want int }{{0, 2}, {1, 2}, {2, 2}, {3, 4}, {2048, 2048}, {2049, 4096}} {
+		if got := len(NewTableSized(allStats, test.slots).entries); got != test.want {
+			t.Errorf("NewTableSized(%d) has %d slots, want %d", test.slots, got, test.want)
+		}
+	}
+	table := NewTableSized(allStats, 1)
+	for i := range 100 {
+		table.Update(fmt.Sprintf("station %d", i), 10, 1)
+	}
+	if table.Len() != 100 || len(table.entries) != 256 {
+		t.Errorf("table holds %d names in %d slots, want 100 in 256", table.Len(), len(table.entries))
+	}
+}
+
+// Checks that names sharing a hash probe to their own slots, also across a growth that places
+// them again, and that the stats of one never land on another
+func TestTableCollisions(t *testing.T) {
+	table := NewTable(allStats)
+	const collisions = 100
+	for range 2 {
+		for i := range collisions {
+			table.mergeHashed(42, fmt.Sprint("name ", i), NameStats{Sum: int64(i), Count: 1})
+		}
+	}
+
+	// Names whose hash lands on the last slot wrap around to the first ones
+	last := uint64(len(table.entries) - 1)
+	table.mergeHashed(last, "wrapped a", NameStats{Count: 1})
+	table.mergeHashed(last, "wrapped b", NameStats{Count: 1})
+	if string(table.entries[0].name) != "wrapped b" {
+		t.Errorf("second name hashing to the last slot went to %q, want the first slot", table.entries[0].name)
+	}
+
+	table.grow()
+	for i := range collisions {
+		stats := table.mergeHashed(42, fmt.Sprint("name ", i), NameStats{})
+		if stats.Count != 2 || stats.Sum != 2*int64(i) {
+			t.Errorf("name %d has count %d and sum %d after growing, want 2 and %d", i, stats.Count, stats.Sum, 2*i)
+		}
+	}
+	if table.Len() != collisions+2 {
+		t.Errorf("table holds %d names, want %d", table.Len(), collisions+2)
+	}
+}
+
+// Checks that long names and names differing only in their last byte or a prefix stay apart, and
+// that the table keeps its own copy of the name bytes
+func TestTableNames(t *testing.T) {
+	table := NewTable(allStats)
+	long := strings.Repeat("x", 100_000)
+	names := []string{long, long[:len(long)-1] + "y", long[:len(long)-1], "", "a", "a\x00", "\xff\xfe"}
+	for i, name := range names {
+		table.Merge(name, NameStats{Sum: int64(i), Count: 1})
+	}
+
+	buffer := []byte("Hamburg")
+	table.Merge(string(buffer), NameStats{Count: 1})
+	copy(buffer, "Overwri")
+
+	got := map[string]NameStats{}
+	for name, stats := range table.All() {
+		got[name] = stats
+	}
+	if len(got) != len(names)+1 {
+		t.Errorf("table holds %d names, want %d", len(got), len(names)+1)
+	}
+	for i, name := range names {
+		if stats, ok := got[name]; !ok || stats.Sum != int64(i) {
+			t.Errorf("name %d (%d bytes) has sum %d, %v, want %d", i, len(name), stats.Sum, ok, i)
+		}
+	}
+	if _, ok := got["Hamburg"]; !ok {
+		t.Error("name lost after its source bytes were overwritten")
+	}
+}
+
+// Checks that Update only computes what the options select, and that a digest or histogram
+// merged into another table stays with its source
+func TestTableUpdate(t *testing.T) {
+	options := allStats
+	options.Percentiles = true
+	options.HistogramBounds = []int16{0, 100}
+	source := NewTable(options)
+	for _, tenths := range []int16{120, -34, 5} {
+		source.Update("Hamburg", tenths, 2)
+	}
+	target := NewTable(options)
+	for name, stats := range source.All() {
+		target.Merge(name, stats)
+	}
+	target.Update("Hamburg", 999, 1)
+
+	stats := lookup(t, target, "Hamburg")
+	if stats.Min != -34 || stats.Max != 999 || stats.Sum != 2*(120-34+5)+999 || stats.Count != 7 {
+		t.Errorf("Hamburg has min %d, max %d, sum %d, count %d", stats.Min, stats.Max, stats.Sum, stats.Count)
+	}
+	if fmt.Sprint(stats.Histogram) != "[2 2 3]" {
+		t.Errorf("Hamburg has histogram %v, want [2 2 3]", stats.Histogram)
+	}
+	original := lookup(t, source, "Hamburg")
+	if top := original.Digest.Quantile(1); top != 120 {
+		t.Errorf("source digest tops out at %v after the merge, want 120", top)
+	}
+	if fmt.Sprint(original.Histogram) != "[2 2 2]" {
+		t.Errorf("source histogram is %v after the merge, want [2 2 2]", original.Histogram)
+	}
+	if source.Rows != 3 || target.Rows != 1 {
+		t.Errorf("tables counted %d and %d rows, want 3 and 1", source.Rows, target.Rows)
+	}
+
+	// Only the count is kept without any other option, only the rows with RowsOnly
+	counts := NewTable(Options{})
+	counts.Update("Hamburg", 120, 3)
+	if got := lookup(t, counts, "Hamburg"); fmt.Sprintf("%+v", got) != fmt.Sprintf("%+v", NameStats{Count: 3}) {
+		t.Errorf("count-only table holds %+v", got)
+	}
+	rows := NewTable(Options{RowsOnly: true, Min: true})
+	rows.Update("Hamburg", 120, 1)
+	if rows.Len() != 0 || rows.Rows != 1 {
+		t.Errorf("rows-only table holds %d names and %d rows, want 0 and 1", rows.Len(), rows.Rows)
+	}
+}
+
+// Function to find the stats of a name in a table, failing the test when it is missing
+func lookup(t *testing.T, table *Table, name string) NameStats {
+	t.Helper()
+	for n, stats := range table.All() {
+		if n == name {
+			return stats
+		}
+	}
+	t.Fatalf("table has no %q", name)
+	return NameStats{}
+}
+
+// Checks that sums overflowing an int64 carry into their high limbs and stay exact, through
+// weighted updates, merges and sums climbing past the int64 range and falling back into it
+func TestWideSum(t *testing.T) {
+	const weight = 1_000_000_000_000_000
+	table := NewTable(allStats)
+	other := NewTable(allStats)
+	want, wantSquares := new(big.Int), new(big.Int)
+	for i, tenths := range []int16{9999, 9999, 9999, 9999, 9999, 9999, 9999, 9999, 9999, 9999, 32767, -32768, -9999, -9999, -9999, -9999, -9999} {
+		into := table
+		if i%3 == 0 {
+			into = other
+		}
+		into.Update("Hamburg", tenths, weight)
+		want.Add(want, new(big.Int).Mul(big.NewInt(int64(tenths)), big.NewInt(weight)))
+		wantSquares.Add(wantSquares, new(big.Int).Mul(big.NewInt(int64(tenths)*int64(tenths)), big.NewInt(weight)))
+	}
+	table.MergeTable(other)
+
+	stats := lookup(t, table, "Hamburg")
+	if got := stats.BigSum(); got.Cmp(want) != 0 || stats.SumHigh == 0 {
+		t.Errorf("sum is %v with high limb %d, want %v beyond an int64", got, stats.SumHigh, want)
+	}
+	if got := stats.BigSumSquares(); got.Cmp(wantSquares) != 0 {
+		t.Errorf("sum of squares is %v, want %v", got, wantSquares)
+	}
+	wantMean, _ := new(big.Rat).SetFrac(want, big.NewInt(17*weight)).Float64()
+	if got := stats.Mean(); got != wantMean {
+		t.Errorf("mean is %v tenths, want %v", got, wantMean)
+	}
+
+	// A sum climbing past the int64 range and back into it ends with no high limb
+	back := NewTable(allStats)
+	for _, tenths := range []int16{9999, 9999, 9999, 9999, 9999, 9999, 9999, 9999, 9999, 9999, -9999, -9999, -9999, -9999, -9999, -9999, -9999, -9999, -9999, -9999} {
+		back.Update("Bulawayo", tenths, weight)
+	}
+	back.Update("Bulawayo", 123, 1)
+	if s := lookup(t, back, "Bulawayo"); s.Sum != 123 || s.SumHigh != 0 {
+		t.Errorf("sum back in range is %d with high limb %d, want 123 and 0", s.Sum, s.SumHigh)
+	}
+}
+
+// Checks the carries of the two-limb sums against big integers at the edges of the int64 range
+func TestWideArithmetic(t *testing.T) {
+	for _, term := range []int64{0, 1, -1, 9999, -9999, 32768 * 32768, math.MinInt16} {
+		for _, weight := range []int{1, 2, 1 << 32, math.MaxInt64 / 2, math.MaxInt64} {
+			low, high := mulWide(term, weight)
+			want := new(big.Int).Mul(big.NewInt(term), big.NewInt(int64(weight)))
+			if got := wideInt(low, high); got.Cmp(want) != 0 {
+				t.Errorf("%d * %d gives %v, want %v", term, weight, got, want)
+			}
+		}
+	}
+	edges := []int64{0, 1, -1, math.MaxInt64, math.MinInt64, math.MaxInt64 - 1, math.MinInt64 + 1}
+	for _, low := range edges {
+		for _, otherLow := range edges {
+			for _, highs := range [][2]int64{{0, 0}, {1, -1}, {-3, 2}} {
+				gotLow, gotHigh := addWide(low, highs[0], otherLow, highs[1])
+				want := new(big.Int).Add(wideInt(low, highs[0]), wideInt(otherLow, highs[1]))
+				if got := wideInt(gotLow, gotHigh); got.Cmp(want) != 0 {
+					t.Errorf("(%d, %d) + (%d, %d) gives %v, want %v", low, highs[0], otherLow, highs[1], got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestVariance(t *testing.T) {
+	table := NewTable(allStats)
+	for _, tenths := range []int16{20, 40, 40, 40, 50, 50, 70, 90} {
+		table.Update("a", tenths, 1)
+	}
+	table.Update("b", 123, 5)
+	if got := lookup(t, table, "a").Variance(); math.Abs(got-4) > 1e-9 {
+		t.Errorf("variance of a is %v, want 4", got)
+	}
+	if got := lookup(t, table, "b").Variance(); got != 0 {
+		t.Errorf("variance of a single reading is %v, want 0", got)
+	}
+}
+
+// Checks that the tables filled by concurrent workers merge into shards holding every name once,
+// in the shard ShardOf picks, with the rows of all workers
+func TestSetMerge(t *testing.T) {
+	set := NewSet(allStats)
+	const workers, names = 8, 5000
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Go(func() {
+			table := set.NewTable()
+			for i := range names {
+				table.Update(fmt.Sprint("station ", i), int16(w), 1)
+			}
+		})
+	}
+	wg.Wait()
+
+	shards := set.Merge()
+	total := 0
+	for shard, table := range shards {
+		for name, stats := range table.All() {
+			if ShardOf(name) != shard {
+				t.Errorf("%q is in shard %d, want %d", name, shard, ShardOf(name))
+			}
+			if stats.Count != workers || stats.Min != 0 || stats.Max != workers-1 || stats.Sum != workers*(workers-1)/2 {
+				t.Errorf("%q has %+v", name, stats)
+			}
+		}
+		total += table.Len()
+	}
+	if total != names {
+		t.Errorf("shards hold %d names, want %d", total, names)
+	}
+	if rows := set.Rows(); rows != workers*names {
+		t.Errorf("set counted %d rows, want %d", rows, workers*names)
+	}
+}
+
+// Checks that released tables are handed to the next workers, still counted once in the merge
+func TestSetRelease(t *testing.T) {
+	set := NewSet(allStats)
+	first := set.NewTable()
+	first.Update("Hamburg", 120, 1)
+	second := set.NewTable()
+	set.Release(first)
+	if reused := set.NewTable(); reused != first {
+		t.Error("NewTable did not reuse the released table")
+	}
+	first.Update("Hamburg", -30, 1)
+	second.Update("Bulawayo", 89, 1)
+	if fresh := set.NewTable(); fresh == first || fresh == second {
+		t.Error("NewTable handed out a table still in use")
+	}
+
+	shards := set.Merge()
+	hamburg := shards[ShardOf("Hamburg")]
+	for name, s := range hamburg.All() {
+		if name == "Hamburg" && (s.Count != 2 || s.Min != -30 || s.Max != 120) {
+			t.Errorf("Hamburg = %+v, want 2 readings from -30 to 120", s)
+		}
+	}
+	if rows := set.Rows(); rows != 3 {
+		t.Errorf("set counted %d rows, want 3", rows)
+	}
+}
+
+func TestSetEstimateDistinct(t *testing.T) {
+	set := NewSet(Options{Distinct: true})
+	const names = 50_000
+	for w := range 4 {
+		table := set.NewTable()
+		for i := w; i < names; i += 2 {
+			table.Update(fmt.Sprint("station ", i), 0, 1)
+		}
+		if table.Len() != 0 {
+			t.Errorf("distinct table keeps %d names, want none", table.Len())
+		}
+	}
+	if got := set.EstimateDistinct(); math.Abs(float64(got)-names)/names > 0.03 {
+		t.Errorf("estimated %d distinct names, want about %d", got, names)
+	}
+}
+
+// Checks that merging whole tables folds the names, the rows and the distinct sketches in,
+// leaving the merged-in table as it was
+func TestMergeTable(t *testing.T) {
+	a, b := NewTable(allStats), NewTable(allStats)
+	a.Update("Hamburg", 120, 1)
+	b.Update("Hamburg", -34, 2)
+	b.Update("Bulawayo", 89, 1)
+	a.MergeTable(b)
+	if got := lookup(t, a, "Hamburg"); got.Min != -34 || got.Max != 120 || got.Count != 3 || a.Rows != 3 || a.EstimateDistinct() != 2 {
+		t.Errorf("merged table has Hamburg %+v, %d rows and %d names", got, a.Rows, a.EstimateDistinct())
+	}
+	if got := lookup(t, b, "Hamburg"); got.Count != 2 || b.Len() != 2 || b.Rows != 2 {
+		t.Errorf("merged-in table changed to Hamburg %+v with %d names and %d rows", got, b.Len(), b.Rows)
+	}
+
+	low, high := NewTable(Options{Distinct: true}), NewTable(Options{Distinct: true})
+	for i := range 1000 {
+		low.Update(fmt.Sprint("station ", i), 0, 1)
+		high.Update(fmt.Sprint("station ", 500+i), 0, 1)
+	}
+	low.MergeTable(high)
+	if got := low.EstimateDistinct(); math.Abs(float64(got)-1500) > 50 {
+		t.Errorf("merged sketches estimate %d names, want about 1500", got)
+	}
+}
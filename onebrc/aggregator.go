@@ -0,0 +1,140 @@
+package onebrc
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+
+	"example.com/mod/internal/parse"
+	"example.com/mod/internal/stats"
+)
+
+// Interface of what the workers of Process fold the readings into. Every worker adds to an
+// aggregator of its own, so Add needs no locking; once the input is read the aggregators are
+// merged into the first one, which gives the Results
+type Aggregator interface {
+	// Function to add a reading in degrees for a name; name is only valid during the call and
+	// must not be written to
+	Add(name []byte, value float64)
+	// Function to fold another aggregator made by the same registered constructor into this one
+	Merge(other Aggregator)
+	// Function to return the stations and counts aggregated so far
+	Results() Results
+}
+
+// Interface of aggregators that fold a row standing for several readings (Columns.Weight) in one
+// call; Process adds the reading weight times to the other aggregators
+type WeightedAggregator interface {
+	Aggregator
+	// Function to add a reading in degrees weight times for a name, valid as for Add
+	AddWeighted(name []byte, value float64, weight int)
+}
+
+// Name of the aggregator Process uses when Options.Aggregator is empty
+const DefaultAggregator = "stats"
+
+// Constructors of the registered aggregators by name, and the mutex guarding them
+var (
+	aggregators      = map[string]func() Aggregator{}
+	aggregatorsMutex sync.RWMutex
+)
+
+func init() {
+	Register(DefaultAggregator, tableAggregators(stats.Options{Min: true, Max: true, Sum: true}))
+	Register("count", tableAggregators(stats.Options{}))
+	Register("sum", tableAggregators(stats.Options{Sum: true}))
+	Register("distinct", tableAggregators(stats.Options{Distinct: true}))
+}
+
+// Function to register a constructor of aggregators under name, for Options.Aggregator and the
+// -aggregator flag of the 1brc command; registering a name again replaces its constructor
+func Register(name string, newAggregator func() Aggregator) {
+	aggregatorsMutex.Lock()
+	defer aggregatorsMutex.Unlock()
+	aggregators[name] = newAggregator
+}
+
+// Function to list the names of the registered aggregators, sorted
+func Aggregators() []string {
+	aggregatorsMutex.RLock()
+	defer aggregatorsMutex.RUnlock()
+	return slices.Sorted(maps.Keys(aggregators))
+}
+
+// Function to look up the constructor of a registered aggregator
+func lookupAggregator(name string) (func() Aggregator, error) {
+	if name == "" {
+		name = DefaultAggregator
+	}
+	aggregatorsMutex.RLock()
+	defer aggregatorsMutex.RUnlock()
+	newAggregator, ok := aggregators[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown aggregator %q, registered are %s", name, strings.Join(slices.Sorted(maps.Keys(aggregators)), ", "))
+	}
+	return newAggregator, nil
+}
+
+// Aggregator folding the readings into a stats table, as the 1brc command does. The built-in
+// aggregators only differ in what the options of their table keep: stats the min, max, sum and
+// count, count the count alone, sum the sum and count (enough for the mean) and distinct nothing
+// but a HyperLogLog sketch of the names, in a fixed 16 KiB whatever their number
+type tableAggregator struct {
+	options stats.Options
+	table   *stats.Table
+}
+
+// Function to make a constructor of aggregators whose tables keep what options select
+func tableAggregators(options stats.Options) func() Aggregator {
+	return func() Aggregator {
+		return &tableAggregator{options: options, table: stats.NewTable(options)}
+	}
+}
+
+// Function to fold a reading into the stats of its station
+func (a *tableAggregator) Add(name []byte, value float64) {
+	a.AddWeighted(name, value, 1)
+}
+
+// Function to fold a reading into the stats of its station weight times; Process only passes
+// readings that are exact tenths, so rounding gives them back
+func (a *tableAggregator) AddWeighted(name []byte, value float64, weight int) {
+	a.table.Update(parse.ByteString(name), int16(math.Round(value*10)), weight)
+}
+
+// Function to fold the table of another aggregator with the same options into this one, copying
+// what it holds so both can keep adding
+func (a *tableAggregator) Merge(other Aggregator) {
+	a.table.MergeTable(other.(*tableAggregator).table)
+}
+
+// Function to return the stations sorted by name with the fields the options keep, the others
+// left zero
+func (a *tableAggregator) Results() Results {
+	if a.options.Distinct {
+		return Results{Distinct: a.table.EstimateDistinct()}
+	}
+
+	results := Results{Stations: make([]Station, 0, a.table.Len()), Distinct: uint64(a.table.Len())}
+	for name, s := range a.table.All() {
+		station := Station{Name: name, Count: int64(s.Count)}
+		if a.options.Min {
+			station.Min = float64(s.Min) / 10
+		}
+		if a.options.Max {
+			station.Max = float64(s.Max) / 10
+		}
+		if a.options.Sum {
+			station.Mean = s.Mean() / 10
+			station.Sum = s.FloatSum() / 10
+		}
+		results.Stations = append(results.Stations, station)
+	}
+	slices.SortFunc(results.Stations, func(a, b Station) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return results
+}
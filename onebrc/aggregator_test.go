@@ -0,0 +1,34 @@
+package onebrc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeKeepsAggregatorsApart(t *testing.T) {
+	for _, name := range []string{"stats", "sum"} {
+		newAggregator, err := lookupAggregator(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		into, other := newAggregator(), newAggregator()
+		into.Add([]byte("Hamburg"), 1)
+		other.Add([]byte("Bulawayo"), 2)
+		into.Merge(other)
+
+		other.Add([]byte("Bulawayo"), 4)
+		into.Add([]byte("Bulawayo"), -6)
+
+		got := into.Results().Stations
+		if got[0].Name != "Bulawayo" || got[0].Count != 2 || got[0].Sum != -4 {
+			t.Errorf("%s: merged Bulawayo = %+v, want 2 readings summing to -4", name, got[0])
+		}
+		want := other.Results().Stations
+		if len(want) != 1 || want[0].Count != 2 || want[0].Sum != 6 {
+			t.Errorf("%s: merged-from Bulawayo = %+v, want 2 readings summing to 6", name, want)
+		}
+		if name == "stats" && !reflect.DeepEqual([]float64{got[0].Min, got[0].Max, want[0].Min, want[0].Max}, []float64{-6, 2, 2, 4}) {
+			t.Errorf("stats: min and max = %v/%v and %v/%v, want -6/2 and 2/4", got[0].Min, got[0].Max, want[0].Min, want[0].Max)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package onebrc
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"example.com/mod/internal/output"
+)
+
+// Format the stations are written in by WriteResultsSorted
+type Format string
+
+const (
+	FormatOfficial Format = "official" // "{Abha=-23.0/18.0/59.2, ...}" with min/mean/max to one decimal, as the 1BRC reference prints
+	FormatCSV      Format = "csv"      // A station,min,max,mean,count,sum header and a record per station, to two decimals
+	FormatJSON     Format = "json"     // An array of {"station", "min", "max", "mean", "count", "sum"} objects
+)
+
+// Struct to hold a station as FormatJSON writes it
+type stationJSON struct {
+	Station string  `json:"station"`
+	Min     float64 `json:"min"`
+	Max     float64 `json:"max"`
+	Mean    float64 `json:"mean"`
+	Count   int64   `json:"count"`
+	Sum     float64 `json:"sum"`
+}
+
+// Function to order stations by a stat as the -sort and -by flags of the 1brc command do: name
+// ascending, avg, max and count highest first or min lowest first, ties broken by name
+func StationOrder(stat string) (func(a, b Station) bool, error) {
+	var compare func(a, b Station) int
+	switch stat {
+	case "name":
+		compare = func(a, b Station) int { return 0 }
+	case "min":
+		compare = func(a, b Station) int { return cmp.Compare(a.Min, b.Min) }
+	case "max":
+		compare = func(a, b Station) int { return cmp.Compare(b.Max, a.Max) }
+	case "count":
+		compare = func(a, b Station) int { return cmp.Compare(b.Count, a.Count) }
+	case "avg":
+		compare = func(a, b Station) int { return cmp.Compare(b.Mean, a.Mean) }
+	default:
+		return nil, fmt.Errorf("unknown order %q, want name, avg, max, min or count", stat)
+	}
+	return func(a, b Station) bool {
+		if c := compare(a, b); c != 0 {
+			return c < 0
+		}
+		return a.Name < b.Name
+	}, nil
+}
+
+// Function to sort stations in place by less, keeping the order of those less ranks equal
+func SortStations(stations []Station, less func(a, b Station) bool) {
+	slices.SortStableFunc(stations, func(a, b Station) int {
+		switch {
+		case less(a, b):
+			return -1
+		case less(b, a):
+			return 1
+		default:
+			return 0
+		}
+	})
+}
+
+// Function to write stations in format f ordered by less, leaving the stations as they are; a
+// nil less keeps their order, by name for the Results of Process
+func WriteResultsSorted(w io.Writer, stations []Station, less func(a, b Station) bool, f Format) error {
+	stations = slices.Clone(stations)
+	if less != nil {
+		SortStations(stations, less)
+	}
+
+	var b bytes.Buffer
+	switch f {
+	case FormatOfficial:
+		b.WriteString("{")
+		for i, s := range stations {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			fmt.Fprintf(&b, "%s=%s/%s/%s", s.Name, officialTenths(s.Min), officialMean(s), officialTenths(s.Max))
+		}
+		b.WriteString("}\n")
+	case FormatCSV:
+		writer := csv.NewWriter(&b)
+		writer.Write([]string{"station", "min", "max", "mean", "count", "sum"})
+		for _, s := range stations {
+			writer.Write([]string{s.Name, output.FormatValue(s.Min, 2), output.FormatValue(s.Max, 2), output.FormatValue(s.Mean, 2),
+				strconv.FormatInt(s.Count, 10), output.FormatValue(s.Sum, 2)})
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return err
+		}
+	case FormatJSON:
+		objects := make([]stationJSON, len(stations))
+		for i, s := range stations {
+			objects[i] = stationJSON{Station: s.Name, Min: s.Min, Max: s.Max, Mean: s.Mean, Count: s.Count, Sum: s.Sum}
+		}
+		encoded, err := json.MarshalIndent(objects, "", "  ")
+		if err != nil {
+			return err
+		}
+		b.Write(encoded)
+		b.WriteString("\n")
+	default:
+		return fmt.Errorf("unknown format %q, want %s", f, strings.Join([]string{string(FormatOfficial), string(FormatCSV), string(FormatJSON)}, ", "))
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// Function to round a reading to one decimal half up for FormatOfficial, going through its tenths
+// as the 1brc command does
+func officialTenths(value float64) string {
+	return output.RoundHalfUp(int64(math.Round(value*10)), 10, 1)
+}
+
+// Function to round the mean of a station to one decimal half up for FormatOfficial, from the
+// exact sum of its tenths; stations without a count fall back to their mean
+func officialMean(s Station) string {
+	if s.Count <= 0 {
+		return output.FormatValue(s.Mean, 1)
+	}
+	return output.RoundHalfUp(int64(math.Round(s.Sum*10)), 10*s.Count, 1)
+}
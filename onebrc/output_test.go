@@ -0,0 +1,110 @@
+package onebrc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Stations of the output tests, sorted by name as Process returns them
+var outputStations = []Station{
+	{Name: "Bulawayo", Min: 8.9, Max: 8.9, Mean: 8.9, Sum: 8.9, Count: 1},
+	{Name: "Hamburg", Min: -3.4, Max: 12, Mean: 4.3, Sum: 8.6, Count: 2},
+	{Name: "Palembang", Min: 30.1, Max: 38.8, Mean: 34.45, Sum: 68.9, Count: 2},
+	{Name: "St. John's", Min: -20.3, Max: 15.2, Mean: -2.55, Sum: -5.1, Count: 2},
+}
+
+// Checks that a custom comparator, the temperature range widest first, orders every format and
+// leaves the stations passed in as they were
+func TestWriteResultsSorted(t *testing.T) {
+	byRange := func(a, b Station) bool { return a.Max-a.Min > b.Max-b.Min }
+	tests := []struct {
+		format Format
+		want   string
+	}{
+		{FormatOfficial, "{St. John's=-20.3/-2.5/15.2, Hamburg=-3.4/4.3/12.0, Palembang=30.1/34.5/38.8, Bulawayo=8.9/8.9/8.9}\n"},
+		{FormatCSV, "station,min,max,mean,count,sum\nSt. John's,-20.30,15.20,-2.55,2,-5.10\nHamburg,-3.40,12.00,4.30,2,8.60\n" +
+			"Palembang,30.10,38.80,34.45,2,68.90\nBulawayo,8.90,8.90,8.90,1,8.90\n"},
+		{FormatJSON, `[
+  {
+    "station": "St. John's",
+    "min": -20.3,
+    "max": 15.2,
+    "mean": -2.55,
+    "count": 2,
+    "sum": -5.1
+  },
+  {
+    "station": "Hamburg",
+    "min": -3.4,
+    "max": 12,
+    "mean": 4.3,
+    "count": 2,
+    "sum": 8.6
+  },
+  {
+    "station": "Palembang",
+    "min": 30.1,
+    "max": 38.8,
+    "mean": 34.45,
+    "count": 2,
+    "sum": 68.9
+  },
+  {
+    "station": "Bulawayo",
+    "min": 8.9,
+    "max": 8.9,
+    "mean": 8.9,
+    "count": 1,
+    "sum": 8.9
+  }
+]
+`},
+	}
+	for _, test := range tests {
+		var b bytes.Buffer
+		if err := WriteResultsSorted(&b, outputStations, byRange, test.format); err != nil || b.String() != test.want {
+			t.Errorf("%s: WriteResultsSorted = %v and wrote\n%s\nwant\n%s", test.format, err, b.String(), test.want)
+		}
+	}
+	if outputStations[0].Name != "Bulawayo" {
+		t.Errorf("WriteResultsSorted reordered the stations passed in: %v", outputStations)
+	}
+
+	var b bytes.Buffer
+	if err := WriteResultsSorted(&b, outputStations, nil, FormatOfficial); err != nil || !strings.HasPrefix(b.String(), "{Bulawayo=") {
+		t.Errorf("WriteResultsSorted without a comparator = %v and wrote %s, want the order passed in", err, b.String())
+	}
+	if err := WriteResultsSorted(&b, outputStations, nil, "yaml"); err == nil || !strings.Contains(err.Error(), `unknown format "yaml"`) {
+		t.Errorf("WriteResultsSorted accepted format yaml: %v", err)
+	}
+}
+
+// Checks the built-in orders of the command, ties broken by name
+func TestStationOrder(t *testing.T) {
+	tests := []struct{ stat, want string }{
+		{"name", "Bulawayo,Hamburg,Palembang,St. John's"},
+		{"min", "St. John's,Hamburg,Bulawayo,Palembang"},
+		{"max", "Palembang,St. John's,Hamburg,Bulawayo"},
+		{"avg", "Palembang,Bulawayo,Hamburg,St. John's"},
+		{"count", "Hamburg,Palembang,St. John's,Bulawayo"},
+	}
+	for _, test := range tests {
+		less, err := StationOrder(test.stat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stations := append([]Station(nil), outputStations...)
+		SortStations(stations, less)
+		var names []string
+		for _, s := range stations {
+			names = append(names, s.Name)
+		}
+		if got := strings.Join(names, ","); got != test.want {
+			t.Errorf("StationOrder(%q) sorted %s, want %s", test.stat, got, test.want)
+		}
+	}
+	if _, err := StationOrder("median"); err == nil {
+		t.Error("StationOrder accepted median")
+	}
+}
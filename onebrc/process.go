@@ -0,0 +1,365 @@
+// Package onebrc aggregates 1BRC-style "name;temperature" lines from any io.Reader, so other Go
+// programs can embed the aggregator (over network streams or in-memory data) instead of running
+// the 1brc command, which reads its streamed inputs through it as well
+package onebrc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"example.com/mod/internal/codec"
+	"example.com/mod/internal/parse"
+	"example.com/mod/internal/reader"
+)
+
+// Struct to hold the settings of Process, the zero value reads plain 1BRC input
+type Options struct {
+	Delimiter     string            // Separator of the fields, ";" when empty; "auto" detects ';', ',' or tab from the first data line
+	Quoted        bool              // Split RFC 4180 records whose "double-quoted" fields may hold the delimiter
+	Format        string            // "jsonl" reads one JSON object per line, "text" (the default) delimited lines
+	NameField     string            // Field of a jsonl object holding the name, "station" when empty
+	ValueField    string            // Field of a jsonl object holding the reading, "temp" when empty
+	Columns       *Columns          // Columns of the delimited lines, a name and a reading when nil
+	Unit          string            // Unit of the readings, C (the default), F or K, converted to Celsius
+	Aliases       map[string]string // Canonical names replacing the aliased names of the input
+	Range         *Range            // Band of plausible readings, nil accepts every reading
+	Workers       int               // Goroutines parsing and aggregating the lines, runtime.NumCPU() when zero
+	BatchLines    int               // Lines handed to a worker at a time, 1000 when zero
+	BufferSize    int               // Size of the blocks the input is read in, 4 MiB when zero
+	MaxLineLength int               // Longest accepted line in bytes, 1 MiB when zero
+	SkipLines     int               // Leading header or comment lines to skip
+	Strict        bool              // Fail on the first malformed line instead of counting and skipping it
+	PlainText     bool              // Read the input as UTF-8 text as it is, without detecting compression or byte order marks
+	Aggregator    string            // Registered aggregator the readings are folded into, DefaultAggregator when empty
+	NewAggregator func() Aggregator // Constructor of the aggregators of the workers, used instead of Aggregator when set
+
+	// Function called from the workers for every malformed line starting at offset, instead of
+	// failing it with Strict; a non-nil error stops Process with that error
+	OnMalformed func(line []byte, offset int64, err error) error
+}
+
+// Struct to select the columns of the delimited lines, counted from 0
+type Columns struct {
+	Group  []int // Columns forming the key of a row, joined by "/"; the first one holds the name
+	Value  int   // Column holding the reading
+	Weight int   // Column holding how many readings a row stands for, -1 when every row is one reading
+}
+
+// Struct to hold a band of plausible readings in degrees Celsius
+type Range struct {
+	Min, Max float64
+	Keep     bool // Aggregate the readings outside the band as well, only counting them
+}
+
+// Struct to hold the aggregated readings of one station, in degrees
+type Station struct {
+	Name           string
+	Min, Max, Mean float64
+	Sum            float64
+	Count          int64
+}
+
+// Struct to hold what Process aggregated; which fields of the stations are set depends on the aggregator
+type Results struct {
+	Stations   []Station // Sorted by name, none for aggregators that only count distinct names
+	Distinct   uint64    // Number of distinct stations, an estimate for the distinct aggregator
+	Rows       int64     // Lines aggregated
+	Malformed  int64     // Lines skipped as malformed
+	OutOfRange int64     // Readings outside Options.Range, weighted
+	Remapped   int64     // Rows whose name Options.Aliases replaced
+	Delimiter  string    // Separator the lines were split by, the detected one with "auto"
+}
+
+// Error wrapped by Process for a malformed line with Options.Strict
+var ErrMalformed = errors.New("malformed line")
+
+// Struct to hold a batch of lines copied out of the read buffer, with the input offset of each
+type batch struct {
+	data    []byte
+	ends    []int
+	offsets []int64
+}
+
+// Struct to hold the aggregator of one worker and the rows it went through
+type table struct {
+	aggregator Aggregator
+	weighted   WeightedAggregator // The aggregator when it takes weights itself, nil otherwise
+	rows       int64
+	malformed  int64
+}
+
+// Function to read r line by line, aggregating the readings of every station on opts.Workers
+// goroutines into the aggregator named by opts.Aggregator. When ctx is cancelled the readers and
+// workers stop within a few lines and the results aggregated so far are returned with the error of ctx
+func Process(ctx context.Context, r io.Reader, opts Options) (Results, error) {
+	opts = opts.withDefaults()
+	newAggregator := opts.NewAggregator
+	if newAggregator == nil {
+		var err error
+		if newAggregator, err = lookupAggregator(opts.Aggregator); err != nil {
+			return Results{}, err
+		}
+	}
+	parser, err := opts.parser()
+	if err != nil {
+		return Results{}, err
+	}
+
+	// Decompress and transcode the input unless the caller already did
+	if !opts.PlainText {
+		decoded, err := codec.Open(r, "input", opts.Workers)
+		if err != nil {
+			return Results{}, err
+		}
+		defer decoded.Close()
+		r = decoded
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	// Batches go to the workers and come back to be filled again, bounding the memory in flight
+	batches := make(chan *batch, opts.Workers)
+	free := make(chan *batch, 2*opts.Workers)
+	tables := make([]*table, opts.Workers)
+	for i := range tables {
+		tables[i] = &table{aggregator: newAggregator()}
+		tables[i].weighted, _ = tables[i].aggregator.(WeightedAggregator)
+	}
+	var wg sync.WaitGroup
+	for _, t := range tables {
+		wg.Go(func() {
+			if err := t.consume(ctx, batches, free, parser, opts); err != nil {
+				cancel(err)
+			}
+		})
+	}
+
+	err = readBatches(ctx, r, parser, opts, batches, free)
+	close(batches)
+	wg.Wait()
+	if cause := context.Cause(ctx); cause != nil {
+		err = cause
+	}
+
+	results := mergeTables(tables)
+	if parser.Range != nil {
+		results.OutOfRange = parser.Range.Violations.Load()
+	}
+	results.Remapped = parser.Remapped.Load()
+	results.Delimiter = parser.Delimiter
+	return results, err
+}
+
+// Function to fill in the defaults of the options left zero
+func (o Options) withDefaults() Options {
+	if o.Workers <= 0 {
+		o.Workers = runtime.NumCPU()
+	}
+	if o.BatchLines <= 0 {
+		o.BatchLines = 1000
+	}
+	if o.BufferSize <= 0 {
+		o.BufferSize = 4 << 20
+	}
+	if o.MaxLineLength <= 0 {
+		o.MaxLineLength = 1 << 20
+	}
+	return o
+}
+
+// Function to build the parser of the lines the options describe, rejecting invalid combinations
+func (o Options) parser() (*parse.Parser, error) {
+	p := parse.NewParser()
+	if o.Delimiter != "" {
+		p.Delimiter = o.Delimiter
+	}
+	if o.Quoted && p.Delimiter != "auto" && utf8.RuneCountInString(p.Delimiter) != 1 {
+		return nil, fmt.Errorf("quoted fields need a single-character delimiter, not %q", p.Delimiter)
+	}
+	p.Quoted = o.Quoted
+
+	switch o.Format {
+	case "", "text":
+	case "jsonl":
+		p.Format = o.Format
+	default:
+		return nil, fmt.Errorf("unknown format %q, want text or jsonl", o.Format)
+	}
+	if o.NameField != "" {
+		p.NameField = o.NameField
+	}
+	if o.ValueField != "" {
+		p.ValueField = o.ValueField
+	}
+
+	if c := o.Columns; c != nil {
+		if len(c.Group) == 0 || slices.ContainsFunc(c.Group, func(col int) bool { return col < 0 }) {
+			return nil, fmt.Errorf("invalid key columns %v", c.Group)
+		}
+		p.GroupColumns, p.ValueColumn, p.WeightColumn = c.Group, c.Value, c.Weight
+		if err := p.CheckColumns(); err != nil {
+			return nil, err
+		}
+	}
+
+	if o.Unit != "" {
+		p.Unit = strings.ToUpper(o.Unit)
+	}
+	if p.Unit != "C" && p.Unit != "F" && p.Unit != "K" {
+		return nil, fmt.Errorf("unknown unit %q, want C, F or K", o.Unit)
+	}
+	p.Aliases = o.Aliases
+
+	if r := o.Range; r != nil {
+		if r.Min > r.Max {
+			return nil, fmt.Errorf("range minimum %v is above its maximum %v", r.Min, r.Max)
+		}
+		p.Range = &parse.Range{Min: r.Min, Max: r.Max, Keep: r.Keep}
+	}
+	return p, nil
+}
+
+// Function to read the input into batches of lines for the workers until the end of the input,
+// an error or the cancellation of ctx
+func readBatches(ctx context.Context, r io.Reader, parser *parse.Parser, opts Options, batches chan<- *batch, free chan *batch) error {
+	lines, consumed := reader.NewLineReader(r, 0, opts.BufferSize, opts.MaxLineLength)
+	for i := 0; i < opts.SkipLines; i++ {
+		if !lines.Scan() {
+			if err := lines.Err(); err != nil {
+				return fmt.Errorf("skipping lines: %w", err)
+			}
+			return fmt.Errorf("input has only %d lines, fewer than the %d to skip", i, opts.SkipLines)
+		}
+	}
+
+	b := nextBatch(free)
+	for first := true; ; first = false {
+		offset := *consumed
+		if !lines.Scan() {
+			break
+		}
+
+		// Sniff the delimiter from the first data line before any batch reaches the workers
+		if first && parser.Delimiter == "auto" && parser.Format != "jsonl" {
+			detected, err := parser.DetectDelimiter(string(lines.Bytes()))
+			if err != nil {
+				return err
+			}
+			parser.Delimiter = detected
+		}
+
+		b.data = append(b.data, lines.Bytes()...)
+		b.ends = append(b.ends, len(b.data))
+		b.offsets = append(b.offsets, offset)
+		if len(b.ends) < opts.BatchLines {
+			continue
+		}
+		select {
+		case batches <- b:
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+		b = nextBatch(free)
+	}
+	if err := lines.Err(); err != nil {
+		return fmt.Errorf("reading input: %w", err)
+	}
+	if len(b.ends) > 0 {
+		select {
+		case batches <- b:
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+	}
+	return nil
+}
+
+// Function to take an emptied batch back from the workers, or a new one when none is free
+func nextBatch(free chan *batch) *batch {
+	select {
+	case b := <-free:
+		b.data, b.ends, b.offsets = b.data[:0], b.ends[:0], b.offsets[:0]
+		return b
+	default:
+		return &batch{}
+	}
+}
+
+// Function to fold the lines of every batch into the table, checking ctx between lines so a
+// cancelled run stops without finishing its batch
+func (t *table) consume(ctx context.Context, batches <-chan *batch, free chan<- *batch, parser *parse.Parser, opts Options) error {
+	for b := range batches {
+		start := 0
+		for i, end := range b.ends {
+			if i%256 == 0 && ctx.Err() != nil {
+				return nil
+			}
+			if err := t.add(b.data[start:end], b.offsets[i], parser, opts); err != nil {
+				return err
+			}
+			start = end
+		}
+		select {
+		case free <- b:
+		default:
+		}
+	}
+	return nil
+}
+
+// Function to parse a line starting at offset and fold its reading into the table. A malformed
+// line is counted and handed to Options.OnMalformed, or failed with Options.Strict
+func (t *table) add(line []byte, offset int64, parser *parse.Parser, opts Options) error {
+	// The line stays in the batch: parsing only slices it and the aggregator copies what it keeps
+	name, tenths, weight, err := parser.ParseLine(parse.ByteString(line))
+	if err != nil {
+		t.malformed++
+		if opts.OnMalformed != nil {
+			return opts.OnMalformed(line, offset, err)
+		}
+		if opts.Strict {
+			return fmt.Errorf("%w at offset %d: %w", ErrMalformed, offset, err)
+		}
+		return nil
+	}
+	if !parser.Range.Accept(tenths, weight) {
+		return nil
+	}
+
+	t.rows++
+	value := float64(tenths) / 10
+	if t.weighted != nil {
+		t.weighted.AddWeighted(parse.StringBytes(name), value, weight)
+		return nil
+	}
+
+	// Aggregators without weights see a weighted row as that many readings
+	for range weight {
+		t.aggregator.Add(parse.StringBytes(name), value)
+	}
+	return nil
+}
+
+// Function to merge the aggregators of all workers into the first one and take its results
+func mergeTables(tables []*table) Results {
+	merged := tables[0].aggregator
+	for _, t := range tables[1:] {
+		merged.Merge(t.aggregator)
+	}
+
+	results := merged.Results()
+	results.Rows, results.Malformed = 0, 0
+	for _, t := range tables {
+		results.Rows += t.rows
+		results.Malformed += t.malformed
+	}
+	return results
+}
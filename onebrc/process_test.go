@@ -0,0 +1,240 @@
+package onebrc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+
+	"example.com/mod/internal/reader"
+)
+
+func TestProcess(t *testing.T) {
+	input := "\ufeffHamburg;12.0\r\nBulawayo;8.9\nHamburg;-3.45\nnot a row\nİzmir;17\n;1.0\nBulawayo;-8.9"
+	want := Results{
+		Stations: []Station{
+			{Name: "Bulawayo", Min: -8.9, Max: 8.9, Mean: 0, Sum: 0, Count: 2},
+			{Name: "Hamburg", Min: -3.5, Max: 12, Mean: 4.25, Sum: 8.5, Count: 2},
+			{Name: "İzmir", Min: 17, Max: 17, Mean: 17, Sum: 17, Count: 1},
+		},
+		Distinct:  3,
+		Rows:      5,
+		Malformed: 2,
+		Delimiter: ";",
+	}
+
+	for _, opts := range []Options{{}, {Workers: 3, BatchLines: 1, BufferSize: 8}} {
+		got, err := Process(context.Background(), strings.NewReader(input), opts)
+		if err != nil {
+			t.Fatalf("Process with %+v: %v", opts, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Process with %+v = %+v, want %+v", opts, got, want)
+		}
+	}
+}
+
+// Checks that a reading cut between two reads of the input is aggregated once with its whole value
+func TestProcessSplitReads(t *testing.T) {
+	input := "Hamburg;12.3\nBulawayo;-8.9\nHamburg;1.5\n"
+	for _, r := range []io.Reader{iotest.OneByteReader(strings.NewReader(input)), iotest.HalfReader(strings.NewReader(input))} {
+		got, err := Process(context.Background(), r, Options{Workers: 2, BatchLines: 1, BufferSize: 4})
+		want := []Station{
+			{Name: "Bulawayo", Min: -8.9, Max: -8.9, Mean: -8.9, Sum: -8.9, Count: 1},
+			{Name: "Hamburg", Min: 1.5, Max: 12.3, Mean: 6.9, Sum: 13.8, Count: 2},
+		}
+		if err != nil || !reflect.DeepEqual(got.Stations, want) || got.Rows != 3 {
+			t.Errorf("Process = %+v, %v, want %+v in 3 rows", got, err, want)
+		}
+	}
+}
+
+func TestProcessStrict(t *testing.T) {
+	_, err := Process(context.Background(), strings.NewReader("a;1.0\nb;x\n"), Options{Strict: true})
+	if !errors.Is(err, ErrMalformed) || !strings.Contains(err.Error(), "offset 6") {
+		t.Errorf("Process = %v, want ErrMalformed at offset 6", err)
+	}
+}
+
+func TestProcessSkipLinesError(t *testing.T) {
+	input := strings.Repeat("x", 64) + "\nHamburg;12.0\n"
+	_, err := Process(context.Background(), strings.NewReader(input), Options{SkipLines: 1, BufferSize: 16, MaxLineLength: 32})
+	if !errors.Is(err, reader.ErrLineTooLong) || !strings.Contains(err.Error(), "skipping lines") {
+		t.Errorf("Process = %v, want ErrLineTooLong while skipping lines", err)
+	}
+	if _, err := Process(context.Background(), strings.NewReader("header\n"), Options{SkipLines: 2}); err == nil || !strings.Contains(err.Error(), "only 1 lines") {
+		t.Errorf("Process of a short input = %v, want an error for the lines it lacks", err)
+	}
+}
+
+// Checks the options the 1brc command reads its streams with: columns and weights, units,
+// aliases, the range, jsonl, a detected delimiter and compressed input
+func TestProcessOptions(t *testing.T) {
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	gw.Write([]byte("Hamburg,12.0\nBulawayo,8.9\n"))
+	gw.Close()
+
+	tests := []struct {
+		name  string
+		input io.Reader
+		opts  Options
+		want  Results
+	}{
+		{"columns", strings.NewReader("indoor|21.5|Hamburg|3\noutdoor|5|Hamburg|1\nindoor|x|Hamburg|1\n"),
+			Options{Delimiter: "|", Columns: &Columns{Group: []int{2, 0}, Value: 1, Weight: 3}, Aggregator: "count"},
+			Results{Stations: []Station{{Name: "Hamburg/indoor", Count: 3}, {Name: "Hamburg/outdoor", Count: 1}}, Distinct: 2, Rows: 2, Malformed: 1, Delimiter: "|"}},
+		{"unit, aliases and range", strings.NewReader("NYC;50\nNew York;212\nNYC;68\n"),
+			Options{Unit: "f", Aliases: map[string]string{"NYC": "New York"}, Range: &Range{Min: -50, Max: 50}},
+			Results{Stations: []Station{{Name: "New York", Min: 10, Max: 20, Mean: 15, Sum: 30, Count: 2}}, Distinct: 1, Rows: 2, OutOfRange: 1, Remapped: 2, Delimiter: ";"}},
+		{"flagged range", strings.NewReader("Hamburg;99\n"), Options{Range: &Range{Min: -50, Max: 50, Keep: true}, Aggregator: "count"},
+			Results{Stations: []Station{{Name: "Hamburg", Count: 1}}, Distinct: 1, Rows: 1, OutOfRange: 1, Delimiter: ";"}},
+		{"jsonl", strings.NewReader(`{"name": "Hamburg", "t": "12.5"}` + "\n" + `{"name": "Hamburg"}` + "\n"),
+			Options{Format: "jsonl", NameField: "name", ValueField: "t", Aggregator: "sum"},
+			Results{Stations: []Station{{Name: "Hamburg", Mean: 12.5, Sum: 12.5, Count: 1}}, Distinct: 1, Rows: 1, Malformed: 1, Delimiter: ";"}},
+		{"gzip with a detected delimiter", &gzipped, Options{Delimiter: "auto", Aggregator: "count"},
+			Results{Stations: []Station{{Name: "Bulawayo", Count: 1}, {Name: "Hamburg", Count: 1}}, Distinct: 2, Rows: 2, Delimiter: ","}},
+	}
+	for _, test := range tests {
+		got, err := Process(context.Background(), test.input, test.opts)
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: Process = %+v, %v, want %+v", test.name, got, err, test.want)
+		}
+	}
+
+	for _, opts := range []Options{
+		{Format: "csv"}, {Unit: "R"}, {Quoted: true, Delimiter: "::"}, {Range: &Range{Min: 1, Max: 0}},
+		{Columns: &Columns{Value: 1, Weight: -1}}, {Columns: &Columns{Group: []int{0}, Value: 0, Weight: -1}},
+	} {
+		if _, err := Process(context.Background(), strings.NewReader("Hamburg;1\n"), opts); err == nil {
+			t.Errorf("Process with %+v did not fail", opts)
+		}
+	}
+}
+
+// Checks that OnMalformed sees every malformed line with its offset and stops Process with its error
+func TestProcessOnMalformed(t *testing.T) {
+	var mutex sync.Mutex
+	var seen []string
+	stop := errors.New("stop")
+	_, err := Process(context.Background(), strings.NewReader("a;1\nb;x\nc;2\n;3\n"), Options{
+		Workers: 2, BatchLines: 1,
+		OnMalformed: func(line []byte, offset int64, err error) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			seen = append(seen, fmt.Sprintf("%d:%s", offset, line))
+			return nil
+		},
+	})
+	slices.Sort(seen)
+	if err != nil || !reflect.DeepEqual(seen, []string{"12:;3", "4:b;x"}) {
+		t.Errorf("OnMalformed saw %q with %v, want the two malformed lines at offsets 4 and 12", seen, err)
+	}
+
+	_, err = Process(context.Background(), strings.NewReader("a;1\nb;x\nc;2\n"), Options{
+		OnMalformed: func([]byte, int64, error) error { return stop },
+	})
+	if err != stop {
+		t.Errorf("Process = %v, want the error OnMalformed returned", err)
+	}
+}
+
+func TestProcessCancelled(t *testing.T) {
+	var input strings.Builder
+	for i := range 100000 {
+		fmt.Fprintf(&input, "station %d;%d.5\n", i%100, i%50)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	got, err := Process(ctx, strings.NewReader(input.String()), Options{Workers: 2, BatchLines: 10})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Process = %v, want context.Canceled", err)
+	}
+	if got.Rows >= 100000 {
+		t.Errorf("Process of a cancelled context aggregated all %d rows", got.Rows)
+	}
+}
+
+// Aggregator keeping the longest name seen, to test registering a custom implementation
+type longestName struct {
+	name string
+}
+
+func (a *longestName) Add(name []byte, value float64) {
+	if len(name) > len(a.name) {
+		a.name = string(name)
+	}
+}
+
+func (a *longestName) Merge(other Aggregator) {
+	a.Add([]byte(other.(*longestName).name), 0)
+}
+
+func (a *longestName) Results() Results {
+	return Results{Stations: []Station{{Name: a.name}}, Distinct: 1}
+}
+
+// Aggregator counting how often it was called, to test how weighted rows reach aggregators without weights
+type calls struct {
+	count int64
+}
+
+func (a *calls) Add(name []byte, value float64) {
+	a.count++
+}
+
+func (a *calls) Merge(other Aggregator) {
+	a.count += other.(*calls).count
+}
+
+func (a *calls) Results() Results {
+	return Results{Stations: []Station{{Name: "calls", Count: a.count}}}
+}
+
+func TestProcessAggregators(t *testing.T) {
+	Register("longest", func() Aggregator { return &longestName{} })
+	input := "Hamburg;12.0\nBulawayo;8.9\nHamburg;-3.4\nx;1\n"
+
+	tests := []struct {
+		aggregator string
+		want       []Station
+		distinct   uint64
+	}{
+		{"count", []Station{{Name: "Bulawayo", Count: 1}, {Name: "Hamburg", Count: 2}, {Name: "x", Count: 1}}, 3},
+		{"sum", []Station{{Name: "Bulawayo", Mean: 8.9, Sum: 8.9, Count: 1}, {Name: "Hamburg", Mean: 4.3, Sum: 8.6, Count: 2}, {Name: "x", Mean: 1, Sum: 1, Count: 1}}, 3},
+		{"distinct", nil, 3},
+		{"longest", []Station{{Name: "Bulawayo"}}, 1},
+	}
+	for _, test := range tests {
+		got, err := Process(context.Background(), strings.NewReader(input), Options{Workers: 3, BatchLines: 1, Aggregator: test.aggregator})
+		if err != nil {
+			t.Fatalf("Process with %s: %v", test.aggregator, err)
+		}
+		if !reflect.DeepEqual(got.Stations, test.want) || got.Distinct != test.distinct || got.Rows != 4 {
+			t.Errorf("Process with %s = %+v, want stations %+v, %d distinct and 4 rows", test.aggregator, got, test.want, test.distinct)
+		}
+	}
+
+	if _, err := Process(context.Background(), strings.NewReader(input), Options{Aggregator: "missing"}); err == nil {
+		t.Error("Process with an unregistered aggregator did not fail")
+	}
+
+	// A weighted row is added as many times to an aggregator that does not take weights
+	weighted := "Hamburg;12.0;3\nBulawayo;8.9;2\n"
+	got, err := Process(context.Background(), strings.NewReader(weighted), Options{
+		Columns:       &Columns{Group: []int{0}, Value: 1, Weight: 2},
+		NewAggregator: func() Aggregator { return &calls{} },
+	})
+	if err != nil || got.Stations[0].Count != 5 || got.Rows != 2 {
+		t.Errorf("Process with an unweighted aggregator = %+v, %v, want 5 calls for 2 rows", got, err)
+	}
+}
@@ -0,0 +1,159 @@
+package onebrc
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+
+	"example.com/mod/internal/parse"
+	"example.com/mod/internal/stats"
+)
+
+// Aggregator keeping the min, max, sum and count of every station like the stats aggregator, but
+// safe for concurrent use: the stations are split into shards by a hash of their name, each
+// behind a mutex of its own, so goroutines folding in different stations rarely wait on each
+// other. Besides single readings it folds in stats aggregated elsewhere, such as by another
+// node, with MergeStats. Readings are kept in tenths of a degree like the tables of Process
+type ShardedAggregator struct {
+	shards [stats.ShardCount]aggregatorShard
+}
+
+// Struct to hold the stations of one shard of a ShardedAggregator and the mutex guarding them
+type aggregatorShard struct {
+	mutex sync.Mutex
+	table *stats.Table
+}
+
+// Function to create the table of one shard, the shards together starting as large as one table
+func newShardTable() *stats.Table {
+	return stats.NewTableSized(stats.Options{Min: true, Max: true, Sum: true}, stats.InitialTableCapacity/stats.ShardCount)
+}
+
+// Function to create an empty sharded aggregator. Returning the same one from
+// Options.NewAggregator has every worker of Process fold into it directly
+func NewShardedAggregator() *ShardedAggregator {
+	a := new(ShardedAggregator)
+	for i := range a.shards {
+		a.shards[i].table = newShardTable()
+	}
+	return a
+}
+
+// Function to add a reading in degrees for a name, rounded to tenths; name is only valid during
+// the call and must not be written to
+func (a *ShardedAggregator) Add(name []byte, value float64) {
+	a.AddWeighted(name, value, 1)
+}
+
+// Function to add a reading in degrees weight times for a name, valid as for Add
+func (a *ShardedAggregator) AddWeighted(name []byte, value float64, weight int) {
+	a.update(parse.ByteString(name), int16(math.Round(value*10)), weight)
+}
+
+// Function to fold a reading in tenths weight times into the shard of name, under its lock
+func (a *ShardedAggregator) update(name string, tenths int16, weight int) {
+	shard := &a.shards[stats.ShardOf(name)]
+	shard.mutex.Lock()
+	shard.table.Update(name, tenths, weight)
+	shard.mutex.Unlock()
+}
+
+// Function to add a single reading in degrees for a name, rounded to tenths, failing when it
+// does not fit the tenths of the stats (-3276.8 to 3276.7)
+func (a *ShardedAggregator) Update(name string, value float64) error {
+	tenths, err := toTenths(value)
+	if err != nil {
+		return err
+	}
+	a.update(name, tenths, 1)
+	return nil
+}
+
+// Function to fold the stats of a station aggregated elsewhere into those of name, as if their
+// readings had been added one by one; the mean and the name of s are not used. It fails when s
+// holds no readings or values that do not fit the tenths of the stats
+func (a *ShardedAggregator) MergeStats(name string, s Station) error {
+	if s.Count <= 0 {
+		return fmt.Errorf("stats of %s hold %d readings", name, s.Count)
+	}
+	if s.Min > s.Max {
+		return fmt.Errorf("stats of %s have min %v above max %v", name, s.Min, s.Max)
+	}
+	minTenths, err := toTenths(s.Min)
+	if err != nil {
+		return err
+	}
+	maxTenths, err := toTenths(s.Max)
+	if err != nil {
+		return err
+	}
+	sum := math.Round(s.Sum * 10)
+	if math.IsNaN(sum) || math.Abs(sum) >= math.MaxInt64 {
+		return fmt.Errorf("sum out of range: %v", s.Sum)
+	}
+
+	shard := &a.shards[stats.ShardOf(name)]
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	shard.table.Merge(name, stats.NameStats{Min: minTenths, Max: maxTenths, Sum: int64(sum), Count: int(s.Count)})
+	shard.table.Rows += s.Count
+	return nil
+}
+
+// Function to fold another sharded aggregator into this one, copying what it holds so both can
+// keep adding; merging an aggregator into itself does nothing
+func (a *ShardedAggregator) Merge(other Aggregator) {
+	from := other.(*ShardedAggregator)
+	if from == a {
+		return
+	}
+	for i := range a.shards {
+		// Copy the shard out first, so two aggregators merged into each other never hold both locks
+		copied := newShardTable()
+		from.shards[i].mutex.Lock()
+		copied.MergeTable(from.shards[i].table)
+		from.shards[i].mutex.Unlock()
+
+		a.shards[i].mutex.Lock()
+		a.shards[i].table.MergeTable(copied)
+		a.shards[i].mutex.Unlock()
+	}
+}
+
+// Function to return the stations aggregated so far sorted by name, with the number of readings
+// they hold as the rows
+func (a *ShardedAggregator) Results() Results {
+	var results Results
+	for i := range a.shards {
+		shard := &a.shards[i]
+		shard.mutex.Lock()
+		for name, s := range shard.table.All() {
+			results.Stations = append(results.Stations, Station{
+				Name:  name,
+				Min:   float64(s.Min) / 10,
+				Max:   float64(s.Max) / 10,
+				Mean:  s.Mean() / 10,
+				Sum:   s.FloatSum() / 10,
+				Count: int64(s.Count),
+			})
+		}
+		results.Rows += shard.table.Rows
+		shard.mutex.Unlock()
+	}
+	slices.SortFunc(results.Stations, func(a, b Station) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	results.Distinct = uint64(len(results.Stations))
+	return results
+}
+
+// Function to round a value in degrees to tenths, failing when it does not fit an int16
+func toTenths(value float64) (int16, error) {
+	tenths := math.Round(value * 10)
+	if math.IsNaN(tenths) || tenths < math.MinInt16 || tenths > math.MaxInt16 {
+		return 0, fmt.Errorf("value out of range: %v", value)
+	}
+	return int16(tenths), nil
+}
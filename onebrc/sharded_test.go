@@ -0,0 +1,86 @@
+package onebrc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Checks that partial stats merged concurrently with MergeStats and single readings added with
+// Update give the totals of all of them, whatever the interleaving
+func TestShardedMergeStats(t *testing.T) {
+	const nodes, stations, rounds = 8, 50, 20
+	a := NewShardedAggregator()
+	var wg sync.WaitGroup
+	for node := range nodes {
+		wg.Go(func() {
+			for round := range rounds {
+				for station := range stations {
+					name := fmt.Sprintf("Station %d", station)
+					// Every node reports 3 readings summing to 3 degrees, spanning -node to node+1
+					partial := Station{Min: float64(-node), Max: float64(node + 1), Sum: 3, Count: 3}
+					if err := a.MergeStats(name, partial); err != nil {
+						t.Error(err)
+					}
+					if round == 0 {
+						if err := a.Update(name, 0.5); err != nil {
+							t.Error(err)
+						}
+					}
+				}
+			}
+		})
+	}
+	wg.Wait()
+
+	results := a.Results()
+	if len(results.Stations) != stations || results.Rows != nodes*stations*(3*rounds+1) {
+		t.Fatalf("got %d stations and %d rows, want %d and %d", len(results.Stations), results.Rows, stations, nodes*stations*(3*rounds+1))
+	}
+	for _, s := range results.Stations {
+		want := Station{Name: s.Name, Min: -(nodes - 1), Max: nodes, Sum: nodes * (3*rounds + 0.5), Count: nodes * (3*rounds + 1)}
+		want.Mean = want.Sum * 10 / float64(want.Count) / 10 // In tenths, as Results computes it
+		if s != want {
+			t.Errorf("%s = %+v, want %+v", s.Name, s, want)
+		}
+	}
+	if results.Stations[0].Name != "Station 0" || results.Stations[1].Name != "Station 1" {
+		t.Errorf("stations are not sorted by name: %s, %s", results.Stations[0].Name, results.Stations[1].Name)
+	}
+
+	for _, invalid := range []Station{{Count: 0}, {Min: 2, Max: 1, Count: 1}, {Min: -4000, Max: 1, Count: 1}} {
+		if err := a.MergeStats("Hamburg", invalid); err == nil {
+			t.Errorf("MergeStats accepted %+v", invalid)
+		}
+	}
+	if err := a.Update("Hamburg", 3276.8); err == nil {
+		t.Error("Update accepted 3276.8")
+	}
+}
+
+// Checks that one sharded aggregator shared by the workers of Process holds every reading, and
+// that merging sharded aggregators into each other copies what they hold
+func TestShardedProcess(t *testing.T) {
+	a := NewShardedAggregator()
+	input := strings.Repeat("Hamburg;12.0\nBulawayo;-8.9\n", 500)
+	results, err := Process(context.Background(), bytes.NewReader([]byte(input)), Options{
+		Workers: 4, BatchLines: 7, NewAggregator: func() Aggregator { return a },
+	})
+	if err != nil || results.Rows != 1000 || len(results.Stations) != 2 || results.Stations[1].Count != 500 {
+		t.Fatalf("Process = %+v, %v, want 500 readings of each station", results, err)
+	}
+
+	other := NewShardedAggregator()
+	other.Update("Hamburg", 20)
+	other.Merge(a)
+	a.Merge(other)
+	if got := other.Results().Stations; got[1].Count != 501 || got[1].Max != 20 {
+		t.Errorf("merged Hamburg = %+v, want 501 readings up to 20", got[1])
+	}
+	if got := a.Results().Stations; got[1].Count != 1001 {
+		t.Errorf("Hamburg merged back = %+v, want 1001 readings", got[1])
+	}
+}